@@ -1,9 +1,18 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
 	"io/ioutil"
-	"log"
+	"net/http"
 	"os"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
 
 	"gopkg.in/yaml.v2"
 
@@ -11,49 +20,742 @@ import (
 	controllers "github.com/anyo/aws-node-group-manager/pkg/controllers"
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	log "github.com/sirupsen/logrus"
 )
 
 var region string
 var k8sVersion string
 var alwaysLatestAmi bool
+var apiMetrics = &controllers.APIMetrics{}
+
+const apiTokenEnv = "NODE_GROUP_MANAGER_API_TOKEN"
+
+// configEnv selects the overlay merged over the config, config.yaml is followed by config.{env}.yaml
+const configEnv = "NODE_GROUP_MANAGER_ENV"
 
 func main() {
 	region = "us-east-1"
 	k8sVersion = "1.14"
 
-	session := getAwsSession(region)
-	ssmSvc := controllers.SsmService{AwsSession: session, Region: region}
-	asgSvc := controllers.AsgService{AwsSession: session, Region: region}
-	ec2Svc := controllers.Ec2Service{AwsSession: session, Region: region}
+	// the first argument selects the command, apply is the default
+	command := "apply"
+	args := os.Args[1:]
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		command = args[0]
+		args = args[1:]
+	}
+
+	switch command {
+	case "apply":
+		runApply(args)
+	case "import":
+		runImport(args)
+	case "gc":
+		runGc(args)
+	case "rollback":
+		runRollback(args)
+	case "promote":
+		runPromote(args)
+	case "abort":
+		runAbort(args)
+	case "orphans":
+		runOrphans(args)
+	case "history":
+		runHistory(args)
+	case "tui":
+		runTui(args)
+	case "status":
+		runStatus(args)
+	case "validate":
+		runValidate(args)
+	case "operator":
+		runOperator(args)
+	case "export":
+		runExport(args)
+	default:
+		log.WithField("command", command).Fatal("Unknown command, expected one of: apply, import, gc, rollback, promote, abort, orphans, history, tui, status, validate, operator, export")
+	}
+}
+
+func runApply(args []string) {
+	flags := flag.NewFlagSet("apply", flag.ExitOnError)
+	configPath := flags.String("config", defaultConfigPath(), "path to the node group config file, overlays merged over it may follow separated by commas")
+	concurrency := flags.Int("concurrency", 0, "number of node groups reconciled in parallel, overrides the config")
+	metricsAddr := flags.String("metrics-addr", "", "address to serve prometheus metrics on at /metrics, e.g. :9090")
+	interval := flags.Duration("interval", 0, "reconcile repeatedly with this pause in between instead of once")
+	apiAddr := flags.String("api-addr", "", "address to serve the http api on, e.g. :8080, keeps running to serve it")
+	apiTokenFile := flags.String("api-token-file", "", "file holding the bearer token of the api, defaults to $"+apiTokenEnv)
+	controlsPath := flags.String("controls-path", controllers.DefaultControlsPath, "file keeping the rollouts paused and amis approved through the api")
+	parseFlags(flags, args)
+
+	reconcilerSvc := newReconcilerService(getAwsSession(region))
+	controls, err := controllers.LoadNodeGroupControls(*controlsPath)
+	if err != nil {
+		log.Fatal("Failed to load the controls: ", err)
+	}
+	reconcilerSvc.Controls = controls
+
+	c := loadConfig(*configPath)
+	if *concurrency > 0 {
+		c.Concurrency = *concurrency
+	}
+	if *apiAddr == "" {
+		if errs := controllers.ValidateWithoutAPI(c.NodeGroups); len(errs) > 0 {
+			logValidationErrors(*configPath, errs)
+			os.Exit(1)
+		}
+	}
+	if errs := reconcilerSvc.ValidateInstanceTypes(c.NodeGroups); len(errs) > 0 {
+		logValidationErrors(*configPath, errs)
+		os.Exit(1)
+	}
+
+	if *metricsAddr != "" {
+		go serveMetrics(*metricsAddr)
+	}
+
+	triggers := make(chan string, 16)
+	if *apiAddr != "" {
+		server := controllers.APIServer{
+			Token:      apiToken(*apiTokenFile),
+			Reconciler: reconcilerSvc,
+			K8sVersion: k8sVersion,
+			NodeGroups: copyNodeGroups(c.NodeGroups),
+			Controls:   reconcilerSvc.Controls,
+			Triggers:   triggers,
+		}
+		go serveAPI(*apiAddr, server.Handler())
+	}
+
+	nodeGroups := c
+	for {
+		failed := apply(&reconcilerSvc, nodeGroups)
+		if *interval <= 0 && *apiAddr == "" {
+			if failed > 0 {
+				os.Exit(1)
+			}
+			os.Exit(0)
+		}
+
+		// without an interval only reconciles triggered through the api run
+		var next <-chan time.Time
+		if *interval > 0 {
+			log.WithField("interval", *interval).Info("Waiting for the next reconcile")
+			next = time.After(*interval)
+		}
+
+		nodeGroups = c
+		select {
+		case <-next:
+		case name := <-triggers:
+			nodeGroups = selectNodeGroup(c, name)
+			log.WithField(controllers.NodeGroupField, name).Info("Reconcile triggered through the api")
+		}
+	}
+}
+
+// selectNodeGroup narrows the config to the named node group, all of them when empty. A reconcile asked for one
+// node group does not wait for the node groups it depends on.
+func selectNodeGroup(c apiTypes.ManagerConfig, name string) apiTypes.ManagerConfig {
+	if name == "" {
+		return c
+	}
+
+	for _, v := range c.NodeGroups {
+		if v.NodeGroupName() == name {
+			v.DependsOn = nil
+			return apiTypes.ManagerConfig{Concurrency: c.Concurrency, NodeGroups: []apiTypes.OperatorModel{v}}
+		}
+	}
+
+	return c
+}
+
+// copyNodeGroups deep copies the node groups, a reconcile writes the resolved ami, subnets and security groups into
+// the models it is given
+func copyNodeGroups(models []apiTypes.OperatorModel) []apiTypes.OperatorModel {
+	copied := make([]apiTypes.OperatorModel, len(models))
+	for i := range models {
+		models[i].DeepCopyInto(&copied[i])
+	}
+
+	return copied
+}
+
+// apiToken reads the bearer token of the api from the file or the environment, an api without a token is refused
+func apiToken(path string) string {
+	token := os.Getenv(apiTokenEnv)
+	if path != "" {
+		content, err := ioutil.ReadFile(path)
+		if err != nil {
+			log.Fatal("Failed to read api token: ", err)
+		}
+		token = strings.TrimSpace(string(content))
+	}
+
+	if token == "" {
+		log.WithField("env", apiTokenEnv).Fatal("The api requires a bearer token, set it in the environment or pass -api-token-file")
+	}
+
+	return token
+}
+
+func serveAPI(addr string, handler http.Handler) {
+	log.WithField("addr", addr).Info("Serving api")
+	if err := http.ListenAndServe(addr, handler); err != nil {
+		log.Fatal("Failed to serve api: ", err)
+	}
+}
+
+// apply reconciles every node group once, prints a summary and returns the number of node groups not reconciled
+func apply(reconcilerSvc *controllers.ReconcilerService, c apiTypes.ManagerConfig) int {
+	// each pass resolves the ami and networking again from the config as written
+	results, err := reconcilerSvc.ReconcileNodeGroups(copyNodeGroups(c.NodeGroups), k8sVersion, c.Concurrency)
+	if err != nil {
+		log.Fatal("Invalid node group configuration: ", err)
+	}
+
+	failed := 0
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "NODE GROUP\tRESULT\tDURATION\tERROR")
+	for _, v := range results {
+		result := "succeeded"
+		if v.Skipped {
+			result = "skipped"
+		} else if !v.Success {
+			result = "failed"
+		}
+
+		if !v.Success {
+			failed++
+		}
+
+		fmt.Fprintf(w, "%v\t%v\t%v\t%v\n", v.NodeGroup, result, v.Duration.Round(time.Second), errorString(v.Err))
+	}
+	w.Flush()
+
+	printAPIMetrics()
+
+	return failed
+}
+
+func serveMetrics(addr string) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+
+	log.WithField("addr", addr).Info("Serving metrics")
+	if err := http.ListenAndServe(addr, mux); err != nil {
+		log.Fatal("Failed to serve metrics: ", err)
+	}
+}
+
+// printAPIMetrics logs the aws calls made by the command, to keep an eye on api usage
+func printAPIMetrics() {
+	for _, v := range apiMetrics.Calls() {
+		log.WithFields(log.Fields{"operation": v.Operation, "calls": v.Calls}).Info("AWS API calls")
+	}
+
+	hits, misses := apiMetrics.CacheLookups()
+	log.WithFields(log.Fields{"hits": hits, "misses": misses}).Info("AWS API cache")
+}
+
+// parseFlags adds the logging flags shared by all commands, parses the arguments and configures the logger
+func parseFlags(flags *flag.FlagSet, args []string) {
+	logFormat := flags.String("log-format", "logfmt", "log format, logfmt or json")
+	logLevel := flags.String("log-level", "info", "log level: debug, info, warn or error")
+	_ = flags.Parse(args)
+
+	if err := controllers.ConfigureLogger(log.StandardLogger(), *logFormat, *logLevel); err != nil {
+		log.Fatal("Invalid logging flags: ", err)
+	}
+}
+
+func errorString(err error) string {
+	if err == nil {
+		return ""
+	}
+
+	return err.Error()
+}
+
+func runImport(args []string) {
+	flags := flag.NewFlagSet("import", flag.ExitOnError)
+	asgName := flags.String("asg", "", "name of the existing asg to import")
+	outPath := flags.String("out", "", "path to write the node group config to, defaults to stdout")
+	clusterName := flags.String("cluster", "", "cluster the node group belongs to")
+	managerID := flags.String("manager-id", "default", "id of the manager owning the imported resources")
+	lockBackend := flags.String("lock-backend", "", "lock backend the node group is reconciled with: file, dynamodb or kubernetes")
+	lockPath := flags.String("lock-path", "", "directory of the file locks")
+	lockTable := flags.String("lock-table", "", "dynamodb table of the locks")
+	lockNamespace := flags.String("lock-namespace", "", "namespace of the kubernetes lock leases")
+	parseFlags(flags, args)
+
+	if *asgName == "" {
+		log.Fatal("import requires -asg")
+	}
+
+	reconcilerSvc := newReconcilerService(getAwsSession(region))
+
+	// the asg is tagged while holding the lock of the node group and audited, the way the other commands change it
+	nodeGroup := apiTypes.OperatorModel{LockOptions: apiTypes.LockOptions{Backend: *lockBackend, Path: *lockPath, Table: *lockTable, Namespace: *lockNamespace}}
+	nodeGroup.AutoScalingGroupOptions.Name = *asgName
+	useNodeGroup(&reconcilerSvc, &nodeGroup)
+
+	var model *apiTypes.OperatorModel
+	err := reconcilerSvc.WithNodeGroupLock(&nodeGroup, func(context.Context) error {
+		var err error
+		model, err = reconcilerSvc.ImportAutoScalingGroup(*asgName, *clusterName, *managerID)
+		return err
+	})
+	if err != nil {
+		log.Fatal("Failed to import asg: ", err)
+	}
+
+	config, err := yaml.Marshal(model)
+	if err != nil {
+		log.Fatal("Failed to marshal the imported node group: ", err)
+	}
+
+	if *outPath == "" {
+		os.Stdout.Write(config)
+		os.Exit(0)
+	}
+
+	if err := ioutil.WriteFile(*outPath, config, 0644); err != nil {
+		log.Fatal(err, *outPath)
+	}
+
+	log.WithFields(log.Fields{controllers.AsgField: *asgName, "path": *outPath}).Info("Imported asg")
+	os.Exit(0)
+}
+
+func runGc(args []string) {
+	flags := flag.NewFlagSet("gc", flag.ExitOnError)
+	configPath := flags.String("config", defaultConfigPath(), "path to the node group config file, overlays merged over it may follow separated by commas")
+	group := flags.String("group", "", "node group to use when the config has several")
+	dryRun := flags.Bool("dry-run", false, "only list the versions that would be deleted")
+	parseFlags(flags, args)
+
+	reconcilerSvc := newReconcilerService(getAwsSession(region))
+
+	c := loadNodeGroup(*configPath, *group)
+	useNodeGroup(&reconcilerSvc, &c)
+	var versions []string
+	err := reconcilerSvc.WithNodeGroupLock(&c, func(context.Context) error {
+		var err error
+		versions, err = reconcilerSvc.CollectLaunchTemplateVersions(&c, *dryRun)
+		return err
+	})
+	if err != nil {
+		log.Fatal("Failed to garbage collect launch template versions: ", err)
+	}
+
+	for _, v := range versions {
+		fmt.Println(v)
+	}
+
+	os.Exit(0)
+}
+
+func runRollback(args []string) {
+	flags := flag.NewFlagSet("rollback", flag.ExitOnError)
+	configPath := flags.String("config", defaultConfigPath(), "path to the node group config file, overlays merged over it may follow separated by commas")
+	group := flags.String("group", "", "node group to use when the config has several")
+	version := flags.String("version", "", "launch template version to roll back to, defaults to the previous version")
+	clearRollback := flags.Bool("clear", false, "forget the last rollback so the node group rolls forward to its config again")
+	parseFlags(flags, args)
+
+	reconcilerSvc := newReconcilerService(getAwsSession(region))
+
+	c := loadNodeGroup(*configPath, *group)
+	useNodeGroup(&reconcilerSvc, &c)
+
+	if *clearRollback {
+		if err := reconcilerSvc.ClearRollback(&c); err != nil {
+			log.Fatal("Failed to clear the rollback: ", err)
+		}
+		os.Exit(0)
+	}
+
+	if err := reconcilerSvc.WithNodeGroupLock(&c, func(context.Context) error { return reconcilerSvc.Rollback(&c, *version) }); err != nil {
+		log.Fatal("Failed to roll back: ", err)
+	}
+
+	os.Exit(0)
+}
+
+func runPromote(args []string) {
+	flags := flag.NewFlagSet("promote", flag.ExitOnError)
+	configPath := flags.String("config", defaultConfigPath(), "path to the node group config file, overlays merged over it may follow separated by commas")
+	group := flags.String("group", "", "node group to use when the config has several")
+	parseFlags(flags, args)
+
+	reconcilerSvc := newReconcilerService(getAwsSession(region))
+
+	c := loadNodeGroup(*configPath, *group)
+	useNodeGroup(&reconcilerSvc, &c)
+	if err := reconcilerSvc.WithNodeGroupLock(&c, func(context.Context) error { return reconcilerSvc.Promote(&c) }); err != nil {
+		log.Fatal("Failed to promote green ASG: ", err)
+	}
+
+	os.Exit(0)
+}
+
+func runAbort(args []string) {
+	flags := flag.NewFlagSet("abort", flag.ExitOnError)
+	configPath := flags.String("config", defaultConfigPath(), "path to the node group config file, overlays merged over it may follow separated by commas")
+	group := flags.String("group", "", "node group to use when the config has several")
+	parseFlags(flags, args)
+
+	reconcilerSvc := newReconcilerService(getAwsSession(region))
+
+	c := loadNodeGroup(*configPath, *group)
+	useNodeGroup(&reconcilerSvc, &c)
+	if err := reconcilerSvc.WithNodeGroupLock(&c, func(context.Context) error { return reconcilerSvc.Abort(&c) }); err != nil {
+		log.Fatal("Failed to abort blue/green replacement: ", err)
+	}
+
+	os.Exit(0)
+}
+
+func runOrphans(args []string) {
+	flags := flag.NewFlagSet("orphans", flag.ExitOnError)
+	configPath := flags.String("config", defaultConfigPath(), "path to the node group config file, overlays merged over it may follow separated by commas")
+	group := flags.String("group", "", "node group to use when the config has several")
+	terminate := flags.Bool("terminate", false, "drain and terminate the orphaned instances")
+	parseFlags(flags, args)
+
+	reconcilerSvc := newReconcilerService(getAwsSession(region))
+
+	c := loadNodeGroup(*configPath, *group)
+	useNodeGroup(&reconcilerSvc, &c)
+	orphans, err := reconcilerSvc.FindOrphanInstances(&c)
+	if err != nil {
+		log.Fatal("Failed to look for orphaned instances: ", err)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "INSTANCE\tNODE GROUP\tTYPE\tSTATE\tAGE\tEST. COST")
+	for _, v := range orphans {
+		fmt.Fprintf(w, "%v\t%v\t%v\t%v\t%v\t$%.2f\n", v.InstanceID, v.NodeGroup, v.InstanceType, v.State, v.Age, v.EstimatedCost)
+	}
+	w.Flush()
+
+	if *terminate && len(orphans) > 0 {
+		failed := 0
+		err := reconcilerSvc.WithNodeGroupLock(&c, func(context.Context) error {
+			failed = reconcilerSvc.CleanUpOrphanInstances(&c, orphans)
+			return nil
+		})
+		if err != nil {
+			log.Fatal("Failed to terminate orphaned instances: ", err)
+		}
+		log.WithFields(log.Fields{"failed": failed, "orphans": len(orphans)}).Info("Cleaned up orphaned instances")
+		if failed > 0 {
+			os.Exit(1)
+		}
+	}
+
+	os.Exit(0)
+}
+
+func runHistory(args []string) {
+	flags := flag.NewFlagSet("history", flag.ExitOnError)
+	configPath := flags.String("config", defaultConfigPath(), "path to the node group config file, overlays merged over it may follow separated by commas")
+	group := flags.String("group", "", "node group to use when the config has several")
+	since := flags.Duration("since", 0, "only show events younger than this, e.g. 24h")
+	limit := flags.Int("limit", 50, "show at most this many of the latest events, 0 for all")
+	output := flags.String("output", "table", "output format: table or json")
+	parseFlags(flags, args)
+
+	c := loadNodeGroup(*configPath, *group)
+	sink, err := controllers.NewAuditSink(c.AuditOptions, getAwsSession(region), c.NodeGroupName())
+	if err != nil {
+		log.Fatal("Invalid audit configuration: ", err)
+	}
+
+	query := controllers.AuditQuery{NodeGroup: c.NodeGroupName(), Limit: *limit}
+	if *since > 0 {
+		query.Since = time.Now().Add(-*since)
+	}
+
+	events, err := sink.Query(query)
+	if err != nil {
+		log.Fatal("Failed to read audit events: ", err)
+	}
+
+	switch *output {
+	case "json":
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(events); err != nil {
+			log.Fatal(err)
+		}
+	case "table":
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "TIME\tOPERATOR\tACTION\tRESOURCE\tOUTCOME\tERROR")
+		for _, v := range events {
+			fmt.Fprintf(w, "%v\t%v\t%v\t%v\t%v\t%v\n", v.Time.Format(time.RFC3339), v.Operator, v.Action, v.Resource, v.Outcome, v.Error)
+		}
+		w.Flush()
+	default:
+		log.WithField("output", *output).Fatal("Unknown output format, expected one of: table, json")
+	}
+
+	os.Exit(0)
+}
+
+func runStatus(args []string) {
+	flags := flag.NewFlagSet("status", flag.ExitOnError)
+	configPath := flags.String("config", defaultConfigPath(), "path to the node group config file, overlays merged over it may follow separated by commas")
+	group := flags.String("group", "", "only report this node group")
+	output := flags.String("output", "table", "output format: table, json or yaml")
+	parseFlags(flags, args)
+
+	if *output != "table" && *output != "json" && *output != "yaml" {
+		log.WithField("output", *output).Fatal("Unknown output format, expected one of: table, json, yaml")
+	}
 
-	reconcilerSvc := controllers.ReconcilerService{
-		AsgService: asgSvc,
-		SsmService: ssmSvc,
-		Ec2Service: ec2Svc,
+	reconcilerSvc := newReconcilerService(getAwsSession(region))
+
+	c := loadConfig(*configPath)
+	if *group != "" {
+		c.NodeGroups = []apiTypes.OperatorModel{loadNodeGroup(*configPath, *group)}
 	}
 
-	c := loadConfig()
-	c.AmiID = *reconcilerSvc.GetLatestEksAmi(&k8sVersion)
+	failed := 0
+	statuses := []*apiTypes.NodeGroupStatus{}
+	for i := range c.NodeGroups {
+		model := &c.NodeGroups[i]
+		svc, err := reconcilerSvc.ForNodeGroup(model)
+		if err != nil {
+			log.Fatal("Invalid node group configuration: ", err)
+		}
+
+		status, err := svc.NodeGroupStatus(model, k8sVersion)
+		if err != nil {
+			log.WithField(controllers.NodeGroupField, model.NodeGroupName()).WithError(err).Error("Failed to get node group status")
+			failed++
+			continue
+		}
+		statuses = append(statuses, status)
+	}
 
-	templateName, latestVersion, success := reconcilerSvc.ReconcileLaunchTemplate(&c.LaunchTemplateOptions)
+	switch *output {
+	case "json":
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(statuses); err != nil {
+			log.Fatal(err)
+		}
+	case "yaml":
+		content, err := yaml.Marshal(statuses)
+		if err != nil {
+			log.Fatal("Failed to marshal the status: ", err)
+		}
+		os.Stdout.Write(content)
+	default:
+		for i, v := range statuses {
+			if i > 0 {
+				fmt.Println()
+			}
+			printStatus(v)
+		}
+	}
 
-	if !success {
+	if failed > 0 {
 		os.Exit(1)
 	}
+	os.Exit(0)
+}
+
+func runValidate(args []string) {
+	flags := flag.NewFlagSet("validate", flag.ExitOnError)
+	configPath := flags.String("config", defaultConfigPath(), "path to the node group config file, overlays merged over it may follow separated by commas")
+	offline := flags.Bool("offline", false, "skip the checks calling aws, such as the instance type existing")
+	schema := flags.Bool("schema", false, "print the json schema of the config file instead")
+	parseFlags(flags, args)
+
+	if *schema {
+		if err := writeSchema(os.Stdout); err != nil {
+			log.Fatal(err)
+		}
+		os.Exit(0)
+	}
+
+	c, err := readConfig(*configPath)
+	if err != nil {
+		log.Fatal(err)
+	}
 
-	_, success = reconcilerSvc.ReconcileAutoScalingGroup(&c.AutoScalingGroupOptions, templateName, latestVersion)
-	if !success {
+	errs := controllers.ValidateConfig(c.NodeGroups)
+	if !*offline {
+		reconcilerSvc := newReconcilerService(getAwsSession(region))
+		errs = append(errs, reconcilerSvc.ValidateInstanceTypes(c.NodeGroups)...)
+	}
+
+	if len(errs) > 0 {
+		logValidationErrors(*configPath, errs)
 		os.Exit(1)
 	}
 
+	log.WithFields(log.Fields{"config": *configPath, "nodeGroups": len(c.NodeGroups)}).Info("Config is valid")
 	os.Exit(0)
 }
 
+// printStatus prints the node group status as tables, configured values next to the ones in aws
+func printStatus(status *apiTypes.NodeGroupStatus) {
+	fmt.Printf("NODE GROUP %v\n", status.NodeGroup)
+	if status.Actual == nil {
+		fmt.Printf("  ASG %v does not exist\n", status.Desired.AsgName)
+		return
+	}
+
+	actual := status.Actual
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "  \tCONFIGURED\tACTUAL\t")
+	fmt.Fprintf(w, "  ASG\t%v\t%v\t\n", status.Desired.AsgName, actual.AsgName)
+	fmt.Fprintf(w, "  MIN/DESIRED/MAX\t%v/%v/%v\t%v/%v/%v\t%v\n", status.Desired.MinInstances, status.Desired.DesiredInstances, status.Desired.MaxInstances,
+		actual.MinInstances, actual.DesiredInstances, actual.MaxInstances,
+		skew(status.Desired.MinInstances == actual.MinInstances && status.Desired.DesiredInstances == actual.DesiredInstances && status.Desired.MaxInstances == actual.MaxInstances))
+	fmt.Fprintf(w, "  LAUNCH TEMPLATE\t%v\t%v\t\n", status.Desired.LaunchTemplate, actual.LaunchTemplate)
+	fmt.Fprintf(w, "  VERSION\t\tdefault %v, latest %v\t%v\n", actual.TargetVersion, actual.LatestVersion, skew(actual.TargetVersion == actual.LatestVersion))
+	fmt.Fprintf(w, "  INSTANCE TYPE\t%v\t%v\t%v\n", status.Desired.InstanceType, actual.InstanceType, skew(status.Desired.InstanceType == actual.InstanceType))
+	fmt.Fprintf(w, "  AMI\t%v (recommended)\t%v\t%v\n", status.Desired.AmiID, actual.AmiID, skew(status.Desired.AmiID == actual.AmiID))
+	if status.PendingAmi != "" {
+		fmt.Fprintf(w, "  PENDING AMI\t%v\t\t\n", status.PendingAmi)
+	}
+	w.Flush()
+
+	rollout := "none"
+	switch {
+	case status.Rollout != nil:
+		rollout = fmt.Sprintf("in progress to version %v, %v stale instances", status.Rollout.TargetVersion, status.StaleInstances)
+	case status.StaleInstances > 0:
+		rollout = fmt.Sprintf("%v stale instances", status.StaleInstances)
+	}
+	if status.Paused {
+		rollout += ", paused"
+	}
+	fmt.Printf("  ROLLOUT %v\n", rollout)
+
+	fmt.Println()
+	w = tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "  VERSION\tLIFECYCLE\tHEALTH\tINSTANCES")
+	for _, v := range status.InstanceGroups {
+		fmt.Fprintf(w, "  %v\t%v\t%v\t%v\n", v.Version, v.LifecycleState, v.HealthStatus, v.Count)
+	}
+	w.Flush()
+
+	if len(status.Activities) == 0 {
+		return
+	}
+
+	fmt.Println()
+	w = tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "  ACTIVITY\tSTATUS\tPROGRESS\tSTARTED")
+	for _, v := range status.Activities {
+		fmt.Fprintf(w, "  %v\t%v\t%v%%\t%v\n", v.Description, v.Status, v.Progress, v.StartTime.Format(time.RFC3339))
+	}
+	w.Flush()
+}
+
+func skew(inSync bool) string {
+	if inSync {
+		return ""
+	}
+
+	return "differs"
+}
+
+// runExport prints the launch templates and asgs apply would create as a CloudFormation template or terraform
+// configuration, resolved against aws the same way but without changing anything
+func runExport(args []string) {
+	flags := flag.NewFlagSet("export", flag.ExitOnError)
+	configPath := flags.String("config", defaultConfigPath(), "path to the node group config file, overlays merged over it may follow separated by commas")
+	group := flags.String("group", "", "only export this node group")
+	format := flags.String("format", "cloudformation", "output format: cloudformation or terraform")
+	parseFlags(flags, args)
+
+	if *format != "cloudformation" && *format != "terraform" {
+		log.WithField("format", *format).Fatal("Unknown export format, expected one of: cloudformation, terraform")
+	}
+
+	reconcilerSvc := newReconcilerService(getAwsSession(region))
+
+	c := loadConfig(*configPath)
+	if *group != "" {
+		c.NodeGroups = []apiTypes.OperatorModel{loadNodeGroup(*configPath, *group)}
+	}
+
+	exports := []*controllers.NodeGroupExport{}
+	for i := range c.NodeGroups {
+		model := &c.NodeGroups[i]
+		svc, err := reconcilerSvc.ForNodeGroup(model)
+		if err != nil {
+			log.Fatal("Invalid node group configuration: ", err)
+		}
+
+		export, err := svc.ExportNodeGroup(model, k8sVersion)
+		if err != nil {
+			log.WithField(controllers.NodeGroupField, model.NodeGroupName()).Fatal("Failed to export node group: ", err)
+		}
+		exports = append(exports, export)
+	}
+
+	switch *format {
+	case "terraform":
+		os.Stdout.Write(controllers.RenderTerraform(exports))
+	default:
+		content, err := controllers.RenderCloudFormation(exports)
+		if err != nil {
+			log.Fatal("Failed to render the template: ", err)
+		}
+		os.Stdout.Write(content)
+	}
+}
+
+func newReconcilerService(session session.Session) controllers.ReconcilerService {
+	cache := controllers.NewAPICache(controllers.DefaultAPICacheTTL, apiMetrics)
+	ssmSvc := controllers.SsmService{AwsSession: session, Region: region}
+	asgSvc := controllers.AsgService{AwsSession: session, Region: region, Cache: cache}
+	ec2Svc := controllers.Ec2Service{AwsSession: session, Region: region, Cache: cache}
+	kubeSvc := controllers.KubeService{Kubectl: "kubectl", Kubeconfig: os.Getenv("KUBECONFIG")}
+	cwSvc := controllers.CloudWatchService{AwsSession: session, Region: region}
+	pricingSvc := controllers.PricingService{AwsSession: session, Region: region, Prices: &sync.Map{}}
+
+	operator, err := controllers.GetCallerIdentity(session)
+	if err != nil {
+		log.WithError(err).Warn("Failed to get caller identity, audit events will have no operator")
+	}
+
+	return controllers.ReconcilerService{
+		AsgService:        asgSvc,
+		SsmService:        ssmSvc,
+		Ec2Service:        ec2Svc,
+		KubeService:       kubeSvc,
+		CloudWatchService: cwSvc,
+		PricingService:    pricingSvc,
+		Auditor:           &controllers.Auditor{Operator: operator},
+	}
+}
+
+// useNodeGroup keeps rollout state in the store of the node group, logs with it, audits its changes and notifies of
+// its rollout events, as apply does, for commands working on a single node group
+func useNodeGroup(reconcilerSvc *controllers.ReconcilerService, c *apiTypes.OperatorModel) {
+	svc, err := reconcilerSvc.ForNodeGroup(c)
+	if err != nil {
+		log.Fatal("Invalid node group configuration: ", err)
+	}
+	*reconcilerSvc = *svc
+}
+
 //GetAwsSession represents
 func getAwsSession(region string) session.Session {
 	session, err := session.NewSessionWithOptions(session.Options{
 		Profile: "argentus",
-		Config:  aws.Config{Region: aws.String(region)},
+		Config:  *controllers.WithRetryPolicy(&aws.Config{Region: aws.String(region)}, controllers.DefaultRetryPolicy),
 	})
 
 	if err != nil {
@@ -61,22 +763,107 @@ func getAwsSession(region string) session.Session {
 		os.Exit(1)
 	}
 
+	controllers.InstrumentSession(session, apiMetrics)
 	return *session
 }
 
-func loadConfig() apiTypes.OperatorModel {
-	dir, err := os.Getwd()
-	filePath := dir + "/cmd/manager/config.yaml"
-	//filePath := "config.yaml"
-	config, err := ioutil.ReadFile(filePath)
+func defaultConfigPath() string {
+	dir, _ := os.Getwd()
+	return dir + "/cmd/manager/config.yaml"
+}
+
+// loadConfig reads either a single node group or a list of node groups under nodeGroups
+func loadConfig(filePath string) apiTypes.ManagerConfig {
+	c, err := readConfig(filePath)
 	if err != nil {
-		log.Fatal(err, dir)
+		log.Fatal(err)
+	}
+
+	if errs := controllers.ValidateConfig(c.NodeGroups); len(errs) > 0 {
+		logValidationErrors(filePath, errs)
 		os.Exit(1)
 	}
-	c := apiTypes.OperatorModel{}
-	err = yaml.Unmarshal(config, &c)
+
+	return c
+}
+
+// readConfig merges the overlays over the base config and resolves the environment variables and secrets it
+// references. filePath lists the base config then the overlays, separated by commas, the overlay of the environment
+// in $NODE_GROUP_MANAGER_ENV comes last.
+func readConfig(filePath string) (apiTypes.ManagerConfig, error) {
+	paths := strings.Split(filePath, ",")
+	if env := os.Getenv(configEnv); env != "" {
+		paths = append(paths, controllers.ConfigOverlayPath(paths[0], env))
+	}
+
+	secrets := &controllers.SsmService{AwsSession: getAwsSession(region), Region: region}
+	config, err := controllers.LoadConfigFiles(paths, os.LookupEnv, secrets)
 	if err != nil {
-		log.Fatalf("Unmarshal: %v", err)
+		return apiTypes.ManagerConfig{}, err
 	}
-	return c
+
+	c, err := parseConfig(config)
+	if err != nil {
+		return c, fmt.Errorf("unmarshal %v: %v", filePath, err)
+	}
+
+	return c, nil
+}
+
+// writeSchema writes the json schema of the config file, config.schema.json holds its output
+func writeSchema(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(apiTypes.ConfigSchema())
+}
+
+// parseConfig reads either several node groups under nodeGroups or a single one, fields not in the config types
+// are rejected so a typo does not go unnoticed
+func parseConfig(config []byte) (apiTypes.ManagerConfig, error) {
+	keys := make(map[string]interface{})
+	if err := yaml.Unmarshal(config, &keys); err != nil {
+		return apiTypes.ManagerConfig{}, err
+	}
+
+	c := apiTypes.ManagerConfig{}
+	if _, ok := keys["nodeGroups"]; ok {
+		err := yaml.UnmarshalStrict(config, &c)
+		return c, err
+	}
+
+	nodeGroup := apiTypes.OperatorModel{}
+	if err := yaml.UnmarshalStrict(config, &nodeGroup); err != nil {
+		return c, err
+	}
+	c.NodeGroups = []apiTypes.OperatorModel{nodeGroup}
+
+	return c, nil
+}
+
+func logValidationErrors(filePath string, errs apiTypes.ValidationErrors) {
+	for _, v := range errs {
+		log.Error(v)
+	}
+	log.WithFields(log.Fields{"config": filePath, "errors": len(errs)}).Error("Config is invalid")
+}
+
+// loadNodeGroup reads the named node group, the name may be left out when the config has only one
+func loadNodeGroup(filePath string, name string) apiTypes.OperatorModel {
+	c := loadConfig(filePath)
+	if name == "" && len(c.NodeGroups) == 1 {
+		return c.NodeGroups[0]
+	}
+
+	if name == "" {
+		log.WithFields(log.Fields{"config": filePath, "nodeGroups": len(c.NodeGroups)}).Fatal("Config has several node groups, select one with -group")
+	}
+
+	for _, v := range c.NodeGroups {
+		if v.NodeGroupName() == name {
+			return v
+		}
+	}
+
+	log.WithFields(log.Fields{"config": filePath, controllers.NodeGroupField: name}).Fatal("Node group is not in the config")
+	return apiTypes.OperatorModel{}
 }
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	apiTypes "github.com/anyo/aws-node-group-manager/pkg/apis"
+	controllers "github.com/anyo/aws-node-group-manager/pkg/controllers"
+
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+func TestParseConfig(t *testing.T) {
+	tests := []struct {
+		name   string
+		config string
+		// nodeGroups are the asg names of the parsed node groups
+		nodeGroups []string
+		// err is a substring of the expected error, empty when the config is valid
+		err string
+	}{
+		{
+			name:       "single node group",
+			config:     "asg:\n  name: workers\n  max: 3\n",
+			nodeGroups: []string{"workers"},
+		},
+		{
+			name:       "several node groups",
+			config:     "concurrency: 2\nnodeGroups:\n  - asg:\n      name: workers\n  - asg:\n      name: batch\n",
+			nodeGroups: []string{"workers", "batch"},
+		},
+		{
+			name:   "unknown key",
+			config: "asg:\n  name: workers\n  maximum: 3\n",
+			err:    "field maximum not found",
+		},
+		{
+			name:   "unknown top level key",
+			config: "asg:\n  name: workers\nrolllout:\n  strategy: rolling\n",
+			err:    "field rolllout not found",
+		},
+		{
+			name:   "unknown key in a node group list",
+			config: "nodeGroups:\n  - asg:\n      name: workers\n    ec2:\n      launchTemplate:\n        instancetype: m5.large\n",
+			err:    "field instancetype not found",
+		},
+		{
+			name:   "key ignored by yaml",
+			config: "asg:\n  name: workers\n  launchTemplateName: other\n",
+			err:    "field launchTemplateName not found",
+		},
+		{
+			name:   "wrong type",
+			config: "asg:\n  name: workers\n  max: three\n",
+			err:    "cannot unmarshal",
+		},
+		{
+			name:   "not yaml",
+			config: "asg: [workers\n",
+			err:    "yaml",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := parseConfig([]byte(tt.config))
+			switch {
+			case tt.err == "" && err != nil:
+				t.Fatalf("unexpected error: %v", err)
+			case tt.err != "" && err == nil:
+				t.Fatalf("expected an error containing %q", tt.err)
+			case tt.err != "" && !strings.Contains(err.Error(), tt.err):
+				t.Fatalf("expected an error containing %q, got %v", tt.err, err)
+			case tt.err != "":
+				return
+			}
+
+			names := []string{}
+			for _, v := range c.NodeGroups {
+				names = append(names, v.NodeGroupName())
+			}
+			if strings.Join(names, ",") != strings.Join(tt.nodeGroups, ",") {
+				t.Fatalf("parsed node groups %v, expected %v", names, tt.nodeGroups)
+			}
+		})
+	}
+}
+
+func TestExampleConfig(t *testing.T) {
+	env := map[string]string{"CLUSTER_ENDPOINT": "https://tally.eks.example.com", "CLUSTER_CA": "Y2E="}
+	config, err := controllers.LoadConfigFiles([]string{"config.yaml"}, func(name string) (string, bool) {
+		value, ok := env[name]
+		return value, ok
+	}, nil)
+	if err != nil {
+		t.Fatalf("load config.yaml: %v", err)
+	}
+
+	c, err := parseConfig(config)
+	if err != nil {
+		t.Fatalf("parse config.yaml: %v", err)
+	}
+	if errs := controllers.ValidateConfig(c.NodeGroups); len(errs) > 0 {
+		t.Fatalf("config.yaml is invalid: %v", errs)
+	}
+}
+
+func TestCommittedSchema(t *testing.T) {
+	committed, err := ioutil.ReadFile("config.schema.json")
+	if err != nil {
+		t.Fatalf("read config.schema.json: %v", err)
+	}
+
+	var generated bytes.Buffer
+	if err := writeSchema(&generated); err != nil {
+		t.Fatalf("generate schema: %v", err)
+	}
+
+	if !bytes.Equal(committed, generated.Bytes()) {
+		t.Fatal("config.schema.json is out of date, regenerate it with: go run ./cmd/manager validate -schema > cmd/manager/config.schema.json")
+	}
+}
+
+func TestCopyNodeGroups(t *testing.T) {
+	models := []apiTypes.OperatorModel{{}}
+	models[0].AutoScalingGroupOptions.Name = "workers"
+	models[0].SecurityGroups = []*string{aws.String("sg-1")}
+
+	copied := copyNodeGroups(models)
+	// what a reconcile resolves into its models
+	copied[0].AmiID = "ami-1"
+	copied[0].Subnets = "subnet-1"
+	*copied[0].SecurityGroups[0] = "sg-2"
+	copied[0].SecurityGroups = append(copied[0].SecurityGroups, aws.String("sg-3"))
+
+	if models[0].AmiID != "" || models[0].Subnets != "" {
+		t.Fatalf("the resolved ami and subnets reached the config: %+v", models[0])
+	}
+	if len(models[0].SecurityGroups) != 1 || *models[0].SecurityGroups[0] != "sg-1" {
+		t.Fatalf("the resolved security groups reached the config: %v", aws.StringValueSlice(models[0].SecurityGroups))
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"flag"
+	"time"
+
+	"github.com/anyo/aws-node-group-manager/pkg/apis/v1alpha1"
+	controllers "github.com/anyo/aws-node-group-manager/pkg/controllers"
+	"github.com/go-logr/logr/funcr"
+	log "github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/healthz"
+	metricsserver "sigs.k8s.io/controller-runtime/pkg/metrics/server"
+)
+
+// runOperator reconciles NodeGroup resources instead of a config file, until it is stopped. The kubeconfig is
+// found as kubectl does, in cluster it is the service account.
+func runOperator(args []string) {
+	flags := flag.NewFlagSet("operator", flag.ExitOnError)
+	namespace := flags.String("namespace", "", "only watch NodeGroups in this namespace, all namespaces by default")
+	concurrency := flags.Int("concurrency", 1, "number of node groups reconciled in parallel")
+	resync := flags.Duration("resync", 10*time.Minute, "time between reconciles of an unchanged node group")
+	metricsAddr := flags.String("metrics-addr", "", "address to serve prometheus metrics on at /metrics, e.g. :9090")
+	healthAddr := flags.String("health-addr", ":8081", "address to serve the /healthz and /readyz probes on")
+	leaderElect := flags.Bool("leader-elect", true, "elect a leader so only one replica reconciles, disable only when a single replica runs")
+	parseFlags(flags, args)
+
+	// controller-runtime logs through logrus like the rest of the manager
+	ctrl.SetLogger(funcr.New(func(prefix, args string) {
+		log.WithField("logger", prefix).Info(args)
+	}, funcr.Options{}))
+
+	scheme := runtime.NewScheme()
+	if err := clientgoscheme.AddToScheme(scheme); err != nil {
+		log.Fatal(err)
+	}
+	if err := v1alpha1.AddToScheme(scheme); err != nil {
+		log.Fatal(err)
+	}
+
+	options := ctrl.Options{
+		Scheme: scheme,
+		// the manager metrics are served with -metrics-addr, next to the ones of the other commands
+		Metrics:                metricsserver.Options{BindAddress: "0"},
+		HealthProbeBindAddress: *healthAddr,
+		LeaderElection:         *leaderElect,
+		LeaderElectionID:       "aws-node-group-manager",
+	}
+	if *namespace != "" {
+		options.Cache = cache.Options{DefaultNamespaces: map[string]cache.Config{*namespace: {}}}
+	}
+
+	mgr, err := ctrl.NewManager(ctrl.GetConfigOrDie(), options)
+	if err != nil {
+		log.Fatal("Failed to create the controller manager: ", err)
+	}
+
+	reconcilerSvc := newReconcilerService(getAwsSession(region))
+	reconcilerSvc.Controls = controllers.NewNodeGroupControls()
+
+	operator := &controllers.NodeGroupOperator{
+		Client:      mgr.GetClient(),
+		Reconciler:  reconcilerSvc,
+		K8sVersion:  k8sVersion,
+		Resync:      *resync,
+		Concurrency: *concurrency,
+	}
+	if err := operator.SetupWithManager(mgr); err != nil {
+		log.Fatal("Failed to set up the operator: ", err)
+	}
+
+	if err := mgr.AddHealthzCheck("healthz", healthz.Ping); err != nil {
+		log.Fatal(err)
+	}
+	if err := mgr.AddReadyzCheck("readyz", healthz.Ping); err != nil {
+		log.Fatal(err)
+	}
+
+	if *metricsAddr != "" {
+		go serveMetrics(*metricsAddr)
+	}
+
+	log.Info("Starting operator")
+	if err := mgr.Start(ctrl.SetupSignalHandler()); err != nil {
+		log.Fatal("Operator stopped: ", err)
+	}
+}
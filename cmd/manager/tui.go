@@ -0,0 +1,506 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	apiTypes "github.com/anyo/aws-node-group-manager/pkg/apis"
+	controllers "github.com/anyo/aws-node-group-manager/pkg/controllers"
+	"github.com/jroimartin/gocui"
+	log "github.com/sirupsen/logrus"
+)
+
+const dashboardLogLines = 200
+
+const dashboardHelp = "↑/↓ select  r refresh  p plan  a apply  space pause/resume rollout  q quit"
+
+// dashboard is the state behind the tui, views are redrawn from it on every layout
+type dashboard struct {
+	reconciler *controllers.ReconcilerService
+	config     apiTypes.ManagerConfig
+	gui        *gocui.Gui
+
+	mu          sync.Mutex
+	statuses    map[string]*apiTypes.NodeGroupStatus
+	errors      map[string]error
+	selected    int
+	plan        map[string][]string
+	applying    string
+	message     string
+	refreshedAt time.Time
+	logs        []string
+}
+
+func runTui(args []string) {
+	flags := flag.NewFlagSet("tui", flag.ExitOnError)
+	configPath := flags.String("config", defaultConfigPath(), "path to the node group config file, overlays merged over it may follow separated by commas")
+	refresh := flags.Duration("refresh", 10*time.Second, "time between status refreshes")
+	controlsPath := flags.String("controls-path", controllers.DefaultControlsPath, "file keeping the rollouts paused and amis approved, shared with the daemon")
+	parseFlags(flags, args)
+
+	controls, err := controllers.LoadNodeGroupControls(*controlsPath)
+	if err != nil {
+		log.Fatal("Failed to load the controls: ", err)
+	}
+	reconcilerSvc := newReconcilerService(getAwsSession(region))
+	reconcilerSvc.Controls = controls
+
+	d := &dashboard{
+		reconciler: &reconcilerSvc,
+		config:     loadConfig(*configPath),
+		statuses:   make(map[string]*apiTypes.NodeGroupStatus),
+		errors:     make(map[string]error),
+		plan:       make(map[string][]string),
+	}
+
+	g, err := gocui.NewGui(gocui.OutputNormal)
+	if err != nil {
+		log.Fatal("Failed to start the terminal ui: ", err)
+	}
+	defer g.Close()
+	d.gui = g
+
+	// log lines would scribble over the views, they go to the log view instead
+	logOutput := log.StandardLogger().Out
+	log.SetOutput(d)
+	defer log.SetOutput(logOutput)
+
+	g.SetManagerFunc(d.layout)
+	if err := d.bindKeys(); err != nil {
+		log.Fatal("Failed to bind keys: ", err)
+	}
+
+	go func() {
+		for {
+			d.refresh()
+			time.Sleep(*refresh)
+		}
+	}()
+
+	if err := g.MainLoop(); err != nil && err != gocui.ErrQuit {
+		log.SetOutput(logOutput)
+		log.Fatal(err)
+	}
+}
+
+func (d *dashboard) bindKeys() error {
+	bindings := []struct {
+		key     interface{}
+		handler func(*gocui.Gui, *gocui.View) error
+	}{
+		{gocui.KeyCtrlC, quit},
+		{'q', quit},
+		{gocui.KeyArrowUp, d.moveSelection(-1)},
+		{'k', d.moveSelection(-1)},
+		{gocui.KeyArrowDown, d.moveSelection(1)},
+		{'j', d.moveSelection(1)},
+		{'r', d.onRefresh},
+		{'p', d.onPlan},
+		{'a', d.onApply},
+		{gocui.KeySpace, d.onTogglePause},
+	}
+
+	for _, v := range bindings {
+		if err := d.gui.SetKeybinding("", v.key, gocui.ModNone, v.handler); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func quit(g *gocui.Gui, v *gocui.View) error {
+	return gocui.ErrQuit
+}
+
+// Write keeps the last log lines for the log view
+func (d *dashboard) Write(p []byte) (int, error) {
+	d.mu.Lock()
+	d.logs = append(d.logs, strings.Split(strings.TrimRight(string(p), "\n"), "\n")...)
+	if len(d.logs) > dashboardLogLines {
+		d.logs = d.logs[len(d.logs)-dashboardLogLines:]
+	}
+	d.mu.Unlock()
+
+	d.redraw()
+	return len(p), nil
+}
+
+func (d *dashboard) redraw() {
+	d.gui.Update(func(*gocui.Gui) error { return nil })
+}
+
+// refresh describes every node group, outside of the ui loop since it calls aws
+func (d *dashboard) refresh() {
+	for i := range d.config.NodeGroups {
+		model := d.config.NodeGroups[i]
+
+		var status *apiTypes.NodeGroupStatus
+		svc, err := d.reconciler.ForNodeGroup(&model)
+		if err == nil {
+			status, err = svc.NodeGroupStatus(&model, k8sVersion)
+		}
+
+		d.mu.Lock()
+		d.statuses[model.NodeGroupName()] = status
+		d.errors[model.NodeGroupName()] = err
+		d.mu.Unlock()
+	}
+
+	d.mu.Lock()
+	d.refreshedAt = time.Now()
+	d.mu.Unlock()
+	d.redraw()
+}
+
+func (d *dashboard) moveSelection(delta int) func(*gocui.Gui, *gocui.View) error {
+	return func(g *gocui.Gui, v *gocui.View) error {
+		d.mu.Lock()
+		defer d.mu.Unlock()
+
+		d.selected += delta
+		if d.selected < 0 {
+			d.selected = 0
+		}
+		if d.selected >= len(d.config.NodeGroups) {
+			d.selected = len(d.config.NodeGroups) - 1
+		}
+
+		return nil
+	}
+}
+
+func (d *dashboard) selectedNodeGroup() string {
+	if len(d.config.NodeGroups) == 0 {
+		return ""
+	}
+
+	return d.config.NodeGroups[d.selected].NodeGroupName()
+}
+
+func (d *dashboard) onRefresh(g *gocui.Gui, v *gocui.View) error {
+	d.setMessage("Refreshing")
+	go d.refresh()
+	return nil
+}
+
+// onPlan toggles the changes apply would make to the selected node group, as far as its status shows them
+func (d *dashboard) onPlan(g *gocui.Gui, v *gocui.View) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	name := d.selectedNodeGroup()
+	if _, shown := d.plan[name]; shown {
+		delete(d.plan, name)
+		return nil
+	}
+
+	model := d.config.NodeGroups[d.selected]
+	d.plan[name] = planNodeGroup(&model, d.statuses[name])
+	return nil
+}
+
+func (d *dashboard) onApply(g *gocui.Gui, v *gocui.View) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	name := d.selectedNodeGroup()
+	if d.applying != "" {
+		d.message = fmt.Sprintf("Already applying %v", d.applying)
+		return nil
+	}
+	d.applying = name
+	d.message = fmt.Sprintf("Applying %v", name)
+	delete(d.plan, name)
+
+	go func() {
+		c := selectNodeGroup(d.config, name)
+		results, err := d.reconciler.ReconcileNodeGroups(copyNodeGroups(c.NodeGroups), k8sVersion, c.Concurrency)
+
+		message := fmt.Sprintf("Applied %v", name)
+		if err != nil {
+			message = fmt.Sprintf("Failed to apply %v: %v", name, err)
+		} else if len(results) > 0 && !results[0].Success {
+			message = fmt.Sprintf("Failed to apply %v: %v", name, errorString(results[0].Err))
+		}
+
+		d.mu.Lock()
+		d.applying = ""
+		d.message = message
+		d.mu.Unlock()
+		d.refresh()
+	}()
+
+	return nil
+}
+
+func (d *dashboard) onTogglePause(g *gocui.Gui, v *gocui.View) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	name := d.selectedNodeGroup()
+	var err error
+	if d.reconciler.Controls.Paused(name) {
+		err = d.reconciler.Controls.Resume(name)
+		d.message = fmt.Sprintf("Resumed the rollout of %v, apply to continue it", name)
+	} else {
+		err = d.reconciler.Controls.Pause(name)
+		d.message = fmt.Sprintf("Paused the rollout of %v", name)
+	}
+	if err != nil {
+		d.message = fmt.Sprintf("Failed to save the controls of %v: %v", name, err)
+	}
+	if status := d.statuses[name]; status != nil {
+		status.Paused = d.reconciler.Controls.Paused(name)
+	}
+
+	return nil
+}
+
+func (d *dashboard) setMessage(message string) {
+	d.mu.Lock()
+	d.message = message
+	d.mu.Unlock()
+	d.redraw()
+}
+
+func (d *dashboard) layout(g *gocui.Gui) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	maxX, maxY := g.Size()
+	listWidth := maxX / 3
+	logTop := maxY - maxY/4 - 2
+
+	list, err := setDashboardView(g, "nodegroups", "Node groups", 0, 0, listWidth, logTop-1)
+	if err != nil {
+		return err
+	}
+	list.Highlight = true
+	list.SelBgColor = gocui.ColorGreen
+	list.SelFgColor = gocui.ColorBlack
+	d.drawNodeGroups(list)
+
+	details, err := setDashboardView(g, "details", d.selectedNodeGroup(), listWidth+1, 0, maxX-1, logTop-1)
+	if err != nil {
+		return err
+	}
+	d.drawDetails(details)
+
+	logs, err := setDashboardView(g, "log", "Log", 0, logTop, maxX-1, maxY-2)
+	if err != nil {
+		return err
+	}
+	_, height := logs.Size()
+	start := len(d.logs) - height
+	if start < 0 {
+		start = 0
+	}
+	for _, v := range d.logs[start:] {
+		fmt.Fprintln(logs, v)
+	}
+
+	help, err := setDashboardView(g, "help", "", -1, maxY-2, maxX, maxY)
+	if err != nil {
+		return err
+	}
+	help.Frame = false
+	refreshed := "never"
+	if !d.refreshedAt.IsZero() {
+		refreshed = d.refreshedAt.Format("15:04:05")
+	}
+	fmt.Fprintf(help, "%v | refreshed %v | %v", dashboardHelp, refreshed, d.message)
+
+	_, err = g.SetCurrentView("nodegroups")
+	return err
+}
+
+// setDashboardView creates or resizes the view and clears it for redrawing
+func setDashboardView(g *gocui.Gui, name string, title string, x0, y0, x1, y1 int) (*gocui.View, error) {
+	v, err := g.SetView(name, x0, y0, x1, y1)
+	if err != nil && err != gocui.ErrUnknownView {
+		return nil, err
+	}
+
+	v.Title = title
+	v.Clear()
+	return v, nil
+}
+
+func (d *dashboard) drawNodeGroups(v *gocui.View) {
+	w := tabwriter.NewWriter(v, 0, 4, 1, ' ', 0)
+	for _, model := range d.config.NodeGroups {
+		name := model.NodeGroupName()
+		status := d.statuses[name]
+
+		state := "loading"
+		capacity := ""
+		version := ""
+		switch {
+		case d.errors[name] != nil:
+			state = "error"
+		case status == nil:
+		case status.Actual == nil:
+			state = "not created"
+		default:
+			capacity = fmt.Sprintf("%v/%v/%v", status.Actual.MinInstances, status.Actual.DesiredInstances, status.Actual.MaxInstances)
+			version = "v" + status.Actual.TargetVersion
+			state = "in sync"
+			if status.StaleInstances > 0 {
+				state = fmt.Sprintf("%v stale", status.StaleInstances)
+			}
+			if status.Paused {
+				state += ", paused"
+			}
+		}
+		if d.applying == name {
+			state = "applying"
+		}
+
+		fmt.Fprintf(w, "%v\t%v\t%v\t%v\n", name, capacity, version, state)
+	}
+	w.Flush()
+
+	_ = v.SetCursor(0, d.selected)
+}
+
+func (d *dashboard) drawDetails(v *gocui.View) {
+	name := d.selectedNodeGroup()
+	if err := d.errors[name]; err != nil {
+		fmt.Fprintf(v, "Failed to get status: %v\n", err)
+		return
+	}
+
+	status := d.statuses[name]
+	if status == nil {
+		fmt.Fprintln(v, "Loading...")
+		return
+	}
+
+	actual := apiTypes.NodeGroupSpec{}
+	if status.Actual != nil {
+		actual = *status.Actual
+	}
+
+	w := tabwriter.NewWriter(v, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "\tDESIRED\tACTUAL")
+	fmt.Fprintf(w, "ASG\t%v\t%v\n", status.Desired.AsgName, actual.AsgName)
+	fmt.Fprintf(w, "Min/desired/max\t%v/%v/%v\t%v/%v/%v\n", status.Desired.MinInstances, status.Desired.DesiredInstances, status.Desired.MaxInstances, actual.MinInstances, actual.DesiredInstances, actual.MaxInstances)
+	fmt.Fprintf(w, "Launch template\t%v\t%v\n", status.Desired.LaunchTemplate, actual.LaunchTemplate)
+	fmt.Fprintf(w, "Version\t\t%v (latest %v)\n", actual.TargetVersion, actual.LatestVersion)
+	fmt.Fprintf(w, "Instance type\t%v\t%v\n", status.Desired.InstanceType, actual.InstanceType)
+	fmt.Fprintf(w, "AMI\t%v\t%v\n", status.Desired.AmiID, actual.AmiID)
+	if status.PendingAmi != "" {
+		fmt.Fprintf(w, "Pending AMI\t%v\t\n", status.PendingAmi)
+	}
+	w.Flush()
+
+	fmt.Fprintln(v)
+	drawRollout(v, status)
+
+	if plan, shown := d.plan[name]; shown {
+		fmt.Fprintln(v, "\nPlan:")
+		for _, line := range plan {
+			fmt.Fprintf(v, "  %v\n", line)
+		}
+	}
+
+	fmt.Fprintln(v)
+	w = tabwriter.NewWriter(v, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "INSTANCE\tVERSION\tHEALTH\tLIFECYCLE\t")
+	for _, i := range status.Instances {
+		stale := ""
+		if i.Stale {
+			stale = "stale"
+		}
+		fmt.Fprintf(w, "%v\t%v\t%v\t%v\t%v\n", i.InstanceID, i.Version, i.HealthStatus, i.LifecycleState, stale)
+	}
+	w.Flush()
+}
+
+// drawRollout shows the share of the instances of the rollout already replaced
+func drawRollout(w io.Writer, status *apiTypes.NodeGroupStatus) {
+	paused := ""
+	if status.Paused {
+		paused = " (paused)"
+	}
+
+	if status.Rollout == nil && status.StaleInstances == 0 {
+		fmt.Fprintf(w, "Rollout: none%v\n", paused)
+		return
+	}
+
+	replaced := 0
+	if status.Rollout != nil {
+		for _, step := range status.Rollout.Instances {
+			if step == apiTypes.InstanceTerminated {
+				replaced++
+			}
+		}
+	}
+	total := replaced + status.StaleInstances
+
+	const width = 30
+	done := 0
+	if total > 0 {
+		done = width * replaced / total
+	}
+	fmt.Fprintf(w, "Rollout: [%v%v] %v/%v replaced%v\n", strings.Repeat("#", done), strings.Repeat("-", width-done), replaced, total, paused)
+}
+
+// planNodeGroup lists the changes apply would make as far as the status shows them, launch template changes are
+// only found by apply since they depend on the ami and networking it resolves
+func planNodeGroup(model *apiTypes.OperatorModel, status *apiTypes.NodeGroupStatus) []string {
+	if status == nil {
+		return []string{"Status not loaded yet"}
+	}
+
+	if status.Actual == nil {
+		return []string{
+			fmt.Sprintf("create launch template %v", status.Desired.LaunchTemplate),
+			fmt.Sprintf("create asg %v with %v/%v/%v instances", status.Desired.AsgName, status.Desired.MinInstances, status.Desired.DesiredInstances, status.Desired.MaxInstances),
+		}
+	}
+
+	plan := []string{}
+	capacity := []struct {
+		name            string
+		desired, actual int64
+	}{
+		{"min", status.Desired.MinInstances, status.Actual.MinInstances},
+		{"desired", status.Desired.DesiredInstances, status.Actual.DesiredInstances},
+		{"max", status.Desired.MaxInstances, status.Actual.MaxInstances},
+	}
+	for _, v := range capacity {
+		if v.desired != v.actual {
+			plan = append(plan, fmt.Sprintf("update asg %v from %v to %v", v.name, v.actual, v.desired))
+		}
+	}
+
+	if status.Desired.InstanceType != "" && status.Desired.InstanceType != status.Actual.InstanceType {
+		plan = append(plan, fmt.Sprintf("new launch template version with instance type %v", status.Desired.InstanceType))
+	}
+
+	if status.StaleInstances > 0 {
+		strategy := model.Strategy
+		if strategy == "" {
+			strategy = "rolling"
+		}
+		plan = append(plan, fmt.Sprintf("replace %v instances not on version %v (%v)", status.StaleInstances, status.Actual.TargetVersion, strategy))
+	}
+
+	if status.PendingAmi != "" {
+		plan = append(plan, fmt.Sprintf("ami %v waits for approval", status.PendingAmi))
+	}
+
+	if len(plan) == 0 {
+		plan = append(plan, "no changes")
+	}
+
+	return append(plan, "launch template drift (ami, user data, tags) is checked by apply")
+}
@@ -0,0 +1,37 @@
+package apis
+
+import "time"
+
+// Outcomes of an audited action
+const (
+	AuditSucceeded = "succeeded"
+	AuditFailed    = "failed"
+)
+
+// AuditEvent represents one mutating call made against aws, who made it and what it changed
+type AuditEvent struct {
+	Time time.Time `json:"time"`
+	// Operator is the arn of the aws identity the manager runs as
+	Operator  string `json:"operator"`
+	NodeGroup string `json:"nodeGroup,omitempty"`
+	// Action is the aws operation, e.g. UpdateAutoScalingGroup
+	Action   string      `json:"action"`
+	Resource string      `json:"resource"`
+	Before   interface{} `json:"before,omitempty"`
+	After    interface{} `json:"after,omitempty"`
+	Outcome  string      `json:"outcome"`
+	Error    string      `json:"error,omitempty"`
+}
+
+// AuditOptions represents where audit events are written
+// +kubebuilder:object:generate=true
+type AuditOptions struct {
+	// Sink is one of file, the default, s3 or cloudwatch
+	Sink     string `yaml:"sink" json:"sink,omitempty"`
+	Path     string `yaml:"path" json:"path,omitempty"`
+	Bucket   string `yaml:"bucket" json:"bucket,omitempty"`
+	Prefix   string `yaml:"prefix" json:"prefix,omitempty"`
+	LogGroup string `yaml:"logGroup" json:"logGroup,omitempty"`
+	// LogStream defaults to the node group name
+	LogStream string `yaml:"logStream" json:"logStream,omitempty"`
+}
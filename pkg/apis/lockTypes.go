@@ -0,0 +1,13 @@
+package apis
+
+// LockOptions represents the lock held on a node group while it is mutated, so concurrent runs do not interfere
+// +kubebuilder:object:generate=true
+type LockOptions struct {
+	// Backend is one of file, the default, dynamodb or kubernetes, the default of the operator which rejects file
+	Backend string `yaml:"backend" json:"backend,omitempty"`
+	Path    string `yaml:"path" json:"path,omitempty"`
+	Table   string `yaml:"table" json:"table,omitempty"`
+	// Namespace holds the Lease objects of the kubernetes backend
+	Namespace  string `yaml:"namespace" json:"namespace,omitempty"`
+	TTLSeconds int64  `yaml:"ttlSeconds" json:"ttlSeconds,omitempty"`
+}
@@ -14,51 +14,147 @@ type LaunchConfigurationOptions struct {
 }
 
 // LaunchTemplateOptions represents all the fields to create a Launch config
+// +kubebuilder:object:generate=true
 type LaunchTemplateOptions struct {
-	Name               string            `yaml:"name"`
-	AmiID              string            `yaml:"-"`
-	PublicIps          bool              `yaml:"publicIps"`
-	InstanceType       string            `yaml:"instanceType"`
-	KeyName            string            `yaml:"keyName"`
-	SecurityGroups     []*string         `yaml:"securityGroups"`
-	UserData           string            `yaml:"userData"`
-	IamInstanceProfile string            `yaml:"iamInstanceProfile"`
-	Tags               map[string]string `yaml:"tags"`
-	EbsVolume          `yaml:"ebs"`
+	Name                   string             `yaml:"name" json:"name,omitempty"`
+	AmiID                  string             `yaml:"-" json:"-"`
+	PublicIps              bool               `yaml:"publicIps" json:"publicIps,omitempty"`
+	InstanceType           string             `yaml:"instanceType" json:"instanceType,omitempty"`
+	KeyName                string             `yaml:"keyName" json:"keyName,omitempty"`
+	SecurityGroups         []*string          `yaml:"securityGroups" json:"securityGroups,omitempty"`
+	SecurityGroupSelectors []ResourceSelector `yaml:"securityGroupSelectors" json:"securityGroupSelectors,omitempty"`
+	UserData               string             `yaml:"userData" json:"userData,omitempty"`
+	IamInstanceProfile     string             `yaml:"iamInstanceProfile" json:"iamInstanceProfile,omitempty"`
+	Tags                   map[string]string  `yaml:"tags" json:"tags,omitempty"`
+	ResourceTags           map[string]string  `yaml:"-" json:"-"`
+	RetainVersions         int                `yaml:"retainVersions" json:"retainVersions,omitempty"`
+	EbsVolume              `yaml:"ebs" json:"ebs,omitempty"`
 }
 
 // AutoScalingGroupOptions represents all the fields to create a AutoScalingGroup config
+// +kubebuilder:object:generate=true
 type AutoScalingGroupOptions struct {
-	Name               string            `yaml:"name"`
-	Subnets            string            `yaml:"subnets"`
-	DesiredInstances   int64             `yaml:"desired"`
-	MaxInstances       int64             `yaml:"max"`
-	MinInstances       int64             `yaml:"min"`
-	LaunchConfName     string            `yaml:"-"`
-	LaunchTemplateName string            `yaml:"-"`
-	Tags               map[string]string `yaml:"tags"`
+	Name               string             `yaml:"name" json:"name,omitempty"`
+	Subnets            string             `yaml:"subnets" json:"subnets,omitempty"`
+	SubnetSelectors    []ResourceSelector `yaml:"subnetSelectors" json:"subnetSelectors,omitempty"`
+	DesiredInstances   int64              `yaml:"desired" json:"desired,omitempty"`
+	MaxInstances       int64              `yaml:"max" json:"max,omitempty"`
+	MinInstances       int64              `yaml:"min" json:"min,omitempty"`
+	LaunchConfName     string             `yaml:"-" json:"-"`
+	LaunchTemplateName string             `yaml:"-" json:"-"`
+	// LaunchTemplateVersion is the version the asg references, resolved by the reconciler from the VersionPolicy
+	LaunchTemplateVersion string `yaml:"-" json:"-"`
+	// VersionPolicy is either "pinned" to reference the explicit version number, the default, or "default" for $Default
+	VersionPolicy string            `yaml:"versionPolicy" json:"versionPolicy,omitempty"`
+	Tags          map[string]string `yaml:"tags" json:"tags,omitempty"`
+}
+
+// ResourceSelector represents a lookup of an ec2 resource by id, Name tag or arbitrary tag filters.
+// An empty tag value matches any resource carrying the tag key.
+// +kubebuilder:object:generate=true
+type ResourceSelector struct {
+	ID   string            `yaml:"id" json:"id,omitempty"`
+	Name string            `yaml:"name" json:"name,omitempty"`
+	Tags map[string]string `yaml:"tags" json:"tags,omitempty"`
 }
 
 // EbsVolume represents
+// +kubebuilder:object:generate=true
 type EbsVolume struct {
-	VolumeType string `yaml:"volumeType"`
-	VolumeSize int64  `yaml:"volumeSize"`
+	VolumeType string `yaml:"volumeType" json:"volumeType,omitempty"`
+	VolumeSize int64  `yaml:"volumeSize" json:"volumeSize,omitempty"`
 }
 
 // Ec2Options represents
+// +kubebuilder:object:generate=true
 type Ec2Options struct {
-	LaunchTemplateOptions `yaml:"launchTemplate"`
-	NamePrefix            string `yaml:"string"`
+	LaunchTemplateOptions `yaml:"launchTemplate" json:"launchTemplate,omitempty"`
+	NamePrefix            string `yaml:"namePrefix" json:"namePrefix,omitempty"`
 }
 
 // SSMOptions represents
+// +kubebuilder:object:generate=true
 type SSMOptions struct {
-	AutoUpgradeAmiChange bool `yaml:"autoAmiUpgrade"`
+	AutoUpgradeAmiChange bool `yaml:"autoAmiUpgrade" json:"autoAmiUpgrade,omitempty"`
+}
+
+// RolloutOptions represents how instances running a stale launch template version are replaced
+// +kubebuilder:object:generate=true
+type RolloutOptions struct {
+	// Strategy is either "rolling", replacing instances in place, the default, or "blue-green"
+	Strategy string `yaml:"strategy" json:"strategy,omitempty"`
+	// PauseBeforeCutover stops a blue/green replacement once the new asg is ready, until it is promoted or aborted
+	PauseBeforeCutover bool `yaml:"pauseBeforeCutover" json:"pauseBeforeCutover,omitempty"`
+	// AutoRollback restores the previous launch template version when new instances fail health checks
+	AutoRollback         bool          `yaml:"autoRollback" json:"autoRollback,omitempty"`
+	HealthTimeoutSeconds int64         `yaml:"healthTimeoutSeconds" json:"healthTimeoutSeconds,omitempty"`
+	Canary               CanaryOptions `yaml:"canary" json:"canary,omitempty"`
+	// RequireAmiApproval keeps the current ami until a newer one is approved through the api
+	RequireAmiApproval bool `yaml:"requireAmiApproval" json:"requireAmiApproval,omitempty"`
+}
+
+// CanaryOptions represents the first stage of a rolling replacement, the rest only continues once the health gates pass
+// +kubebuilder:object:generate=true
+type CanaryOptions struct {
+	Instances   int   `yaml:"instances" json:"instances,omitempty"`
+	SoakSeconds int64 `yaml:"soakSeconds" json:"soakSeconds,omitempty"`
+	// HTTPCheckURL must answer with a 2xx status when set
+	HTTPCheckURL string `yaml:"httpCheckUrl" json:"httpCheckUrl,omitempty"`
+	// CloudWatchAlarms must not be in the ALARM state
+	CloudWatchAlarms []string `yaml:"cloudWatchAlarms" json:"cloudWatchAlarms,omitempty"`
+}
+
+// NamingOptions represents how names of created resources are generated and which manager owns them
+// +kubebuilder:object:generate=true
+type NamingOptions struct {
+	// Template supports the {cluster} and {group} placeholders
+	Template  string `yaml:"template" json:"template,omitempty"`
+	ManagerID string `yaml:"managerId" json:"managerId,omitempty"`
 }
 
 // OperatorModel represents
+// +kubebuilder:object:generate=true
 type OperatorModel struct {
-	Ec2Options              `yaml:"ec2"`
-	AutoScalingGroupOptions `yaml:"asg"`
-	SSMOptions              `yaml:"ssm"`
+	Ec2Options              `yaml:"ec2" json:"ec2,omitempty"`
+	AutoScalingGroupOptions `yaml:"asg" json:"asg,omitempty"`
+	SSMOptions              `yaml:"ssm" json:"ssm,omitempty"`
+	NamingOptions           `yaml:"naming" json:"naming,omitempty"`
+	RolloutOptions          `yaml:"rollout" json:"rollout,omitempty"`
+	StateOptions            `yaml:"state" json:"state,omitempty"`
+	LockOptions             `yaml:"lock" json:"lock,omitempty"`
+	AuditOptions            `yaml:"audit" json:"audit,omitempty"`
+	NotificationOptions     `yaml:"notifications" json:"notifications,omitempty"`
+	OrphanOptions           `yaml:"orphans" json:"orphans,omitempty"`
+	// DependsOn lists node groups that must reconcile successfully before this one starts
+	DependsOn   []string `yaml:"dependsOn" json:"dependsOn,omitempty"`
+	ClusterName string   `yaml:"cluster" json:"cluster,omitempty"`
+	VpcID       string   `yaml:"vpcId" json:"vpcId,omitempty"`
+	// ResourceNamespace is the namespace of the NodeGroup resource in operator mode, it is not configured
+	ResourceNamespace string `yaml:"-" json:"-"`
+}
+
+// NodeGroupName represents the name identifying the node group, the configured asg name
+func (m *OperatorModel) NodeGroupName() string {
+	return m.AutoScalingGroupOptions.Name
+}
+
+// NodeGroupKey represents the key of the node group in locks and rollout state, the asg name prefixed with the
+// namespace of its resource in operator mode so resources of different namespaces do not share it
+func (m *OperatorModel) NodeGroupKey() string {
+	return NodeGroupKey(m.ResourceNamespace, m.NodeGroupName())
+}
+
+// NodeGroupKey represents the key of the named node group of a namespace, the name alone outside operator mode
+func NodeGroupKey(namespace string, nodeGroup string) string {
+	if namespace == "" {
+		return nodeGroup
+	}
+
+	return namespace + "/" + nodeGroup
+}
+
+// ManagerConfig represents a config file with several node groups, reconciled in parallel
+type ManagerConfig struct {
+	Concurrency int             `yaml:"concurrency" json:"concurrency,omitempty"`
+	NodeGroups  []OperatorModel `yaml:"nodeGroups" json:"nodeGroups,omitempty"`
 }
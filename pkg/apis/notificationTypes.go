@@ -0,0 +1,47 @@
+package apis
+
+import "time"
+
+// Rollout events notifiers can be subscribed to
+const (
+	RolloutStarted = "rolloutStarted"
+	BatchCompleted = "batchCompleted"
+	RolloutFailed  = "rolloutFailed"
+	RolledBack     = "rolledBack"
+	AmiAvailable   = "amiAvailable"
+)
+
+// NotificationEvent represents a rollout event sent to the configured notifiers, the data of message templates
+type NotificationEvent struct {
+	Type      string    `json:"type"`
+	Time      time.Time `json:"time"`
+	NodeGroup string    `json:"nodeGroup"`
+	Message   string    `json:"message"`
+	Asg       string    `json:"asg,omitempty"`
+	Version   string    `json:"version,omitempty"`
+	Ami       string    `json:"ami,omitempty"`
+	// Replaced and Remaining count the instances of the rollout
+	Replaced  int    `json:"replaced,omitempty"`
+	Remaining int    `json:"remaining,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+// NotifierOptions represents one destination of rollout events
+// +kubebuilder:object:generate=true
+type NotifierOptions struct {
+	// Type is one of webhook, slack or sns
+	Type     string `yaml:"type" json:"type,omitempty"`
+	URL      string `yaml:"url" json:"url,omitempty"`
+	TopicArn string `yaml:"topicArn" json:"topicArn,omitempty"`
+	// Events limits the events sent, all events are sent when empty
+	Events []string `yaml:"events" json:"events,omitempty"`
+	// Template is a go text/template rendered with the event, it replaces the default message
+	Template string            `yaml:"template" json:"template,omitempty"`
+	Headers  map[string]string `yaml:"headers" json:"headers,omitempty"`
+}
+
+// NotificationOptions represents where rollout events of a node group are sent
+// +kubebuilder:object:generate=true
+type NotificationOptions struct {
+	Notifiers []NotifierOptions `yaml:"notifiers" json:"notifiers,omitempty"`
+}
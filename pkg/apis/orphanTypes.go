@@ -0,0 +1,23 @@
+package apis
+
+import "time"
+
+// OrphanInstance represents an instance carrying the ownership tags of a node group that is not in any managed asg
+type OrphanInstance struct {
+	InstanceID   string        `json:"instanceId" yaml:"instanceId"`
+	NodeGroup    string        `json:"nodeGroup" yaml:"nodeGroup"`
+	InstanceType string        `json:"instanceType" yaml:"instanceType"`
+	State        string        `json:"state" yaml:"state"`
+	LaunchTime   time.Time     `json:"launchTime" yaml:"launchTime"`
+	Age          time.Duration `json:"age" yaml:"age"`
+	// EstimatedCost is the on demand price in USD for the time the instance has been running, zero when unknown
+	EstimatedCost float64 `json:"estimatedCost" yaml:"estimatedCost"`
+}
+
+// OrphanOptions represents what the reconciler does with orphaned instances it finds
+// +kubebuilder:object:generate=true
+type OrphanOptions struct {
+	Terminate bool `yaml:"terminate" json:"terminate,omitempty"`
+	// GracePeriodSeconds is how long after launch an orphaned instance is left alone, 30 minutes by default
+	GracePeriodSeconds int `yaml:"gracePeriodSeconds" json:"gracePeriodSeconds,omitempty"`
+}
@@ -0,0 +1,32 @@
+package apis
+
+import "time"
+
+// Steps an instance goes through while it is replaced
+const (
+	InstanceDetaching  = "detaching"
+	InstanceDetached   = "detached"
+	InstanceDrained    = "drained"
+	InstanceTerminated = "terminated"
+)
+
+// RolloutState represents the progress of replacing the stale instances of a node group, persisted between runs
+type RolloutState struct {
+	NodeGroup     string `json:"nodeGroup"`
+	AsgName       string `json:"asgName"`
+	TargetVersion string `json:"targetVersion"`
+	// Instances maps the id of each instance being replaced to the last step it completed
+	Instances map[string]string `json:"instances"`
+	UpdatedAt time.Time         `json:"updatedAt"`
+}
+
+// StateOptions represents where rollout state is stored
+// +kubebuilder:object:generate=true
+type StateOptions struct {
+	// Backend is one of file, the default, s3 or dynamodb, the operator requires s3 or dynamodb
+	Backend string `yaml:"backend" json:"backend,omitempty"`
+	Path    string `yaml:"path" json:"path,omitempty"`
+	Bucket  string `yaml:"bucket" json:"bucket,omitempty"`
+	Prefix  string `yaml:"prefix" json:"prefix,omitempty"`
+	Table   string `yaml:"table" json:"table,omitempty"`
+}
@@ -0,0 +1,100 @@
+package apis
+
+import (
+	"reflect"
+	"strings"
+	"time"
+)
+
+// schemaEnums lists the allowed values of string settings by their path in a node group
+var schemaEnums = map[string][]string{
+	"asg.versionPolicy":                 {"pinned", "default"},
+	"rollout.strategy":                  {"rolling", "blue-green"},
+	"ec2.launchTemplate.ebs.volumeType": ebsVolumeTypes,
+	"state.backend":                     {"file", "s3", "dynamodb"},
+	"lock.backend":                      {"file", "dynamodb", "kubernetes"},
+	"audit.sink":                        {"file", "s3", "cloudwatch"},
+	"notifications.notifiers.type":      {"webhook", "slack", "sns"},
+	"notifications.notifiers.events":    {RolloutStarted, BatchCompleted, RolloutFailed, RolledBack, AmiAvailable},
+}
+
+// ConfigSchema represents the json schema of a config file, either a single node group or several under nodeGroups.
+// Editors use it to complete and check the yaml, unknown fields are rejected as they are when loading.
+func ConfigSchema() map[string]interface{} {
+	nodeGroup := typeSchema(reflect.TypeOf(OperatorModel{}), "")
+	properties := nodeGroup["properties"].(map[string]interface{})
+
+	manager := typeSchema(reflect.TypeOf(ManagerConfig{}), "")
+	for k, v := range manager["properties"].(map[string]interface{}) {
+		properties[k] = v
+	}
+
+	nodeGroup["$schema"] = "http://json-schema.org/draft-07/schema#"
+	nodeGroup["title"] = "aws-node-group-manager config"
+	return nodeGroup
+}
+
+func typeSchema(t reflect.Type, path string) map[string]interface{} {
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t == reflect.TypeOf(time.Time{}) {
+		return map[string]interface{}{"type": "string", "format": "date-time"}
+	}
+
+	switch t.Kind() {
+	case reflect.String:
+		schema := map[string]interface{}{"type": "string"}
+		if values, ok := schemaEnums[path]; ok {
+			schema["enum"] = values
+		}
+		return schema
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		return map[string]interface{}{"type": "array", "items": typeSchema(t.Elem(), path)}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": typeSchema(t.Elem(), path)}
+	case reflect.Struct:
+		properties := make(map[string]interface{})
+		addProperties(properties, t, path)
+		return map[string]interface{}{"type": "object", "properties": properties, "additionalProperties": false}
+	}
+
+	return map[string]interface{}{}
+}
+
+// addProperties adds the fields of the struct the way yaml reads them: inlined structs share the properties
+// of their parent, embedded structs without a name are keyed by their lowercased type name
+func addProperties(properties map[string]interface{}, t reflect.Type, path string) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.PkgPath != "" {
+			continue
+		}
+
+		tag := strings.Split(field.Tag.Get("yaml"), ",")
+		name := tag[0]
+		if name == "-" {
+			continue
+		}
+		if len(tag) > 1 && tag[1] == "inline" {
+			addProperties(properties, field.Type, path)
+			continue
+		}
+		if name == "" {
+			name = strings.ToLower(field.Name)
+		}
+
+		propertyPath := name
+		if path != "" {
+			propertyPath = path + "." + name
+		}
+		properties[name] = typeSchema(field.Type, propertyPath)
+	}
+}
@@ -0,0 +1,65 @@
+package apis
+
+import (
+	"reflect"
+	"testing"
+)
+
+// schemaAt walks the properties of the schema down the path
+func schemaAt(t *testing.T, schema map[string]interface{}, path ...string) map[string]interface{} {
+	for _, v := range path {
+		if items, ok := schema["items"].(map[string]interface{}); ok {
+			schema = items
+		}
+		properties, ok := schema["properties"].(map[string]interface{})
+		if !ok {
+			t.Fatalf("no properties above %v", v)
+		}
+		if schema, ok = properties[v].(map[string]interface{}); !ok {
+			t.Fatalf("no property %v", v)
+		}
+	}
+
+	return schema
+}
+
+func TestConfigSchema(t *testing.T) {
+	schema := ConfigSchema()
+
+	tests := []struct {
+		name     string
+		path     []string
+		expected map[string]interface{}
+	}{
+		{name: "string", path: []string{"asg", "name"}, expected: map[string]interface{}{"type": "string"}},
+		{name: "integer", path: []string{"asg", "desired"}, expected: map[string]interface{}{"type": "integer"}},
+		{name: "boolean", path: []string{"rollout", "requireAmiApproval"}, expected: map[string]interface{}{"type": "boolean"}},
+		{name: "enum", path: []string{"rollout", "strategy"}, expected: map[string]interface{}{"type": "string", "enum": []string{"rolling", "blue-green"}}},
+		{name: "embedded struct", path: []string{"ec2", "launchTemplate", "ebs", "volumeType"}, expected: map[string]interface{}{"type": "string", "enum": ebsVolumeTypes}},
+		{name: "map", path: []string{"asg", "tags"}, expected: map[string]interface{}{"type": "object", "additionalProperties": map[string]interface{}{"type": "string"}}},
+		{name: "enum in a list", path: []string{"notifications", "notifiers", "events"}, expected: map[string]interface{}{
+			"type":  "array",
+			"items": map[string]interface{}{"type": "string", "enum": []string{RolloutStarted, BatchCompleted, RolloutFailed, RolledBack, AmiAvailable}},
+		}},
+		{name: "node group list", path: []string{"nodeGroups", "asg", "name"}, expected: map[string]interface{}{"type": "string"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if actual := schemaAt(t, schema, tt.path...); !reflect.DeepEqual(actual, tt.expected) {
+				t.Fatalf("schema of %v is %v, expected %v", tt.path, actual, tt.expected)
+			}
+		})
+	}
+
+	// unknown keys are rejected as they are when loading, fields the config does not set are left out
+	if schema["additionalProperties"] != false || schemaAt(t, schema, "asg")["additionalProperties"] != false {
+		t.Fatal("the schema allows unknown keys")
+	}
+	template := schemaAt(t, schema, "ec2", "launchTemplate")["properties"].(map[string]interface{})
+	for _, v := range []string{"AmiID", "amiId", "ResourceTags", "resourceTags", "EbsVolume"} {
+		if _, ok := template[v]; ok {
+			t.Fatalf("the launch template schema has the field %v", v)
+		}
+	}
+}
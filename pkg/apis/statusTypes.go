@@ -0,0 +1,61 @@
+package apis
+
+import "time"
+
+// NodeGroupStatus represents the configured spec of a node group next to what is running in aws
+type NodeGroupStatus struct {
+	NodeGroup string          `json:"nodeGroup" yaml:"nodeGroup"`
+	Desired   NodeGroupSpec   `json:"desired" yaml:"desired"`
+	Actual    *NodeGroupSpec  `json:"actual,omitempty" yaml:"actual,omitempty"`
+	Instances []InstanceState `json:"instances" yaml:"instances"`
+	// InstanceGroups counts the instances by version, lifecycle and health state
+	InstanceGroups []InstanceGroup `json:"instanceGroups" yaml:"instanceGroups"`
+	// StaleInstances counts the instances not running the target version
+	StaleInstances int           `json:"staleInstances" yaml:"staleInstances"`
+	Rollout        *RolloutState `json:"rollout,omitempty" yaml:"rollout,omitempty"`
+	Paused         bool          `json:"paused" yaml:"paused"`
+	// PendingAmi is a newer ami waiting to be approved
+	PendingAmi string `json:"pendingAmi,omitempty" yaml:"pendingAmi,omitempty"`
+	// Activities are the scaling activities of the asg still in progress
+	Activities []ScalingActivity `json:"activities" yaml:"activities"`
+}
+
+// NodeGroupSpec represents the asg and launch template settings of a node group
+type NodeGroupSpec struct {
+	AsgName          string `json:"asgName" yaml:"asgName"`
+	LaunchTemplate   string `json:"launchTemplate" yaml:"launchTemplate"`
+	MinInstances     int64  `json:"minInstances" yaml:"minInstances"`
+	MaxInstances     int64  `json:"maxInstances" yaml:"maxInstances"`
+	DesiredInstances int64  `json:"desiredInstances" yaml:"desiredInstances"`
+	InstanceType     string `json:"instanceType,omitempty" yaml:"instanceType,omitempty"`
+	// AmiID of the desired spec is the recommended eks ami
+	AmiID string `json:"amiId,omitempty" yaml:"amiId,omitempty"`
+	// TargetVersion is the launch template version instances are replaced with, the default version
+	TargetVersion string `json:"targetVersion,omitempty" yaml:"targetVersion,omitempty"`
+	LatestVersion string `json:"latestVersion,omitempty" yaml:"latestVersion,omitempty"`
+}
+
+// InstanceGroup represents the number of instances sharing a launch template version, lifecycle and health state
+type InstanceGroup struct {
+	Version        string `json:"version" yaml:"version"`
+	LifecycleState string `json:"lifecycleState" yaml:"lifecycleState"`
+	HealthStatus   string `json:"healthStatus" yaml:"healthStatus"`
+	Count          int    `json:"count" yaml:"count"`
+}
+
+// ScalingActivity represents a scaling activity of the node group asg
+type ScalingActivity struct {
+	Description string    `json:"description" yaml:"description"`
+	Status      string    `json:"status" yaml:"status"`
+	Progress    int64     `json:"progress" yaml:"progress"`
+	StartTime   time.Time `json:"startTime" yaml:"startTime"`
+}
+
+// InstanceState represents an instance of the node group asg and the launch template version it runs
+type InstanceState struct {
+	InstanceID     string `json:"instanceId" yaml:"instanceId"`
+	Version        string `json:"version" yaml:"version"`
+	LifecycleState string `json:"lifecycleState" yaml:"lifecycleState"`
+	HealthStatus   string `json:"healthStatus" yaml:"healthStatus"`
+	Stale          bool   `json:"stale" yaml:"stale"`
+}
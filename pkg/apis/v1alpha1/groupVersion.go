@@ -0,0 +1,22 @@
+// Package v1alpha1 holds the NodeGroup custom resource reconciled by the operator mode of the manager
+// +kubebuilder:object:generate=true
+// +groupName=nodegroupmanager.anyo.io
+package v1alpha1
+
+//go:generate controller-gen object crd rbac:roleName=aws-node-group-manager paths=../../... output:crd:artifacts:config=../../../config/crd output:rbac:artifacts:config=../../../config/rbac
+
+import (
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/controller-runtime/pkg/scheme"
+)
+
+var (
+	// GroupVersion is the api group and version of the NodeGroup resource
+	GroupVersion = schema.GroupVersion{Group: "nodegroupmanager.anyo.io", Version: "v1alpha1"}
+
+	// SchemeBuilder registers the types of the group
+	SchemeBuilder = &scheme.Builder{GroupVersion: GroupVersion}
+
+	// AddToScheme adds the types of the group to a scheme
+	AddToScheme = SchemeBuilder.AddToScheme
+)
@@ -0,0 +1,85 @@
+package v1alpha1
+
+import (
+	apiTypes "github.com/anyo/aws-node-group-manager/pkg/apis"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Condition types of a NodeGroup
+const (
+	// ConditionReady is true once the last reconcile of the current generation succeeded
+	ConditionReady = "Ready"
+	// ConditionProgressing is true while instances still run a stale launch template version
+	ConditionProgressing = "Progressing"
+)
+
+// Finalizer tears down the asg and launch template of a NodeGroup before it is deleted
+const Finalizer = "nodegroupmanager.anyo.io/teardown"
+
+// NodeGroupSpec represents the node group config, the same settings as a node group of the config file. The asg name
+// defaults to the name of the resource.
+type NodeGroupSpec struct {
+	apiTypes.OperatorModel `json:",inline"`
+}
+
+// NodeGroupStatus represents what the last reconcile observed in aws
+type NodeGroupStatus struct {
+	// ObservedGeneration is the generation of the spec the status reflects
+	ObservedGeneration int64              `json:"observedGeneration,omitempty"`
+	Conditions         []metav1.Condition `json:"conditions,omitempty"`
+	AsgName            string             `json:"asgName,omitempty"`
+	LaunchTemplate     string             `json:"launchTemplate,omitempty"`
+	// LaunchTemplateVersion is the version instances are replaced with
+	LaunchTemplateVersion string `json:"launchTemplateVersion,omitempty"`
+	AmiID                 string `json:"amiId,omitempty"`
+	// PendingAmi is a newer ami waiting to be approved
+	PendingAmi     string       `json:"pendingAmi,omitempty"`
+	Instances      int          `json:"instances,omitempty"`
+	StaleInstances int          `json:"staleInstances,omitempty"`
+	LastReconciled *metav1.Time `json:"lastReconciled,omitempty"`
+}
+
+// NodeGroup represents a node group reconciled by the operator
+// +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+// +kubebuilder:resource:shortName=ng
+// +kubebuilder:printcolumn:name="Asg",type=string,JSONPath=`.status.asgName`
+// +kubebuilder:printcolumn:name="Version",type=string,JSONPath=`.status.launchTemplateVersion`
+// +kubebuilder:printcolumn:name="Stale",type=integer,JSONPath=`.status.staleInstances`
+// +kubebuilder:printcolumn:name="Ready",type=string,JSONPath=`.status.conditions[?(@.type=="Ready")].status`
+// +kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
+type NodeGroup struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   NodeGroupSpec   `json:"spec,omitempty"`
+	Status NodeGroupStatus `json:"status,omitempty"`
+}
+
+// NodeGroupList represents a list of NodeGroups
+// +kubebuilder:object:root=true
+type NodeGroupList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []NodeGroup `json:"items"`
+}
+
+// Model represents the node group config of the resource, the asg name defaulting to the resource name, the lock
+// backend defaulting to kubernetes and the namespace of the resource keying its locks and rollout state
+func (n *NodeGroup) Model() apiTypes.OperatorModel {
+	model := *n.Spec.OperatorModel.DeepCopy()
+	if model.AutoScalingGroupOptions.Name == "" {
+		model.AutoScalingGroupOptions.Name = n.Name
+	}
+	if model.LockOptions.Backend == "" {
+		model.LockOptions.Backend = "kubernetes"
+	}
+	model.ResourceNamespace = n.Namespace
+
+	return model
+}
+
+func init() {
+	SchemeBuilder.Register(&NodeGroup{}, &NodeGroupList{})
+}
@@ -0,0 +1,132 @@
+package apis
+
+import (
+	"fmt"
+	"strings"
+)
+
+// limits aws puts on tags, and the volume types ebs accepts
+const (
+	maxTags           = 50
+	maxTagKeyLength   = 128
+	maxTagValueLength = 256
+	maxEbsVolumeSize  = 16384
+)
+
+var ebsVolumeTypes = []string{"standard", "gp2", "gp3", "io1", "io2", "st1", "sc1"}
+
+// ValidationErrors represents every problem found in a config, reported together rather than one at a time
+type ValidationErrors []error
+
+func (e ValidationErrors) Error() string {
+	messages := []string{}
+	for _, v := range e {
+		messages = append(messages, v.Error())
+	}
+
+	return strings.Join(messages, "; ")
+}
+
+// Validate represents checking the node group settings that do not need aws, reservedTags is the number of tags
+// the manager adds to the ones configured
+func (m *OperatorModel) Validate(reservedTags int) ValidationErrors {
+	errs := ValidationErrors{}
+	fail := func(format string, args ...interface{}) {
+		errs = append(errs, fmt.Errorf("node group %v: "+format, append([]interface{}{m.NodeGroupName()}, args...)...))
+	}
+
+	asg := m.AutoScalingGroupOptions
+	if asg.Name == "" {
+		fail("asg.name is required")
+	}
+	if asg.MinInstances < 0 {
+		fail("asg.min must not be negative, got %v", asg.MinInstances)
+	}
+	if asg.MaxInstances <= 0 {
+		fail("asg.max must be above zero, got %v", asg.MaxInstances)
+	}
+	if asg.MinInstances > asg.DesiredInstances || asg.DesiredInstances > asg.MaxInstances {
+		fail("asg sizes must satisfy min <= desired <= max, got %v <= %v <= %v", asg.MinInstances, asg.DesiredInstances, asg.MaxInstances)
+	}
+	if !oneOf(asg.VersionPolicy, "", "pinned", "default") {
+		fail("asg.versionPolicy must be pinned or default, got %v", asg.VersionPolicy)
+	}
+	for _, err := range validateTags("asg.tags", asg.Tags, reservedTags) {
+		fail("%v", err)
+	}
+
+	template := m.LaunchTemplateOptions
+	if template.InstanceType == "" {
+		fail("ec2.launchTemplate.instanceType is required")
+	}
+	if template.VolumeType != "" && !oneOf(template.VolumeType, ebsVolumeTypes...) {
+		fail("ec2.launchTemplate.ebs.volumeType must be one of %v, got %v", strings.Join(ebsVolumeTypes, ", "), template.VolumeType)
+	}
+	if template.VolumeSize < 1 || template.VolumeSize > maxEbsVolumeSize {
+		fail("ec2.launchTemplate.ebs.volumeSize must be between 1 and %v GiB, got %v", maxEbsVolumeSize, template.VolumeSize)
+	}
+	if template.RetainVersions < 0 {
+		fail("ec2.launchTemplate.retainVersions must not be negative, got %v", template.RetainVersions)
+	}
+	for _, err := range validateTags("ec2.launchTemplate.tags", template.Tags, 0) {
+		fail("%v", err)
+	}
+
+	rollout := m.RolloutOptions
+	if !oneOf(rollout.Strategy, "", "rolling", "blue-green") {
+		fail("rollout.strategy must be rolling or blue-green, got %v", rollout.Strategy)
+	}
+	if rollout.HealthTimeoutSeconds < 0 {
+		fail("rollout.healthTimeoutSeconds must not be negative, got %v", rollout.HealthTimeoutSeconds)
+	}
+	if rollout.Canary.Instances < 0 || int64(rollout.Canary.Instances) > asg.DesiredInstances {
+		fail("rollout.canary.instances must be between 0 and asg.desired, got %v", rollout.Canary.Instances)
+	}
+	if rollout.Canary.SoakSeconds < 0 {
+		fail("rollout.canary.soakSeconds must not be negative, got %v", rollout.Canary.SoakSeconds)
+	}
+	if m.OrphanOptions.GracePeriodSeconds < 0 {
+		fail("orphans.gracePeriodSeconds must not be negative, got %v", m.OrphanOptions.GracePeriodSeconds)
+	}
+
+	for _, v := range append(append([]ResourceSelector{}, template.SecurityGroupSelectors...), asg.SubnetSelectors...) {
+		if v.ID == "" && v.Name == "" && len(v.Tags) == 0 {
+			fail("empty selector, one of id, name or tags is required")
+		}
+	}
+
+	return errs
+}
+
+func validateTags(field string, tags map[string]string, reserved int) []error {
+	errs := []error{}
+	if len(tags)+reserved > maxTags {
+		errs = append(errs, fmt.Errorf("%v has %v tags, at most %v are allowed next to the %v the manager adds", field, len(tags), maxTags-reserved, reserved))
+	}
+
+	for k, v := range tags {
+		switch {
+		case k == "":
+			errs = append(errs, fmt.Errorf("%v has an empty key", field))
+		case len(k) > maxTagKeyLength:
+			errs = append(errs, fmt.Errorf("%v key %v is longer than %v characters", field, k, maxTagKeyLength))
+		case strings.HasPrefix(strings.ToLower(k), "aws:"):
+			errs = append(errs, fmt.Errorf("%v key %v uses the reserved aws: prefix", field, k))
+		}
+		if len(v) > maxTagValueLength {
+			errs = append(errs, fmt.Errorf("%v value of %v is longer than %v characters", field, k, maxTagValueLength))
+		}
+	}
+
+	return errs
+}
+
+func oneOf(value string, allowed ...string) bool {
+	for _, v := range allowed {
+		if value == v {
+			return true
+		}
+	}
+
+	return false
+}
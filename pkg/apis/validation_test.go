@@ -0,0 +1,70 @@
+package apis
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// validNodeGroup returns a node group passing Validate, the cases change one setting
+func validNodeGroup() OperatorModel {
+	m := OperatorModel{}
+	m.AutoScalingGroupOptions = AutoScalingGroupOptions{Name: "workers", MinInstances: 1, DesiredInstances: 2, MaxInstances: 3}
+	m.LaunchTemplateOptions = LaunchTemplateOptions{InstanceType: "m5.large", EbsVolume: EbsVolume{VolumeType: "gp3", VolumeSize: 50}}
+	return m
+}
+
+func TestValidate(t *testing.T) {
+	manyTags := make(map[string]string)
+	for i := 0; i < 48; i++ {
+		manyTags[fmt.Sprintf("tag-%v", i)] = "value"
+	}
+
+	tests := []struct {
+		name   string
+		change func(m *OperatorModel)
+		// errs are substrings of the expected errors, in order
+		errs []string
+	}{
+		{name: "valid", change: func(m *OperatorModel) {}},
+		{name: "missing asg name", change: func(m *OperatorModel) { m.AutoScalingGroupOptions.Name = "" }, errs: []string{"asg.name is required"}},
+		{name: "negative min", change: func(m *OperatorModel) { m.MinInstances = -1 }, errs: []string{"asg.min must not be negative"}},
+		{name: "zero max", change: func(m *OperatorModel) { m.MaxInstances, m.DesiredInstances, m.MinInstances = 0, 0, 0 }, errs: []string{"asg.max must be above zero"}},
+		{name: "desired above max", change: func(m *OperatorModel) { m.DesiredInstances = 4 }, errs: []string{"min <= desired <= max, got 1 <= 4 <= 3"}},
+		{name: "unknown version policy", change: func(m *OperatorModel) { m.VersionPolicy = "latest" }, errs: []string{"asg.versionPolicy must be pinned or default"}},
+		{name: "too many tags", change: func(m *OperatorModel) { m.AutoScalingGroupOptions.Tags = manyTags }, errs: []string{"asg.tags has 48 tags, at most 45 are allowed"}},
+		{name: "reserved tag prefix", change: func(m *OperatorModel) { m.AutoScalingGroupOptions.Tags = map[string]string{"AWS:team": "a"} }, errs: []string{"uses the reserved aws: prefix"}},
+		{name: "tag value too long", change: func(m *OperatorModel) {
+			m.LaunchTemplateOptions.Tags = map[string]string{"team": strings.Repeat("a", 257)}
+		}, errs: []string{"ec2.launchTemplate.tags value of team is longer than 256 characters"}},
+		{name: "missing instance type", change: func(m *OperatorModel) { m.InstanceType = "" }, errs: []string{"instanceType is required"}},
+		{name: "unknown volume type", change: func(m *OperatorModel) { m.VolumeType = "gp9" }, errs: []string{"volumeType must be one of"}},
+		{name: "zero volume size", change: func(m *OperatorModel) { m.VolumeSize = 0 }, errs: []string{"volumeSize must be between 1 and 16384 GiB, got 0"}},
+		{name: "smallest volume size", change: func(m *OperatorModel) { m.VolumeSize = 1 }},
+		{name: "largest volume size", change: func(m *OperatorModel) { m.VolumeSize = 16384 }},
+		{name: "volume size too large", change: func(m *OperatorModel) { m.VolumeSize = 16385 }, errs: []string{"volumeSize must be between 1 and 16384 GiB, got 16385"}},
+		{name: "negative retained versions", change: func(m *OperatorModel) { m.RetainVersions = -1 }, errs: []string{"retainVersions must not be negative"}},
+		{name: "unknown strategy", change: func(m *OperatorModel) { m.Strategy = "recreate" }, errs: []string{"rollout.strategy must be rolling or blue-green"}},
+		{name: "more canaries than desired", change: func(m *OperatorModel) { m.Canary.Instances = 3 }, errs: []string{"rollout.canary.instances must be between 0 and asg.desired"}},
+		{name: "negative grace period", change: func(m *OperatorModel) { m.OrphanOptions.GracePeriodSeconds = -1 }, errs: []string{"orphans.gracePeriodSeconds must not be negative"}},
+		{name: "empty selector", change: func(m *OperatorModel) { m.SubnetSelectors = []ResourceSelector{{}} }, errs: []string{"empty selector"}},
+		{name: "every error reported", change: func(m *OperatorModel) { m.InstanceType, m.VolumeSize = "", 0 }, errs: []string{"instanceType is required", "volumeSize must be between"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := validNodeGroup()
+			tt.change(&m)
+
+			errs := m.Validate(5)
+			if len(errs) != len(tt.errs) {
+				t.Fatalf("expected %v errors, got %v: %v", len(tt.errs), len(errs), errs)
+			}
+			for i, v := range tt.errs {
+				if !strings.Contains(errs[i].Error(), v) {
+					t.Fatalf("error %v is %q, expected it to contain %q", i, errs[i], v)
+				}
+			}
+		})
+	}
+}
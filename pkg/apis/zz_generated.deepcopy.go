@@ -0,0 +1,342 @@
+//go:build !ignore_autogenerated
+
+// Code generated by controller-gen. DO NOT EDIT.
+
+package apis
+
+import ()
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *AuditOptions) DeepCopyInto(out *AuditOptions) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new AuditOptions.
+func (in *AuditOptions) DeepCopy() *AuditOptions {
+	if in == nil {
+		return nil
+	}
+	out := new(AuditOptions)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *AutoScalingGroupOptions) DeepCopyInto(out *AutoScalingGroupOptions) {
+	*out = *in
+	if in.SubnetSelectors != nil {
+		in, out := &in.SubnetSelectors, &out.SubnetSelectors
+		*out = make([]ResourceSelector, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.Tags != nil {
+		in, out := &in.Tags, &out.Tags
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new AutoScalingGroupOptions.
+func (in *AutoScalingGroupOptions) DeepCopy() *AutoScalingGroupOptions {
+	if in == nil {
+		return nil
+	}
+	out := new(AutoScalingGroupOptions)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *CanaryOptions) DeepCopyInto(out *CanaryOptions) {
+	*out = *in
+	if in.CloudWatchAlarms != nil {
+		in, out := &in.CloudWatchAlarms, &out.CloudWatchAlarms
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new CanaryOptions.
+func (in *CanaryOptions) DeepCopy() *CanaryOptions {
+	if in == nil {
+		return nil
+	}
+	out := new(CanaryOptions)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *EbsVolume) DeepCopyInto(out *EbsVolume) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new EbsVolume.
+func (in *EbsVolume) DeepCopy() *EbsVolume {
+	if in == nil {
+		return nil
+	}
+	out := new(EbsVolume)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *Ec2Options) DeepCopyInto(out *Ec2Options) {
+	*out = *in
+	in.LaunchTemplateOptions.DeepCopyInto(&out.LaunchTemplateOptions)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new Ec2Options.
+func (in *Ec2Options) DeepCopy() *Ec2Options {
+	if in == nil {
+		return nil
+	}
+	out := new(Ec2Options)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *LaunchTemplateOptions) DeepCopyInto(out *LaunchTemplateOptions) {
+	*out = *in
+	if in.SecurityGroups != nil {
+		in, out := &in.SecurityGroups, &out.SecurityGroups
+		*out = make([]*string, len(*in))
+		for i := range *in {
+			if (*in)[i] != nil {
+				in, out := &(*in)[i], &(*out)[i]
+				*out = new(string)
+				**out = **in
+			}
+		}
+	}
+	if in.SecurityGroupSelectors != nil {
+		in, out := &in.SecurityGroupSelectors, &out.SecurityGroupSelectors
+		*out = make([]ResourceSelector, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.Tags != nil {
+		in, out := &in.Tags, &out.Tags
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	if in.ResourceTags != nil {
+		in, out := &in.ResourceTags, &out.ResourceTags
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	out.EbsVolume = in.EbsVolume
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new LaunchTemplateOptions.
+func (in *LaunchTemplateOptions) DeepCopy() *LaunchTemplateOptions {
+	if in == nil {
+		return nil
+	}
+	out := new(LaunchTemplateOptions)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *LockOptions) DeepCopyInto(out *LockOptions) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new LockOptions.
+func (in *LockOptions) DeepCopy() *LockOptions {
+	if in == nil {
+		return nil
+	}
+	out := new(LockOptions)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *NamingOptions) DeepCopyInto(out *NamingOptions) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new NamingOptions.
+func (in *NamingOptions) DeepCopy() *NamingOptions {
+	if in == nil {
+		return nil
+	}
+	out := new(NamingOptions)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *NotificationOptions) DeepCopyInto(out *NotificationOptions) {
+	*out = *in
+	if in.Notifiers != nil {
+		in, out := &in.Notifiers, &out.Notifiers
+		*out = make([]NotifierOptions, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new NotificationOptions.
+func (in *NotificationOptions) DeepCopy() *NotificationOptions {
+	if in == nil {
+		return nil
+	}
+	out := new(NotificationOptions)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *NotifierOptions) DeepCopyInto(out *NotifierOptions) {
+	*out = *in
+	if in.Events != nil {
+		in, out := &in.Events, &out.Events
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.Headers != nil {
+		in, out := &in.Headers, &out.Headers
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new NotifierOptions.
+func (in *NotifierOptions) DeepCopy() *NotifierOptions {
+	if in == nil {
+		return nil
+	}
+	out := new(NotifierOptions)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *OperatorModel) DeepCopyInto(out *OperatorModel) {
+	*out = *in
+	in.Ec2Options.DeepCopyInto(&out.Ec2Options)
+	in.AutoScalingGroupOptions.DeepCopyInto(&out.AutoScalingGroupOptions)
+	out.SSMOptions = in.SSMOptions
+	out.NamingOptions = in.NamingOptions
+	in.RolloutOptions.DeepCopyInto(&out.RolloutOptions)
+	out.StateOptions = in.StateOptions
+	out.LockOptions = in.LockOptions
+	out.AuditOptions = in.AuditOptions
+	in.NotificationOptions.DeepCopyInto(&out.NotificationOptions)
+	out.OrphanOptions = in.OrphanOptions
+	if in.DependsOn != nil {
+		in, out := &in.DependsOn, &out.DependsOn
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new OperatorModel.
+func (in *OperatorModel) DeepCopy() *OperatorModel {
+	if in == nil {
+		return nil
+	}
+	out := new(OperatorModel)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *OrphanOptions) DeepCopyInto(out *OrphanOptions) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new OrphanOptions.
+func (in *OrphanOptions) DeepCopy() *OrphanOptions {
+	if in == nil {
+		return nil
+	}
+	out := new(OrphanOptions)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ResourceSelector) DeepCopyInto(out *ResourceSelector) {
+	*out = *in
+	if in.Tags != nil {
+		in, out := &in.Tags, &out.Tags
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ResourceSelector.
+func (in *ResourceSelector) DeepCopy() *ResourceSelector {
+	if in == nil {
+		return nil
+	}
+	out := new(ResourceSelector)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *RolloutOptions) DeepCopyInto(out *RolloutOptions) {
+	*out = *in
+	in.Canary.DeepCopyInto(&out.Canary)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new RolloutOptions.
+func (in *RolloutOptions) DeepCopy() *RolloutOptions {
+	if in == nil {
+		return nil
+	}
+	out := new(RolloutOptions)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *SSMOptions) DeepCopyInto(out *SSMOptions) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SSMOptions.
+func (in *SSMOptions) DeepCopy() *SSMOptions {
+	if in == nil {
+		return nil
+	}
+	out := new(SSMOptions)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *StateOptions) DeepCopyInto(out *StateOptions) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new StateOptions.
+func (in *StateOptions) DeepCopy() *StateOptions {
+	if in == nil {
+		return nil
+	}
+	out := new(StateOptions)
+	in.DeepCopyInto(out)
+	return out
+}
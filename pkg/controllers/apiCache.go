@@ -0,0 +1,185 @@
+package controllers
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/aws/session"
+)
+
+//DefaultAPICacheTTL represents how long describe results are reused, short enough to only span one reconcile pass
+const DefaultAPICacheTTL = 15 * time.Second
+
+//APICache represents describe results shared by the services within a reconcile pass. Writes through a service
+//invalidate the entries they change, wait loops read around the cache. A nil cache caches nothing.
+type APICache struct {
+	TTL     time.Duration
+	Metrics *APIMetrics
+
+	mu      sync.Mutex
+	entries map[string]apiCacheEntry
+}
+
+type apiCacheEntry struct {
+	value     interface{}
+	expiresAt time.Time
+}
+
+//NewAPICache represents
+func NewAPICache(ttl time.Duration, metrics *APIMetrics) *APICache {
+	return &APICache{TTL: ttl, Metrics: metrics, entries: make(map[string]apiCacheEntry)}
+}
+
+//Get represents
+func (r *APICache) Get(key string) (interface{}, bool) {
+	if r == nil {
+		return nil, false
+	}
+
+	r.mu.Lock()
+	entry, ok := r.entries[key]
+	if ok && time.Now().After(entry.expiresAt) {
+		delete(r.entries, key)
+		ok = false
+	}
+	r.mu.Unlock()
+
+	r.Metrics.recordCacheLookup(ok)
+	return entry.value, ok
+}
+
+//Set represents
+func (r *APICache) Set(key string, value interface{}) {
+	if r == nil {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.entries[key] = apiCacheEntry{value: value, expiresAt: time.Now().Add(r.TTL)}
+}
+
+//Invalidate represents dropping the entries of the given keys
+func (r *APICache) Invalidate(keys ...string) {
+	if r == nil {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, v := range keys {
+		delete(r.entries, v)
+	}
+}
+
+//InvalidatePrefix represents dropping every entry whose key starts with the prefix
+func (r *APICache) InvalidatePrefix(prefix string) {
+	if r == nil {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for k := range r.entries {
+		if strings.HasPrefix(k, prefix) {
+			delete(r.entries, k)
+		}
+	}
+}
+
+//Clear represents dropping every entry, at the start and end of a pass
+func (r *APICache) Clear() {
+	if r == nil {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.entries = make(map[string]apiCacheEntry)
+}
+
+//APIMetrics represents counts of the aws calls made, retries included, and of cache lookups
+type APIMetrics struct {
+	mu          sync.Mutex
+	calls       map[string]int64
+	cacheHits   int64
+	cacheMisses int64
+}
+
+//APICallCount represents the number of calls made to one aws operation
+type APICallCount struct {
+	Operation string
+	Calls     int64
+}
+
+//InstrumentSession represents counting every request sent through clients of the session, and the calls failing
+func InstrumentSession(awsSession *session.Session, metrics *APIMetrics) {
+	awsSession.Handlers.Send.PushFrontNamed(request.NamedHandler{
+		Name: "nodegroupmanager.APIMetrics",
+		Fn: func(req *request.Request) {
+			metrics.RecordCall(req.ClientInfo.ServiceName, req.Operation.Name)
+			awsCallsTotal.WithLabelValues(req.ClientInfo.ServiceName, req.Operation.Name).Inc()
+		},
+	})
+	awsSession.Handlers.Complete.PushBackNamed(request.NamedHandler{
+		Name: "nodegroupmanager.APIErrors",
+		Fn: func(req *request.Request) {
+			if req.Error != nil {
+				recordAwsError(req.ClientInfo.ServiceName, req.Operation.Name, req.Error)
+			}
+		},
+	})
+}
+
+//RecordCall represents
+func (r *APIMetrics) RecordCall(service string, operation string) {
+	if r == nil {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.calls == nil {
+		r.calls = make(map[string]int64)
+	}
+	r.calls[service+"."+operation]++
+}
+
+func (r *APIMetrics) recordCacheLookup(hit bool) {
+	if r == nil {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if hit {
+		r.cacheHits++
+	} else {
+		r.cacheMisses++
+	}
+}
+
+//Calls represents the call counts per operation, sorted by operation
+func (r *APIMetrics) Calls() []APICallCount {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	counts := []APICallCount{}
+	for k, v := range r.calls {
+		counts = append(counts, APICallCount{Operation: k, Calls: v})
+	}
+	sort.Slice(counts, func(i, j int) bool { return counts[i].Operation < counts[j].Operation })
+
+	return counts
+}
+
+//CacheLookups represents the number of cache hits and misses
+func (r *APIMetrics) CacheLookups() (int64, int64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.cacheHits, r.cacheMisses
+}
@@ -0,0 +1,218 @@
+package controllers
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	apiTypes "github.com/anyo/aws-node-group-manager/pkg/apis"
+
+	"github.com/sirupsen/logrus"
+)
+
+//APIServer represents the http api of the daemon: node group status, triggering reconciles, pausing and resuming
+//rollouts and approving amis. Every request must carry the bearer token.
+type APIServer struct {
+	Token      string
+	Reconciler ReconcilerService
+	// K8sVersion selects the recommended ami reported in the status
+	K8sVersion string
+	// NodeGroups is read by concurrent requests and never written, it must not share its models with a reconcile
+	NodeGroups []apiTypes.OperatorModel
+	Controls   *NodeGroupControls
+	// Triggers receives the node group to reconcile, empty for all of them
+	Triggers chan<- string
+	Log      *logrus.Entry
+}
+
+type approveAmiRequest struct {
+	Ami string `json:"ami"`
+}
+
+//Handler represents the routes of the api:
+//
+//	GET  /api/v1/nodegroups                     status of every node group
+//	GET  /api/v1/nodegroups/{name}              status of one node group
+//	POST /api/v1/reconcile                      reconcile every node group
+//	POST /api/v1/nodegroups/{name}/reconcile
+//	POST /api/v1/nodegroups/{name}/pause
+//	POST /api/v1/nodegroups/{name}/resume       resumes and reconciles
+//	POST /api/v1/nodegroups/{name}/approve-ami  approves {"ami": ...} or the pending ami, and reconciles
+func (r *APIServer) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v1/nodegroups", r.handleNodeGroups)
+	mux.HandleFunc("/api/v1/nodegroups/", r.handleNodeGroup)
+	mux.HandleFunc("/api/v1/reconcile", r.handleReconcileAll)
+
+	return r.authenticate(mux)
+}
+
+func (r *APIServer) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		// a bare token without the scheme is refused
+		header := req.Header.Get("Authorization")
+		token := strings.TrimPrefix(header, "Bearer ")
+		if r.Token == "" || token == header || subtle.ConstantTimeCompare([]byte(token), []byte(r.Token)) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			writeAPIError(w, http.StatusUnauthorized, fmt.Errorf("missing or invalid bearer token"))
+			return
+		}
+
+		next.ServeHTTP(w, req)
+	})
+}
+
+func (r *APIServer) handleNodeGroups(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		writeAPIError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %v not allowed", req.Method))
+		return
+	}
+
+	statuses := []*apiTypes.NodeGroupStatus{}
+	for i := range r.NodeGroups {
+		status, err := r.nodeGroupStatus(r.NodeGroups[i])
+		if err != nil {
+			writeAPIError(w, http.StatusInternalServerError, err)
+			return
+		}
+		statuses = append(statuses, status)
+	}
+
+	writeAPIResponse(w, http.StatusOK, statuses)
+}
+
+func (r *APIServer) handleNodeGroup(w http.ResponseWriter, req *http.Request) {
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(req.URL.Path, "/api/v1/nodegroups/"), "/"), "/")
+	model := r.findNodeGroup(parts[0])
+	if model == nil {
+		writeAPIError(w, http.StatusNotFound, fmt.Errorf("node group %v not found", parts[0]))
+		return
+	}
+
+	action := ""
+	if len(parts) > 1 {
+		action = parts[1]
+	}
+	if len(parts) > 2 {
+		writeAPIError(w, http.StatusNotFound, fmt.Errorf("unknown path %v", req.URL.Path))
+		return
+	}
+
+	expectedMethod := http.MethodPost
+	if action == "" {
+		expectedMethod = http.MethodGet
+	}
+	if req.Method != expectedMethod {
+		writeAPIError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %v not allowed", req.Method))
+		return
+	}
+
+	name := model.NodeGroupName()
+	logger := r.logger().WithFields(logrus.Fields{NodeGroupField: name, "action": action})
+	switch action {
+	case "":
+		status, err := r.nodeGroupStatus(*model)
+		if err != nil {
+			writeAPIError(w, http.StatusInternalServerError, err)
+			return
+		}
+		writeAPIResponse(w, http.StatusOK, status)
+	case "reconcile":
+		r.trigger(w, name)
+	case "pause":
+		if err := r.Controls.Pause(name); err != nil {
+			writeAPIError(w, http.StatusInternalServerError, fmt.Errorf("failed to save the pause: %v", err))
+			return
+		}
+		logger.Info("Rollout paused through the api")
+		writeAPIResponse(w, http.StatusOK, map[string]interface{}{"nodeGroup": name, "paused": true})
+	case "resume":
+		if err := r.Controls.Resume(name); err != nil {
+			writeAPIError(w, http.StatusInternalServerError, fmt.Errorf("failed to save the resume: %v", err))
+			return
+		}
+		logger.Info("Rollout resumed through the api")
+		r.trigger(w, name)
+	case "approve-ami":
+		body := approveAmiRequest{}
+		// an empty body approves the pending ami
+		if err := json.NewDecoder(req.Body).Decode(&body); err != nil && err != io.EOF {
+			writeAPIError(w, http.StatusBadRequest, fmt.Errorf("invalid body: %v", err))
+			return
+		}
+		if body.Ami == "" {
+			body.Ami = r.Controls.PendingAmi(name)
+		}
+		if body.Ami == "" {
+			writeAPIError(w, http.StatusBadRequest, fmt.Errorf("no ami is waiting for approval, pass one as {\"ami\": ...}"))
+			return
+		}
+
+		if err := r.Controls.ApproveAmi(name, body.Ami); err != nil {
+			writeAPIError(w, http.StatusInternalServerError, fmt.Errorf("failed to save the approval: %v", err))
+			return
+		}
+		logger.WithField("ami", body.Ami).Info("Ami approved through the api")
+		r.trigger(w, name)
+	default:
+		writeAPIError(w, http.StatusNotFound, fmt.Errorf("unknown action %v", action))
+	}
+}
+
+func (r *APIServer) handleReconcileAll(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		writeAPIError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %v not allowed", req.Method))
+		return
+	}
+
+	r.trigger(w, "")
+}
+
+// trigger queues a reconcile without waiting for it, a reconcile may take an hour
+func (r *APIServer) trigger(w http.ResponseWriter, nodeGroup string) {
+	select {
+	case r.Triggers <- nodeGroup:
+		writeAPIResponse(w, http.StatusAccepted, map[string]string{"nodeGroup": nodeGroup, "reconcile": "queued"})
+	default:
+		writeAPIError(w, http.StatusTooManyRequests, fmt.Errorf("too many reconciles queued, retry later"))
+	}
+}
+
+func (r *APIServer) nodeGroupStatus(model apiTypes.OperatorModel) (*apiTypes.NodeGroupStatus, error) {
+	reconciler := r.Reconciler
+	reconciler.Controls = r.Controls
+
+	svc, err := reconciler.ForNodeGroup(&model)
+	if err != nil {
+		return nil, err
+	}
+
+	return svc.NodeGroupStatus(&model, r.K8sVersion)
+}
+
+func (r *APIServer) findNodeGroup(name string) *apiTypes.OperatorModel {
+	for i := range r.NodeGroups {
+		if r.NodeGroups[i].NodeGroupName() == name {
+			return &r.NodeGroups[i]
+		}
+	}
+
+	return nil
+}
+
+func (r *APIServer) logger() *logrus.Entry {
+	return loggerOrDefault(r.Log)
+}
+
+func writeAPIResponse(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(body)
+}
+
+func writeAPIError(w http.ResponseWriter, status int, err error) {
+	writeAPIResponse(w, status, map[string]string{"error": err.Error()})
+}
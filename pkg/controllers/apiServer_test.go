@@ -0,0 +1,126 @@
+package controllers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	apiTypes "github.com/anyo/aws-node-group-manager/pkg/apis"
+)
+
+func TestAPIServerPause(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "controls.json")
+	controls, err := LoadNodeGroupControls(path)
+	if err != nil {
+		t.Fatalf("load controls: %v", err)
+	}
+
+	nodeGroup := apiTypes.OperatorModel{}
+	nodeGroup.AutoScalingGroupOptions.Name = "workers"
+	server := APIServer{Token: "s3cr3t", NodeGroups: []apiTypes.OperatorModel{nodeGroup}, Controls: controls}
+	handler := server.Handler()
+
+	tests := []struct {
+		name          string
+		authorization string
+		status        int
+	}{
+		{name: "no token", status: http.StatusUnauthorized},
+		{name: "bare token", authorization: "s3cr3t", status: http.StatusUnauthorized},
+		{name: "other scheme", authorization: "Basic s3cr3t", status: http.StatusUnauthorized},
+		{name: "wrong token", authorization: "Bearer secret", status: http.StatusUnauthorized},
+		{name: "bearer token", authorization: "Bearer s3cr3t", status: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/api/v1/nodegroups/workers/pause", nil)
+			if tt.authorization != "" {
+				req.Header.Set("Authorization", tt.authorization)
+			}
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, req)
+
+			if recorder.Code != tt.status {
+				t.Fatalf("status %v, expected %v: %v", recorder.Code, tt.status, recorder.Body.String())
+			}
+		})
+	}
+
+	// the pause outlives the daemon
+	restarted, err := LoadNodeGroupControls(path)
+	if err != nil {
+		t.Fatalf("reload controls: %v", err)
+	}
+	if !restarted.Paused("workers") {
+		t.Fatal("the pause was not saved")
+	}
+}
+
+func TestNodeGroupControlsSave(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state", "controls.json")
+	controls, err := LoadNodeGroupControls(path)
+	if err != nil {
+		t.Fatalf("load controls: %v", err)
+	}
+
+	if !controls.setPendingAmi("workers", "ami-2") {
+		t.Fatal("a new ami was reported as already waiting")
+	}
+	if err := controls.ApproveAmi("batch", "ami-3"); err != nil {
+		t.Fatalf("approve: %v", err)
+	}
+	if err := controls.Pause("batch"); err != nil {
+		t.Fatalf("pause: %v", err)
+	}
+	if err := controls.Resume("batch"); err != nil {
+		t.Fatalf("resume: %v", err)
+	}
+
+	restarted, err := LoadNodeGroupControls(path)
+	if err != nil {
+		t.Fatalf("reload controls: %v", err)
+	}
+	if restarted.PendingAmi("workers") != "ami-2" || restarted.setPendingAmi("workers", "ami-2") {
+		t.Fatal("the pending ami was not saved")
+	}
+	if !restarted.AmiApproved("batch", "ami-3") {
+		t.Fatal("the approval was not saved")
+	}
+	if restarted.Paused("batch") {
+		t.Fatal("the resume was not saved")
+	}
+}
+
+func TestNodeGroupControlsShared(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "controls.json")
+	daemon, err := LoadNodeGroupControls(path)
+	if err != nil {
+		t.Fatalf("load daemon controls: %v", err)
+	}
+	tui, err := LoadNodeGroupControls(path)
+	if err != nil {
+		t.Fatalf("load tui controls: %v", err)
+	}
+
+	if err := tui.Pause("workers"); err != nil {
+		t.Fatalf("pause: %v", err)
+	}
+	if !daemon.Paused("workers") {
+		t.Fatal("the pause of the tui was not seen by the daemon")
+	}
+
+	if !daemon.setPendingAmi("workers", "ami-2") {
+		t.Fatal("a new ami was reported as already waiting")
+	}
+	if err := tui.Resume("workers"); err != nil {
+		t.Fatalf("resume: %v", err)
+	}
+	if daemon.Paused("workers") {
+		t.Fatal("the resume of the tui was not seen by the daemon")
+	}
+	if tui.PendingAmi("workers") != "ami-2" || daemon.PendingAmi("workers") != "ami-2" {
+		t.Fatal("the resume of the tui dropped the ami waiting in the daemon")
+	}
+}
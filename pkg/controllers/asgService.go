@@ -1,76 +1,200 @@
 package controllers
 
 import (
-	"log"
 	"reflect"
+	"strings"
 	"time"
 
 	apiTypes "github.com/anyo/aws-node-group-manager/pkg/apis"
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/sirupsen/logrus"
 )
 
 //AsgService represents ssm operations
 type AsgService struct {
 	AwsSession session.Session
 	Region     string
+	Cache      *APICache
+	Log        *logrus.Entry
+	Auditor    *Auditor
 }
 
 //GetAutoScalingGroups represents
 func (r *AsgService) GetAutoScalingGroups() []*autoscaling.Group {
 	asgSvc := autoscaling.New(&r.AwsSession)
 
+	groups := []*autoscaling.Group{}
 	input := autoscaling.DescribeAutoScalingGroupsInput{}
-	grps, err := asgSvc.DescribeAutoScalingGroups(&input)
+	err := asgSvc.DescribeAutoScalingGroupsPages(&input, func(page *autoscaling.DescribeAutoScalingGroupsOutput, lastPage bool) bool {
+		groups = append(groups, page.AutoScalingGroups...)
+		return true
+	})
 	if err != nil {
-		log.Println("Error while getting asgs", err)
+		withAwsError(r.logger(), err).Error("Error while getting asgs")
 		return nil
 	}
 
-	return grps.AutoScalingGroups
+	for _, v := range groups {
+		r.Cache.Set(asgCacheKey(*v.AutoScalingGroupName), v)
+	}
+
+	return groups
 }
 
 //GetLaunchConfiguration represents
 func (r *AsgService) GetLaunchConfiguration(name string) *autoscaling.LaunchConfiguration {
 	asgSvc := autoscaling.New(&r.AwsSession)
 
-	input := autoscaling.DescribeLaunchConfigurationsInput{}
-	response, err := asgSvc.DescribeLaunchConfigurations(&input)
+	input := autoscaling.DescribeLaunchConfigurationsInput{
+		LaunchConfigurationNames: []*string{aws.String(name)},
+	}
+
+	var launchConfiguration *autoscaling.LaunchConfiguration
+	err := asgSvc.DescribeLaunchConfigurationsPages(&input, func(page *autoscaling.DescribeLaunchConfigurationsOutput, lastPage bool) bool {
+		if len(page.LaunchConfigurations) > 0 {
+			launchConfiguration = page.LaunchConfigurations[0]
+			return false
+		}
+		return true
+	})
+	if err != nil {
+		withAwsError(r.logger(), err).WithField("launchConfiguration", name).Error("Error while getting launch configuration")
+		return nil
+	}
+
+	return launchConfiguration
+}
+
+//GetAutoScalingGroup represents getting an asg, from the cache when it was described earlier in the pass
+func (r *AsgService) GetAutoScalingGroup(name string) *autoscaling.Group {
+	if cached, ok := r.Cache.Get(asgCacheKey(name)); ok {
+		return cached.(*autoscaling.Group)
+	}
+
+	return r.RefreshAutoScalingGroup(name)
+}
+
+//RefreshAutoScalingGroup represents describing an asg bypassing the cache, for wait loops watching it change
+func (r *AsgService) RefreshAutoScalingGroup(name string) *autoscaling.Group {
+	groups, err := r.DescribeAutoScalingGroups([]string{name})
 	if err != nil {
-		log.Println("Error while getting asgs", err)
+		withAwsError(r.logger(), err).WithField(AsgField, name).Error("Error while getting asg")
 		return nil
 	}
 
-	if response.LaunchConfigurations == nil {
+	if len(groups) == 0 {
 		return nil
 	}
 
-	return response.LaunchConfigurations[0]
+	return groups[0]
 }
 
-//GetAutoScalingGroup represents
-func (r *AsgService) GetAutoScalingGroup(name string) *autoscaling.Group {
+//DescribeAutoScalingGroups represents describing the named asgs, 50 per request, asgs that do not exist are left out
+func (r *AsgService) DescribeAutoScalingGroups(names []string) ([]*autoscaling.Group, error) {
 	asgSvc := autoscaling.New(&r.AwsSession)
 
-	names := []*string{aws.String(name)}
-	maxRecords := int64(1)
-	input := autoscaling.DescribeAutoScalingGroupsInput{
-		AutoScalingGroupNames: names,
-		MaxRecords:            &maxRecords,
+	groups := []*autoscaling.Group{}
+	for start := 0; start < len(names); start += 50 {
+		end := start + 50
+		if end > len(names) {
+			end = len(names)
+		}
+
+		input := autoscaling.DescribeAutoScalingGroupsInput{
+			AutoScalingGroupNames: aws.StringSlice(names[start:end]),
+		}
+
+		err := asgSvc.DescribeAutoScalingGroupsPages(&input, func(page *autoscaling.DescribeAutoScalingGroupsOutput, lastPage bool) bool {
+			groups = append(groups, page.AutoScalingGroups...)
+			return true
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for _, v := range groups {
+		r.Cache.Set(asgCacheKey(*v.AutoScalingGroupName), v)
 	}
 
-	response, err := asgSvc.DescribeAutoScalingGroups(&input)
+	return groups, nil
+}
+
+//FindAutoScalingGroups represents getting the asgs carrying all of the given tags
+func (r *AsgService) FindAutoScalingGroups(tags map[string]string) []*autoscaling.Group {
+	asgSvc := autoscaling.New(&r.AwsSession)
+
+	keys := []string{}
+	for k := range tags {
+		keys = append(keys, k)
+	}
+
+	input := autoscaling.DescribeTagsInput{
+		Filters: []*autoscaling.Filter{{Name: aws.String("key"), Values: aws.StringSlice(keys)}},
+	}
+
+	// count the matching key/value pairs per asg, only asgs matching every tag are returned
+	matches := make(map[string]int)
+	err := asgSvc.DescribeTagsPages(&input, func(page *autoscaling.DescribeTagsOutput, lastPage bool) bool {
+		for _, v := range page.Tags {
+			if value, ok := tags[*v.Key]; ok && value == *v.Value {
+				matches[*v.ResourceId]++
+			}
+		}
+		return true
+	})
 	if err != nil {
-		log.Println("Error while getting asg: ", name, ", Error: ", err)
+		withAwsError(r.logger(), err).Error("Error while finding asgs by tags")
 		return nil
 	}
 
-	if response.AutoScalingGroups == nil {
+	names := []string{}
+	for name, count := range matches {
+		if count == len(tags) {
+			names = append(names, name)
+		}
+	}
+
+	groups, err := r.DescribeAutoScalingGroups(names)
+	if err != nil {
+		withAwsError(r.logger(), err).Error("Error while getting asgs found by tags")
 		return nil
 	}
 
-	return response.AutoScalingGroups[0]
+	return groups
+}
+
+//GetInstanceAutoScalingGroups represents getting the asg each instance belongs to, instances outside of any asg are left out
+func (r *AsgService) GetInstanceAutoScalingGroups(instanceIDs []*string) (map[string]string, error) {
+	asgSvc := autoscaling.New(&r.AwsSession)
+
+	groups := make(map[string]string)
+	// at most 50 instance ids are accepted per request
+	for start := 0; start < len(instanceIDs); start += 50 {
+		end := start + 50
+		if end > len(instanceIDs) {
+			end = len(instanceIDs)
+		}
+
+		input := autoscaling.DescribeAutoScalingInstancesInput{
+			InstanceIds: instanceIDs[start:end],
+		}
+
+		err := asgSvc.DescribeAutoScalingInstancesPages(&input, func(page *autoscaling.DescribeAutoScalingInstancesOutput, lastPage bool) bool {
+			for _, v := range page.AutoScalingInstances {
+				groups[*v.InstanceId] = *v.AutoScalingGroupName
+			}
+			return true
+		})
+		if err != nil {
+			withAwsError(r.logger(), err).Error("Error while getting asgs of instances")
+			return nil, err
+		}
+	}
+
+	return groups, nil
 }
 
 //CreateAsgLaunchConfig represents
@@ -100,6 +224,7 @@ func (r *AsgService) CreateAsgLaunchConfig(configOptions *apiTypes.LaunchConfigu
 	}
 
 	output, err := asgSvc.CreateLaunchConfiguration(&launchConfInput)
+	r.Auditor.Record("CreateLaunchConfiguration", configOptions.NamePrefix, nil, configOptions, err)
 
 	return output, err
 }
@@ -108,26 +233,22 @@ func (r *AsgService) CreateAsgLaunchConfig(configOptions *apiTypes.LaunchConfigu
 func (r *AsgService) CreateAsg(asgOptions *apiTypes.AutoScalingGroupOptions) (*autoscaling.CreateAutoScalingGroupOutput, error) {
 	asgSvc := autoscaling.New(&r.AwsSession)
 
-	tags := []*autoscaling.Tag{}
+	output, err := asgSvc.CreateAutoScalingGroup(r.getCreateAsgInput(asgOptions))
+	r.Cache.Invalidate(asgCacheKey(asgOptions.Name))
+	r.Auditor.Record("CreateAutoScalingGroup", asgOptions.Name, nil, asgOptions, err)
 
-	for i, v := range asgOptions.Tags {
-		t := autoscaling.Tag{
-			Key:               aws.String(i),
-			PropagateAtLaunch: aws.Bool(true),
-			ResourceId:        aws.String(asgOptions.Name),
-			ResourceType:      aws.String("auto-scaling-group"),
-			Value:             aws.String(v),
-		}
+	return output, err
+}
 
-		tags = append(tags, &t)
-	}
+func (r *AsgService) getCreateAsgInput(asgOptions *apiTypes.AutoScalingGroupOptions) *autoscaling.CreateAutoScalingGroupInput {
+	tags := r.getAsgTags(asgOptions.Name, asgOptions.Tags)
 
 	launchTemplateSpecification := autoscaling.LaunchTemplateSpecification{
 		LaunchTemplateName: aws.String(asgOptions.LaunchTemplateName),
-		Version:            aws.String("$Latest"),
+		Version:            aws.String(asgOptions.LaunchTemplateVersion),
 	}
 
-	input := autoscaling.CreateAutoScalingGroupInput{
+	return &autoscaling.CreateAutoScalingGroupInput{
 		AutoScalingGroupName: aws.String(asgOptions.Name),
 		VPCZoneIdentifier:    aws.String(asgOptions.Subnets),
 		DesiredCapacity:      aws.Int64(asgOptions.DesiredInstances),
@@ -136,79 +257,195 @@ func (r *AsgService) CreateAsg(asgOptions *apiTypes.AutoScalingGroupOptions) (*a
 		Tags:                 tags,
 		LaunchTemplate:       &launchTemplateSpecification,
 	}
-
-	output, err := asgSvc.CreateAutoScalingGroup(&input)
-
-	return output, err
 }
 
 //UpdateAsg represents
 func (r *AsgService) UpdateAsg(asgOptions *apiTypes.AutoScalingGroupOptions) (*autoscaling.UpdateAutoScalingGroupOutput, error) {
 	asgSvc := autoscaling.New(&r.AwsSession)
-	tags := []*autoscaling.Tag{}
-
-	for i, v := range asgOptions.Tags {
-		t := autoscaling.Tag{
-			Key:               aws.String(i),
-			PropagateAtLaunch: aws.Bool(true),
-			ResourceId:        aws.String(asgOptions.Name),
-			ResourceType:      aws.String("auto-scaling-group"),
-			Value:             aws.String(v),
-		}
-
-		tags = append(tags, &t)
-	}
+	tags := r.getAsgTags(asgOptions.Name, asgOptions.Tags)
 
 	input := autoscaling.UpdateAutoScalingGroupInput{
 		AutoScalingGroupName: aws.String(asgOptions.Name),
 		DesiredCapacity:      aws.Int64(asgOptions.DesiredInstances),
 		MinSize:              aws.Int64(asgOptions.MinInstances),
 		MaxSize:              aws.Int64(asgOptions.MaxInstances),
+		LaunchTemplate: &autoscaling.LaunchTemplateSpecification{
+			LaunchTemplateName: aws.String(asgOptions.LaunchTemplateName),
+			Version:            aws.String(asgOptions.LaunchTemplateVersion),
+		},
 	}
 
 	tagsInput := autoscaling.CreateOrUpdateTagsInput{
 		Tags: tags,
 	}
 
+	before := r.auditAsgState(asgOptions.Name)
 	output, err := asgSvc.UpdateAutoScalingGroup(&input)
+	r.Cache.Invalidate(asgCacheKey(asgOptions.Name))
+	r.Auditor.Record("UpdateAutoScalingGroup", asgOptions.Name, before, asgOptions, err)
 	if err != nil {
-		log.Println("Error updating ASG:", asgOptions.Name)
+		withAwsError(r.logger(), err).WithField(AsgField, asgOptions.Name).Error("Error updating ASG")
 		return nil, err
 	}
 
 	_, tagsErr := asgSvc.CreateOrUpdateTags(&tagsInput)
+	r.Auditor.Record("CreateOrUpdateTags", asgOptions.Name, asgTagsState(before), asgOptions.Tags, tagsErr)
 	if tagsErr != nil {
-		log.Println("Error updating tags for ASG:", asgOptions.Name)
+		withAwsError(r.logger(), tagsErr).WithField(AsgField, asgOptions.Name).Error("Error updating tags for ASG")
 		return output, err
 	}
 
 	return output, err
 }
 
+//DeleteAsg represents deleting an asg together with its instances
+func (r *AsgService) DeleteAsg(name *string) error {
+	asgSvc := autoscaling.New(&r.AwsSession)
+
+	input := autoscaling.DeleteAutoScalingGroupInput{
+		AutoScalingGroupName: name,
+		ForceDelete:          aws.Bool(true),
+	}
+
+	before := r.auditAsgState(*name)
+	_, err := asgSvc.DeleteAutoScalingGroup(&input)
+	r.Cache.Invalidate(asgCacheKey(*name))
+	r.Auditor.Record("DeleteAutoScalingGroup", *name, before, nil, err)
+	if err != nil {
+		withAwsError(r.logger(), err).WithField(AsgField, *name).Error("Error deleting ASG")
+		return err
+	}
+
+	return nil
+}
+
+//DeleteAsgTags represents removing tags from an asg
+func (r *AsgService) DeleteAsgTags(name *string, keys []string) error {
+	asgSvc := autoscaling.New(&r.AwsSession)
+
+	tags := []*autoscaling.Tag{}
+	for _, v := range keys {
+		tags = append(tags, &autoscaling.Tag{
+			Key:          aws.String(v),
+			ResourceId:   name,
+			ResourceType: aws.String("auto-scaling-group"),
+		})
+	}
+
+	before := asgTagsState(r.auditAsgState(*name))
+	_, err := asgSvc.DeleteTags(&autoscaling.DeleteTagsInput{Tags: tags})
+	r.Cache.Invalidate(asgCacheKey(*name))
+	r.Auditor.Record("DeleteTags", *name, before, map[string][]string{"deleted": keys}, err)
+	if err != nil {
+		withAwsError(r.logger(), err).WithField(AsgField, *name).Error("Error deleting tags of ASG")
+		return err
+	}
+
+	return nil
+}
+
+//SetLaunchTemplateVersion represents pointing the asg at a launch template version
+func (r *AsgService) SetLaunchTemplateVersion(asgName *string, templateName *string, version string) error {
+	asgSvc := autoscaling.New(&r.AwsSession)
+
+	input := autoscaling.UpdateAutoScalingGroupInput{
+		AutoScalingGroupName: asgName,
+		LaunchTemplate: &autoscaling.LaunchTemplateSpecification{
+			LaunchTemplateName: templateName,
+			Version:            aws.String(version),
+		},
+	}
+
+	before := r.auditAsgState(*asgName)
+	_, err := asgSvc.UpdateAutoScalingGroup(&input)
+	r.Cache.Invalidate(asgCacheKey(*asgName))
+	r.Auditor.Record("SetLaunchTemplateVersion", *asgName, asgLaunchTemplateState(before), map[string]string{"launchTemplate": *templateName, "version": version}, err)
+	if err != nil {
+		withAwsError(r.logger(), err).WithFields(logrus.Fields{AsgField: *asgName, VersionField: version}).Error("Error updating launch template version of ASG")
+		return err
+	}
+
+	return nil
+}
+
+//TagAutoScalingGroup represents adding or overwriting tags on an asg
+func (r *AsgService) TagAutoScalingGroup(name string, tags map[string]string) error {
+	asgSvc := autoscaling.New(&r.AwsSession)
+
+	input := autoscaling.CreateOrUpdateTagsInput{
+		Tags: r.getAsgTags(name, tags),
+	}
+
+	before := asgTagsState(r.auditAsgState(name))
+	_, err := asgSvc.CreateOrUpdateTags(&input)
+	r.Cache.Invalidate(asgCacheKey(name))
+	r.Auditor.Record("CreateOrUpdateTags", name, before, tags, err)
+	if err != nil {
+		withAwsError(r.logger(), err).WithField(AsgField, name).Error("Error updating tags for ASG")
+		return err
+	}
+
+	return nil
+}
+
+func (r *AsgService) getAsgTags(name string, tags map[string]string) []*autoscaling.Tag {
+	asgTags := []*autoscaling.Tag{}
+
+	for i, v := range tags {
+		t := autoscaling.Tag{
+			Key:               aws.String(i),
+			PropagateAtLaunch: aws.Bool(true),
+			ResourceId:        aws.String(name),
+			ResourceType:      aws.String("auto-scaling-group"),
+			Value:             aws.String(v),
+		}
+
+		asgTags = append(asgTags, &t)
+	}
+
+	return asgTags
+}
+
 //CompareAsg represents
 func (r *AsgService) CompareAsg(new *apiTypes.AutoScalingGroupOptions, current *autoscaling.Group) (bool, error) {
+	return len(r.AsgDrift(new, current)) > 0, nil
+}
+
+//AsgDrift represents the settings of the asg that differ from the options
+func (r *AsgService) AsgDrift(new *apiTypes.AutoScalingGroupOptions, current *autoscaling.Group) []string {
+	drift := []string{}
 	if new.DesiredInstances != *current.DesiredCapacity {
-		return true, nil
+		drift = append(drift, "desiredInstances")
 	}
 
 	if new.MaxInstances != *current.MaxSize {
-		return true, nil
+		drift = append(drift, "maxInstances")
 	}
 
 	if new.MinInstances != *current.MinSize {
-		return true, nil
+		drift = append(drift, "minInstances")
+	}
+
+	// the referenced launch template version is part of the desired state
+	if current.LaunchTemplate == nil ||
+		new.LaunchTemplateName != aws.StringValue(current.LaunchTemplate.LaunchTemplateName) ||
+		new.LaunchTemplateVersion != aws.StringValue(current.LaunchTemplate.Version) {
+		drift = append(drift, "launchTemplate")
 	}
 
+	// aws: prefixed tags are reserved and can not be managed
 	currentTags := make(map[string]string)
 	for _, v := range current.Tags {
-		currentTags[*v.Key] = *v.Value
+		if !strings.HasPrefix(*v.Key, "aws:") {
+			currentTags[*v.Key] = *v.Value
+		}
 	}
 
 	if !reflect.DeepEqual(new.Tags, currentTags) {
-		return true, nil
+		drift = append(drift, "tags")
 	}
 
-	return false, nil
+	return drift
 }
 
 // DetachInstance represents
@@ -221,29 +458,37 @@ func (r *AsgService) DetachInstance(instanceID *string, asgName *string) bool {
 		ShouldDecrementDesiredCapacity: aws.Bool(false),
 	}
 
+	logger := r.logger().WithFields(logrus.Fields{AsgField: *asgName, InstanceIDField: *instanceID})
 	output, err := asgSvc.DetachInstances(&input)
+	r.Cache.Invalidate(asgCacheKey(*asgName))
+	r.Auditor.Record("DetachInstances", *instanceID, map[string]string{"asg": *asgName}, nil, err)
 	if err != nil {
-		log.Printf("Failed to detach instance: %v, error: %v", *instanceID, err)
+		withAwsError(logger, err).Error("Failed to detach instance")
+		return false
+	}
+	if len(output.Activities) == 0 {
+		logger.Error("Failed to detach instance, no activity was started")
 		return false
 	}
 	activity := output.Activities[0]
 
+	backoff := NewPollBackoff(2*time.Second, 30*time.Second)
 	for {
-		if *activity.StatusCode != "Successful" {
-			log.Printf("Detaching instance: '%v', Message: '%v'", *instanceID, *activity.Description)
-			time.Sleep(2 * time.Second)
-		} else if *activity.StatusCode == "Successful" {
-			log.Printf("Detached instance: '%v', Progress: '%v', Message: %v", *instanceID, *output.Activities[0].Progress, *activity.Description)
+		switch aws.StringValue(activity.StatusCode) {
+		case "Successful":
+			logger.WithField("activity", aws.StringValue(activity.Description)).Info("Detached instance")
 			return true
-		} else if *activity.StatusCode == "Failed" || *activity.StatusCode == "Cancelled" {
-			log.Printf("Failed to detach instance: '%v', Progress: '%v', Cause: %v", *instanceID, *output.Activities[0].Progress, activity.StatusMessage)
+		case "Failed", "Cancelled":
+			logger.WithFields(logrus.Fields{"status": aws.StringValue(activity.StatusCode), "cause": aws.StringValue(activity.StatusMessage)}).Error("Failed to detach instance")
 			return false
 		}
 
-		activity = r.GetAutoScalingActivityStatus(output.Activities[0].ActivityId)
-		if activity == nil {
-			time.Sleep(2 * time.Second)
-			continue
+		logger.WithField("activity", aws.StringValue(activity.Description)).Info("Detaching instance")
+		backoff.Wait()
+
+		// the last known status stands when the activity can not be described
+		if current := r.GetAutoScalingActivityStatus(output.Activities[0].ActivityId); current != nil {
+			activity = current
 		}
 	}
 }
@@ -259,9 +504,44 @@ func (r *AsgService) GetAutoScalingActivityStatus(activityID *string) *autoscali
 
 	output, err := asgSvc.DescribeScalingActivities(&input)
 	if err != nil {
-		log.Printf("Failed to get activity: %v, error: %v", activityID, err)
+		withAwsError(r.logger(), err).WithField("activityId", aws.StringValue(activityID)).Error("Failed to get activity")
+		return nil
+	}
+	if len(output.Activities) == 0 {
 		return nil
 	}
 
 	return output.Activities[0]
 }
+
+func asgCacheKey(name string) string {
+	return "asg:" + name
+}
+
+//GetInProgressActivities represents the scaling activities of the asg that have not finished yet
+func (r *AsgService) GetInProgressActivities(asgName string) ([]*autoscaling.Activity, error) {
+	asgSvc := autoscaling.New(&r.AwsSession)
+
+	// activities are returned newest first, running ones are among the latest
+	input := autoscaling.DescribeScalingActivitiesInput{
+		AutoScalingGroupName: aws.String(asgName),
+		MaxRecords:           aws.Int64(100),
+	}
+
+	output, err := asgSvc.DescribeScalingActivities(&input)
+	if err != nil {
+		withAwsError(r.logger(), err).WithField(AsgField, asgName).Error("Failed to get scaling activities")
+		return nil, err
+	}
+
+	activities := []*autoscaling.Activity{}
+	for _, v := range output.Activities {
+		switch aws.StringValue(v.StatusCode) {
+		case autoscaling.ScalingActivityStatusCodeSuccessful, autoscaling.ScalingActivityStatusCodeFailed, autoscaling.ScalingActivityStatusCodeCancelled:
+			continue
+		}
+		activities = append(activities, v)
+	}
+
+	return activities, nil
+}
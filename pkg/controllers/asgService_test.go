@@ -0,0 +1,64 @@
+package controllers
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+// scalingActivityResponse is the xml response of the autoscaling action with one activity in the given status
+func scalingActivityResponse(action string, status string) string {
+	return fmt.Sprintf(`<%[1]vResponse xmlns="http://autoscaling.amazonaws.com/doc/2011-01-01/">
+  <%[1]vResult>
+    <Activities>
+      <member>
+        <ActivityId>activity-1</ActivityId>
+        <Description>Detaching EC2 instance: i-1</Description>
+        <StatusCode>%[2]v</StatusCode>
+        <StatusMessage>instance is protected</StatusMessage>
+      </member>
+    </Activities>
+  </%[1]vResult>
+  <ResponseMetadata><RequestId>1</RequestId></ResponseMetadata>
+</%[1]vResponse>`, action, status)
+}
+
+func TestDetachInstance(t *testing.T) {
+	tests := []struct {
+		name string
+		// detached is the status of the activity started by the detach, polled is the one described afterwards
+		detached string
+		polled   string
+		expected bool
+	}{
+		{name: "successful", detached: "Successful", expected: true},
+		{name: "failed", detached: "Failed"},
+		{name: "cancelled", detached: "Cancelled"},
+		{name: "failed after polling", detached: "InProgress", polled: "Failed"},
+		{name: "successful after polling", detached: "InProgress", polled: "Successful", expected: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			responses := map[string]string{"DetachInstances": scalingActivityResponse("DetachInstances", tt.detached)}
+			if tt.polled != "" {
+				responses["DescribeScalingActivities"] = scalingActivityResponse("DescribeScalingActivities", tt.polled)
+			}
+
+			svc := AsgService{AwsSession: testAwsSession(t, responses)}
+			done := make(chan bool)
+			go func() { done <- svc.DetachInstance(aws.String("i-1"), aws.String("workers")) }()
+
+			select {
+			case detached := <-done:
+				if detached != tt.expected {
+					t.Fatalf("detached %v, expected %v", detached, tt.expected)
+				}
+			case <-time.After(10 * time.Second):
+				t.Fatal("detach did not finish")
+			}
+		})
+	}
+}
@@ -0,0 +1,472 @@
+package controllers
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	apiTypes "github.com/anyo/aws-node-group-manager/pkg/apis"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/cloudwatchlogs"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/sts"
+	"github.com/sirupsen/logrus"
+)
+
+const defaultAuditPath = ".node-group-manager/audit.jsonl"
+
+//AuditSink represents where audit events are written to and read back from
+type AuditSink interface {
+	Write(event apiTypes.AuditEvent) error
+	// Query returns the events matching the query, oldest first
+	Query(query AuditQuery) ([]apiTypes.AuditEvent, error)
+}
+
+//AuditQuery represents a filter on audit events, zero values match everything
+type AuditQuery struct {
+	NodeGroup string
+	Since     time.Time
+	// Limit keeps the most recent events
+	Limit int
+}
+
+//NewAuditSink represents creating the audit sink for the configured backend
+func NewAuditSink(options apiTypes.AuditOptions, awsSession session.Session, nodeGroup string) (AuditSink, error) {
+	switch options.Sink {
+	case "", "file":
+		path := options.Path
+		if path == "" {
+			path = defaultAuditPath
+		}
+		return &FileAuditSink{Path: path}, nil
+	case "s3":
+		if options.Bucket == "" {
+			return nil, fmt.Errorf("the s3 audit sink requires a bucket")
+		}
+		return &S3AuditSink{AwsSession: awsSession, Bucket: options.Bucket, Prefix: options.Prefix}, nil
+	case "cloudwatch":
+		if options.LogGroup == "" {
+			return nil, fmt.Errorf("the cloudwatch audit sink requires a log group")
+		}
+		stream := options.LogStream
+		if stream == "" {
+			stream = nodeGroup
+		}
+		return &CloudWatchAuditSink{AwsSession: awsSession, LogGroup: options.LogGroup, LogStream: stream}, nil
+	}
+
+	return nil, fmt.Errorf("unknown audit sink %v, expected one of: file, s3, cloudwatch", options.Sink)
+}
+
+//GetCallerIdentity represents the arn of the aws identity of the session, recorded as the operator of audit events
+func GetCallerIdentity(awsSession session.Session) (string, error) {
+	stsSvc := sts.New(&awsSession)
+
+	output, err := stsSvc.GetCallerIdentity(&sts.GetCallerIdentityInput{})
+	if err != nil {
+		return "", err
+	}
+
+	return aws.StringValue(output.Arn), nil
+}
+
+//Auditor represents recording the mutating calls made for a node group. A nil auditor records nothing.
+type Auditor struct {
+	Sink      AuditSink
+	Operator  string
+	NodeGroup string
+}
+
+//Record represents writing an audit event for an action, failing to write it is logged but does not fail the action
+func (r *Auditor) Record(action string, resource string, before interface{}, after interface{}, err error) {
+	if r == nil || r.Sink == nil {
+		return
+	}
+
+	event := apiTypes.AuditEvent{
+		Time:      time.Now().UTC(),
+		Operator:  r.Operator,
+		NodeGroup: r.NodeGroup,
+		Action:    action,
+		Resource:  resource,
+		Before:    before,
+		After:     after,
+		Outcome:   apiTypes.AuditSucceeded,
+	}
+	if err != nil {
+		event.Outcome = apiTypes.AuditFailed
+		event.Error = err.Error()
+	}
+
+	if writeErr := r.Sink.Write(event); writeErr != nil {
+		withAwsError(logrus.WithFields(logrus.Fields{NodeGroupField: r.NodeGroup, "action": action, "resource": resource}), writeErr).Error("Failed to write audit event")
+	}
+}
+
+//WithAuditor represents setting the auditor of the reconciler and of the services making mutating calls
+func (r *ReconcilerService) WithAuditor(auditor *Auditor) {
+	r.Auditor = auditor
+	r.AsgService.Auditor = auditor
+	r.Ec2Service.Auditor = auditor
+}
+
+//UseNodeGroupAuditor represents auditing the calls made for the node group to its configured sink, as the
+//operator of the current auditor
+func (r *ReconcilerService) UseNodeGroupAuditor(model *apiTypes.OperatorModel) error {
+	sink, err := NewAuditSink(model.AuditOptions, r.Ec2Service.AwsSession, model.NodeGroupName())
+	if err != nil {
+		return err
+	}
+
+	operator := ""
+	if r.Auditor != nil {
+		operator = r.Auditor.Operator
+	}
+
+	r.WithAuditor(&Auditor{Sink: sink, Operator: operator, NodeGroup: model.NodeGroupName()})
+	return nil
+}
+
+// node groups reconciled in parallel append to the same file
+var auditFileMutex sync.Mutex
+
+//FileAuditSink represents audit events appended as json lines to a local file
+type FileAuditSink struct {
+	Path string
+}
+
+//Write represents
+func (r *FileAuditSink) Write(event apiTypes.AuditEvent) error {
+	content, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	auditFileMutex.Lock()
+	defer auditFileMutex.Unlock()
+
+	if err := os.MkdirAll(filepath.Dir(r.Path), 0755); err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(r.Path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	_, err = file.Write(append(content, '\n'))
+	return err
+}
+
+//Query represents
+func (r *FileAuditSink) Query(query AuditQuery) ([]apiTypes.AuditEvent, error) {
+	file, err := os.Open(r.Path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	events := []apiTypes.AuditEvent{}
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 4*1024*1024)
+	for scanner.Scan() {
+		event := apiTypes.AuditEvent{}
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			return nil, err
+		}
+		events = append(events, event)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return filterAuditEvents(events, query), nil
+}
+
+//S3AuditSink represents audit events kept as one json object each, under <prefix><node group>/
+type S3AuditSink struct {
+	AwsSession session.Session
+	Bucket     string
+	Prefix     string
+}
+
+//Write represents
+func (r *S3AuditSink) Write(event apiTypes.AuditEvent) error {
+	s3Svc := s3.New(&r.AwsSession)
+
+	content, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	// keys sort by time within a node group
+	key := fmt.Sprintf("%v%v/%v-%v.json", r.Prefix, event.NodeGroup, event.Time.Format("20060102T150405.000000000Z"), event.Action)
+	input := s3.PutObjectInput{
+		Bucket: aws.String(r.Bucket),
+		Key:    aws.String(key),
+		Body:   bytes.NewReader(content),
+	}
+
+	_, err = s3Svc.PutObject(&input)
+	return err
+}
+
+//Query represents
+func (r *S3AuditSink) Query(query AuditQuery) ([]apiTypes.AuditEvent, error) {
+	s3Svc := s3.New(&r.AwsSession)
+
+	prefix := r.Prefix
+	if query.NodeGroup != "" {
+		prefix += query.NodeGroup + "/"
+	}
+
+	keys := []string{}
+	input := s3.ListObjectsV2Input{Bucket: aws.String(r.Bucket), Prefix: aws.String(prefix)}
+	err := s3Svc.ListObjectsV2Pages(&input, func(page *s3.ListObjectsV2Output, lastPage bool) bool {
+		for _, v := range page.Contents {
+			if query.Since.IsZero() || v.LastModified.After(query.Since) {
+				keys = append(keys, *v.Key)
+			}
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	events := []apiTypes.AuditEvent{}
+	for _, key := range keys {
+		output, err := s3Svc.GetObject(&s3.GetObjectInput{Bucket: aws.String(r.Bucket), Key: aws.String(key)})
+		if err != nil {
+			return nil, err
+		}
+
+		content, err := ioutil.ReadAll(output.Body)
+		output.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		event := apiTypes.AuditEvent{}
+		if err := json.Unmarshal(content, &event); err != nil {
+			return nil, err
+		}
+		events = append(events, event)
+	}
+
+	return filterAuditEvents(events, query), nil
+}
+
+//CloudWatchAuditSink represents audit events written as json log events to a cloudwatch logs stream
+type CloudWatchAuditSink struct {
+	AwsSession session.Session
+	LogGroup   string
+	LogStream  string
+
+	mu            sync.Mutex
+	sequenceToken *string
+	streamReady   bool
+}
+
+//Write represents putting the event, creating the log stream the first time
+func (r *CloudWatchAuditSink) Write(event apiTypes.AuditEvent) error {
+	logsSvc := cloudwatchlogs.New(&r.AwsSession)
+
+	content, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if !r.streamReady {
+		_, err := logsSvc.CreateLogStream(&cloudwatchlogs.CreateLogStreamInput{
+			LogGroupName:  aws.String(r.LogGroup),
+			LogStreamName: aws.String(r.LogStream),
+		})
+		if aErr, ok := err.(awserr.Error); err != nil && !(ok && aErr.Code() == cloudwatchlogs.ErrCodeResourceAlreadyExistsException) {
+			return err
+		}
+		r.streamReady = true
+	}
+
+	input := cloudwatchlogs.PutLogEventsInput{
+		LogGroupName:  aws.String(r.LogGroup),
+		LogStreamName: aws.String(r.LogStream),
+		LogEvents: []*cloudwatchlogs.InputLogEvent{{
+			Message:   aws.String(string(content)),
+			Timestamp: aws.Int64(event.Time.UnixNano() / int64(time.Millisecond)),
+		}},
+		SequenceToken: r.sequenceToken,
+	}
+
+	output, err := logsSvc.PutLogEvents(&input)
+	if aErr, ok := err.(awserr.Error); ok && (aErr.Code() == cloudwatchlogs.ErrCodeInvalidSequenceTokenException || aErr.Code() == cloudwatchlogs.ErrCodeDataAlreadyAcceptedException) {
+		// another writer put events to the stream, retry with the token the stream now expects
+		input.SequenceToken, err = r.uploadSequenceToken(logsSvc)
+		if err != nil {
+			return err
+		}
+		output, err = logsSvc.PutLogEvents(&input)
+	}
+	if err != nil {
+		return err
+	}
+
+	r.sequenceToken = output.NextSequenceToken
+	return nil
+}
+
+func (r *CloudWatchAuditSink) uploadSequenceToken(logsSvc *cloudwatchlogs.CloudWatchLogs) (*string, error) {
+	output, err := logsSvc.DescribeLogStreams(&cloudwatchlogs.DescribeLogStreamsInput{
+		LogGroupName:        aws.String(r.LogGroup),
+		LogStreamNamePrefix: aws.String(r.LogStream),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, v := range output.LogStreams {
+		if aws.StringValue(v.LogStreamName) == r.LogStream {
+			return v.UploadSequenceToken, nil
+		}
+	}
+
+	return nil, fmt.Errorf("log stream %v not found in log group %v", r.LogStream, r.LogGroup)
+}
+
+//Query represents
+func (r *CloudWatchAuditSink) Query(query AuditQuery) ([]apiTypes.AuditEvent, error) {
+	logsSvc := cloudwatchlogs.New(&r.AwsSession)
+
+	input := cloudwatchlogs.FilterLogEventsInput{
+		LogGroupName: aws.String(r.LogGroup),
+	}
+	if query.NodeGroup != "" {
+		input.FilterPattern = aws.String(fmt.Sprintf(`{ $.nodeGroup = "%v" }`, query.NodeGroup))
+	}
+	if !query.Since.IsZero() {
+		input.StartTime = aws.Int64(query.Since.UnixNano() / int64(time.Millisecond))
+	}
+
+	events := []apiTypes.AuditEvent{}
+	var decodeErr error
+	err := logsSvc.FilterLogEventsPages(&input, func(page *cloudwatchlogs.FilterLogEventsOutput, lastPage bool) bool {
+		for _, v := range page.Events {
+			event := apiTypes.AuditEvent{}
+			if decodeErr = json.Unmarshal([]byte(aws.StringValue(v.Message)), &event); decodeErr != nil {
+				return false
+			}
+			events = append(events, event)
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	if decodeErr != nil {
+		return nil, decodeErr
+	}
+
+	return filterAuditEvents(events, query), nil
+}
+
+func filterAuditEvents(events []apiTypes.AuditEvent, query AuditQuery) []apiTypes.AuditEvent {
+	filtered := []apiTypes.AuditEvent{}
+	for _, v := range events {
+		if query.NodeGroup != "" && v.NodeGroup != query.NodeGroup {
+			continue
+		}
+		if !query.Since.IsZero() && v.Time.Before(query.Since) {
+			continue
+		}
+		filtered = append(filtered, v)
+	}
+
+	sort.SliceStable(filtered, func(i, j int) bool { return filtered[i].Time.Before(filtered[j].Time) })
+	if query.Limit > 0 && len(filtered) > query.Limit {
+		filtered = filtered[len(filtered)-query.Limit:]
+	}
+
+	return filtered
+}
+
+// auditAsgState describes the asg as it is before a change, only when the change is audited
+func (r *AsgService) auditAsgState(name string) *autoscaling.Group {
+	if r.Auditor == nil {
+		return nil
+	}
+
+	return r.GetAutoScalingGroup(name)
+}
+
+func asgTagsState(group *autoscaling.Group) map[string]string {
+	if group == nil {
+		return nil
+	}
+
+	tags := map[string]string{}
+	for _, v := range group.Tags {
+		tags[aws.StringValue(v.Key)] = aws.StringValue(v.Value)
+	}
+
+	return tags
+}
+
+func asgLaunchTemplateState(group *autoscaling.Group) map[string]string {
+	if group == nil || group.LaunchTemplate == nil {
+		return nil
+	}
+
+	return map[string]string{
+		"launchTemplate": aws.StringValue(group.LaunchTemplate.LaunchTemplateName),
+		"version":        aws.StringValue(group.LaunchTemplate.Version),
+	}
+}
+
+// auditLaunchTemplateState describes the versions of the launch template before a change, only when the change is audited
+func (r *Ec2Service) auditLaunchTemplateState(name string) map[string]int64 {
+	if r.Auditor == nil {
+		return nil
+	}
+
+	template := r.GetLaunchTemplate(name)
+	if template == nil {
+		return nil
+	}
+
+	return map[string]int64{
+		"defaultVersion": aws.Int64Value(template.DefaultVersionNumber),
+		"latestVersion":  aws.Int64Value(template.LatestVersionNumber),
+	}
+}
+
+// auditLaunchTemplateOptions returns the launch template settings to record, the user data only as its sha256 as it
+// may hold secrets resolved in the config
+func auditLaunchTemplateOptions(options *apiTypes.LaunchTemplateOptions) apiTypes.LaunchTemplateOptions {
+	recorded := *options
+	if recorded.UserData != "" {
+		sum := sha256.Sum256([]byte(recorded.UserData))
+		recorded.UserData = "sha256:" + hex.EncodeToString(sum[:])
+	}
+
+	return recorded
+}
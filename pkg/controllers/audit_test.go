@@ -0,0 +1,28 @@
+package controllers
+
+import (
+	"strings"
+	"testing"
+
+	apiTypes "github.com/anyo/aws-node-group-manager/pkg/apis"
+)
+
+func TestAuditLaunchTemplateOptions(t *testing.T) {
+	options := apiTypes.LaunchTemplateOptions{Name: "workers", InstanceType: "m5.large", UserData: "#!/bin/bash\nexport TOKEN=s3cr3t\n"}
+
+	recorded := auditLaunchTemplateOptions(&options)
+	if strings.Contains(recorded.UserData, "s3cr3t") || !strings.HasPrefix(recorded.UserData, "sha256:") {
+		t.Fatalf("user data recorded as %q, expected its hash", recorded.UserData)
+	}
+	if recorded.InstanceType != "m5.large" {
+		t.Fatalf("instance type recorded as %q", recorded.InstanceType)
+	}
+	if options.UserData != "#!/bin/bash\nexport TOKEN=s3cr3t\n" {
+		t.Fatal("the launch template options were changed")
+	}
+
+	// the same user data records the same hash, a change shows in the audit trail
+	if again := auditLaunchTemplateOptions(&options); again.UserData != recorded.UserData {
+		t.Fatalf("hash changed between records: %v, %v", recorded.UserData, again.UserData)
+	}
+}
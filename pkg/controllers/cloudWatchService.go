@@ -0,0 +1,38 @@
+package controllers
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/cloudwatch"
+	"github.com/sirupsen/logrus"
+)
+
+//CloudWatchService represents cloudwatch operations
+type CloudWatchService struct {
+	AwsSession session.Session
+	Region     string
+	Log        *logrus.Entry
+}
+
+//GetAlarmStates represents getting the state of each named alarm, OK, ALARM or INSUFFICIENT_DATA
+func (r *CloudWatchService) GetAlarmStates(alarmNames []string) (map[string]string, error) {
+	cwSvc := cloudwatch.New(&r.AwsSession)
+
+	input := cloudwatch.DescribeAlarmsInput{
+		AlarmNames: aws.StringSlice(alarmNames),
+	}
+
+	states := make(map[string]string)
+	err := cwSvc.DescribeAlarmsPages(&input, func(page *cloudwatch.DescribeAlarmsOutput, lastPage bool) bool {
+		for _, v := range page.MetricAlarms {
+			states[*v.AlarmName] = *v.StateValue
+		}
+		return true
+	})
+	if err != nil {
+		withAwsError(r.logger(), err).Error("Error while getting alarm states")
+		return nil, err
+	}
+
+	return states, nil
+}
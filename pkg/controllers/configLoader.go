@@ -0,0 +1,256 @@
+package controllers
+
+import (
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// references look like ${NAME}, ${NAME:-default}, ${ssm:/parameter} or ${secretsmanager:secret#key}, $${ is a literal ${
+var configReferencePattern = regexp.MustCompile(`\$\$\{|\$\{([^}]*)\}`)
+
+var envNamePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+var configIndexPattern = regexp.MustCompile(`\[[0-9]+\]`)
+
+// literalConfigFields are go templates, where ${ is their own. userData is interpolated, a script escapes its own
+// variables as $${
+var literalConfigFields = map[string]bool{
+	"notifications.notifiers.template": true,
+}
+
+//ConfigResolver represents looking up the secrets referenced in a config, ssm parameters and secrets manager secrets
+type ConfigResolver interface {
+	GetParameter(name string) (string, error)
+	GetSecret(secretID string, key string) (string, error)
+}
+
+//LoadConfigFiles represents reading a base config and the overlays merged over it in order, then resolving the
+//environment variables and secrets referenced in values. Maps are merged key by key, node groups by asg name, any
+//other value of an overlay replaces the base one. The result is yaml again, ready to be unmarshalled.
+func LoadConfigFiles(paths []string, lookupEnv func(string) (string, bool), secrets ConfigResolver) ([]byte, error) {
+	var merged interface{}
+	for _, path := range paths {
+		content, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+
+		var document interface{}
+		if err := yaml.Unmarshal(content, &document); err != nil {
+			return nil, fmt.Errorf("%v: %v", path, err)
+		}
+		merged = mergeConfig(merged, document, "")
+	}
+
+	resolved, err := interpolateConfig(merged, "", lookupEnv, secrets)
+	if err != nil {
+		return nil, err
+	}
+
+	return yaml.Marshal(resolved)
+}
+
+//ConfigOverlayPath represents the overlay of an environment, config.yaml becomes config.{env}.yaml
+func ConfigOverlayPath(basePath string, env string) string {
+	for _, ext := range []string{".yaml", ".yml"} {
+		if strings.HasSuffix(basePath, ext) {
+			return strings.TrimSuffix(basePath, ext) + "." + env + ext
+		}
+	}
+
+	return basePath + "." + env
+}
+
+// mergeConfig merges the overlay over the base, path is the key of the value in the document
+func mergeConfig(base interface{}, overlay interface{}, path string) interface{} {
+	baseMap, baseIsMap := base.(map[interface{}]interface{})
+	overlayMap, overlayIsMap := overlay.(map[interface{}]interface{})
+	if baseIsMap && overlayIsMap {
+		merged := make(map[interface{}]interface{})
+		for k, v := range baseMap {
+			merged[k] = v
+		}
+		for k, v := range overlayMap {
+			merged[k] = mergeConfig(baseMap[k], v, configPath(path, k))
+		}
+		return merged
+	}
+
+	baseList, baseIsList := base.([]interface{})
+	overlayList, overlayIsList := overlay.([]interface{})
+	if path == "nodeGroups" && baseIsList && overlayIsList {
+		return mergeNodeGroups(baseList, overlayList)
+	}
+
+	if overlay == nil && base != nil {
+		return base
+	}
+	return overlay
+}
+
+// mergeNodeGroups merges the overlay node groups over the base ones with the same asg name, others are added
+func mergeNodeGroups(base []interface{}, overlay []interface{}) []interface{} {
+	merged := append([]interface{}{}, base...)
+	for _, v := range overlay {
+		name := nodeGroupNameOf(v)
+		found := false
+		for i := range merged {
+			if name != "" && nodeGroupNameOf(merged[i]) == name {
+				merged[i] = mergeConfig(merged[i], v, "")
+				found = true
+				break
+			}
+		}
+		if !found {
+			merged = append(merged, v)
+		}
+	}
+
+	return merged
+}
+
+func nodeGroupNameOf(nodeGroup interface{}) string {
+	document, _ := nodeGroup.(map[interface{}]interface{})
+	asg, _ := document["asg"].(map[interface{}]interface{})
+	name, _ := asg["name"].(string)
+	return name
+}
+
+// interpolateConfig replaces the references in every string value but the literal fields. A value that is a
+// reference on its own takes the type of what it resolves to, so ${MAX_INSTANCES} can set an integer.
+func interpolateConfig(value interface{}, path string, lookupEnv func(string) (string, bool), secrets ConfigResolver) (interface{}, error) {
+	switch v := value.(type) {
+	case map[interface{}]interface{}:
+		resolved := make(map[interface{}]interface{})
+		for k, item := range v {
+			r, err := interpolateConfig(item, configPath(path, k), lookupEnv, secrets)
+			if err != nil {
+				return nil, err
+			}
+			resolved[k] = r
+		}
+		return resolved, nil
+	case []interface{}:
+		resolved := []interface{}{}
+		for i, item := range v {
+			r, err := interpolateConfig(item, fmt.Sprintf("%v[%v]", path, i), lookupEnv, secrets)
+			if err != nil {
+				return nil, err
+			}
+			resolved = append(resolved, r)
+		}
+		return resolved, nil
+	case string:
+		if literalConfigField(path) {
+			return v, nil
+		}
+		return interpolateString(v, path, lookupEnv, secrets)
+	}
+
+	return value, nil
+}
+
+func interpolateString(value string, path string, lookupEnv func(string) (string, bool), secrets ConfigResolver) (interface{}, error) {
+	var resolveErr error
+	references := 0
+	resolved := configReferencePattern.ReplaceAllStringFunc(value, func(match string) string {
+		if match == "$${" {
+			return "${"
+		}
+
+		references++
+		r, err := resolveConfigReference(match[2:len(match)-1], lookupEnv, secrets)
+		if err != nil && resolveErr == nil {
+			resolveErr = fmt.Errorf("%v: %v", path, err)
+		}
+		return r
+	})
+	if resolveErr != nil {
+		return nil, resolveErr
+	}
+
+	if references == 1 && configReferencePattern.FindString(value) == value {
+		// only take the scalar type when it reads back the same, a secret like 0123 stays a string
+		var typed interface{}
+		if err := yaml.Unmarshal([]byte(resolved), &typed); err == nil {
+			switch typed.(type) {
+			case int, bool, float64:
+				if fmt.Sprint(typed) == resolved {
+					return typed, nil
+				}
+			}
+		}
+	}
+
+	return resolved, nil
+}
+
+func resolveConfigReference(reference string, lookupEnv func(string) (string, bool), secrets ConfigResolver) (string, error) {
+	kind, name := "env", reference
+	if i := strings.Index(reference, ":"); i > 0 && !strings.HasPrefix(reference[i:], ":-") {
+		kind, name = reference[:i], reference[i+1:]
+	}
+
+	switch kind {
+	case "env":
+		defaultValue, hasDefault := "", false
+		if i := strings.Index(name, ":-"); i >= 0 {
+			name, defaultValue, hasDefault = name[:i], name[i+2:], true
+		}
+		if !envNamePattern.MatchString(name) {
+			return "", fmt.Errorf("invalid environment variable name %v", name)
+		}
+
+		// as in the shell, the default also replaces an empty value
+		value, ok := lookupEnv(name)
+		switch {
+		case hasDefault && value == "":
+			return defaultValue, nil
+		case !ok:
+			return "", fmt.Errorf("environment variable %v is not set", name)
+		}
+		return value, nil
+	case "ssm":
+		if secrets == nil {
+			return "", fmt.Errorf("no resolver for ssm parameter %v", name)
+		}
+		value, err := secrets.GetParameter(name)
+		if err != nil {
+			return "", fmt.Errorf("ssm parameter %v: %v", name, err)
+		}
+		return value, nil
+	case "secretsmanager":
+		if secrets == nil {
+			return "", fmt.Errorf("no resolver for secret %v", name)
+		}
+		secretID, key := name, ""
+		if i := strings.LastIndex(name, "#"); i >= 0 {
+			secretID, key = name[:i], name[i+1:]
+		}
+		value, err := secrets.GetSecret(secretID, key)
+		if err != nil {
+			return "", fmt.Errorf("secret %v: %v", secretID, err)
+		}
+		return value, nil
+	}
+
+	return "", fmt.Errorf("unknown reference ${%v}, expected an environment variable, ssm: or secretsmanager:", reference)
+}
+
+// literalConfigField reports whether the value at path is kept as written, in a single node group or in nodeGroups
+func literalConfigField(path string) bool {
+	field := configIndexPattern.ReplaceAllString(path, "")
+	return literalConfigFields[strings.TrimPrefix(field, "nodeGroups.")]
+}
+
+func configPath(path string, key interface{}) string {
+	if path == "" {
+		return fmt.Sprint(key)
+	}
+
+	return fmt.Sprintf("%v.%v", path, key)
+}
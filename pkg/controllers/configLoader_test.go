@@ -0,0 +1,202 @@
+package controllers
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v2"
+)
+
+// testResolver serves the ssm parameters and secrets of the tests
+type testResolver struct {
+	parameters map[string]string
+	secrets    map[string]string
+}
+
+func (r testResolver) GetParameter(name string) (string, error) {
+	if v, ok := r.parameters[name]; ok {
+		return v, nil
+	}
+
+	return "", fmt.Errorf("parameter not found")
+}
+
+func (r testResolver) GetSecret(secretID string, key string) (string, error) {
+	if v, ok := r.secrets[secretID+"#"+key]; ok {
+		return v, nil
+	}
+
+	return "", fmt.Errorf("secret not found")
+}
+
+func testLookupEnv(name string) (string, bool) {
+	env := map[string]string{"CLUSTER": "tally", "MAX": "5", "EMPTY": "", "ZONE_ID": "0123", "PUBLIC": "true"}
+	v, ok := env[name]
+	return v, ok
+}
+
+// loadTestConfig writes the documents to files and loads them, base first
+func loadTestConfig(t *testing.T, documents ...string) (string, error) {
+	dir := t.TempDir()
+	paths := []string{}
+	for i, v := range documents {
+		path := filepath.Join(dir, fmt.Sprintf("config.%v.yaml", i))
+		if err := ioutil.WriteFile(path, []byte(v), 0644); err != nil {
+			t.Fatalf("write %v: %v", path, err)
+		}
+		paths = append(paths, path)
+	}
+
+	resolver := testResolver{
+		parameters: map[string]string{"/tally/key-name": "ops"},
+		secrets:    map[string]string{"tally/webhook#token": "s3cr3t", "tally/webhook#": `{"token":"s3cr3t"}`},
+	}
+	config, err := LoadConfigFiles(paths, testLookupEnv, resolver)
+	return string(config), err
+}
+
+// normalizeYaml reads and writes the document again, keys end up sorted
+func normalizeYaml(t *testing.T, document string) string {
+	var value interface{}
+	if err := yaml.Unmarshal([]byte(document), &value); err != nil {
+		t.Fatalf("invalid expected yaml: %v", err)
+	}
+	content, err := yaml.Marshal(value)
+	if err != nil {
+		t.Fatalf("marshal expected yaml: %v", err)
+	}
+
+	return string(content)
+}
+
+func TestLoadConfigFilesInterpolation(t *testing.T) {
+	tests := []struct {
+		name     string
+		config   string
+		expected string
+		// err is a substring of the expected error
+		err string
+	}{
+		{name: "environment variable", config: "cluster: ${CLUSTER}", expected: "cluster: tally"},
+		{name: "inside a string", config: "naming:\n  template: ${CLUSTER}-{group}", expected: "naming:\n  template: tally-{group}"},
+		{name: "several references", config: "name: ${CLUSTER}-${MAX}", expected: "name: tally-5"},
+		{name: "typed integer", config: "asg:\n  max: ${MAX}", expected: "asg:\n  max: 5"},
+		{name: "typed boolean", config: "publicIps: ${PUBLIC}", expected: "publicIps: true"},
+		{name: "leading zero stays a string", config: "zone: ${ZONE_ID}", expected: "zone: \"0123\""},
+		{name: "default of an unset variable", config: "cluster: ${UNSET:-dev}", expected: "cluster: dev"},
+		{name: "default of an empty variable", config: "cluster: ${EMPTY:-dev}", expected: "cluster: dev"},
+		{name: "empty variable", config: "cluster: x${EMPTY}", expected: "cluster: x"},
+		{name: "escape", config: "note: $${CLUSTER} is ${CLUSTER}", expected: "note: ${CLUSTER} is tally"},
+		{name: "ssm parameter", config: "keyName: ${ssm:/tally/key-name}", expected: "keyName: ops"},
+		{name: "secret key", config: "token: ${secretsmanager:tally/webhook#token}", expected: "token: s3cr3t"},
+		{name: "whole secret", config: "token: ${secretsmanager:tally/webhook}", expected: "token: '{\"token\":\"s3cr3t\"}'"},
+		{name: "values in lists", config: "groups:\n  - ${CLUSTER}\n  - $${CLUSTER}", expected: "groups:\n  - tally\n  - ${CLUSTER}"},
+		{
+			name:     "user data",
+			config:   "ec2:\n  launchTemplate:\n    userData: \"#!/bin/bash\\n/etc/eks/bootstrap.sh ${CLUSTER} --b64-cluster-ca ${ssm:/tally/key-name} $${X}\"",
+			expected: "ec2:\n  launchTemplate:\n    userData: \"#!/bin/bash\\n/etc/eks/bootstrap.sh tally --b64-cluster-ca ops ${X}\"",
+		},
+		{
+			name:     "user data of a node group list",
+			config:   "nodeGroups:\n  - ec2:\n      launchTemplate:\n        userData: echo ${CLUSTER} $${HOSTNAME}\n    cluster: ${CLUSTER}",
+			expected: "nodeGroups:\n  - ec2:\n      launchTemplate:\n        userData: echo tally ${HOSTNAME}\n    cluster: tally",
+		},
+		{
+			name:     "notifier template kept as written",
+			config:   "notifications:\n  notifiers:\n    - url: ${ssm:/tally/key-name}\n      template: ${NodeGroup} {{.Message}}",
+			expected: "notifications:\n  notifiers:\n    - url: ops\n      template: ${NodeGroup} {{.Message}}",
+		},
+		{name: "unset variable in user data", config: "ec2:\n  launchTemplate:\n    userData: echo ${HOSTNAME}", err: "ec2.launchTemplate.userData: environment variable HOSTNAME is not set"},
+		{name: "unset variable", config: "asg:\n  name: ${UNSET}", err: "asg.name: environment variable UNSET is not set"},
+		{name: "invalid variable name", config: "name: ${1ST}", err: "invalid environment variable name 1ST"},
+		{name: "unknown reference", config: "name: ${vault:kv/tally}", err: "unknown reference ${vault:kv/tally}"},
+		{name: "missing parameter", config: "name: ${ssm:/missing}", err: "ssm parameter /missing"},
+		{name: "missing secret", config: "name: ${secretsmanager:missing#key}", err: "secret missing"},
+		{name: "error path in a list", config: "nodeGroups:\n  - asg:\n      name: ${UNSET}", err: "nodeGroups[0].asg.name"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config, err := loadTestConfig(t, tt.config)
+			switch {
+			case tt.err == "" && err != nil:
+				t.Fatalf("unexpected error: %v", err)
+			case tt.err != "" && err == nil:
+				t.Fatalf("expected an error containing %q, got %v", tt.err, config)
+			case tt.err != "" && !strings.Contains(err.Error(), tt.err):
+				t.Fatalf("expected an error containing %q, got %v", tt.err, err)
+			case tt.err == "" && config != normalizeYaml(t, tt.expected):
+				t.Fatalf("loaded\n%v\nexpected\n%v", config, normalizeYaml(t, tt.expected))
+			}
+		})
+	}
+}
+
+func TestLoadConfigFilesOverlay(t *testing.T) {
+	tests := []struct {
+		name     string
+		base     string
+		overlay  string
+		expected string
+	}{
+		{
+			name:     "maps merged key by key",
+			base:     "asg:\n  name: workers\n  max: 3\n  tags:\n    team: a",
+			overlay:  "asg:\n  max: 10\n  tags:\n    env: prod",
+			expected: "asg:\n  name: workers\n  max: 10\n  tags:\n    team: a\n    env: prod",
+		},
+		{
+			name:     "lists replaced",
+			base:     "ec2:\n  launchTemplate:\n    securityGroups: [sg-1, sg-2]",
+			overlay:  "ec2:\n  launchTemplate:\n    securityGroups: [sg-3]",
+			expected: "ec2:\n  launchTemplate:\n    securityGroups: [sg-3]",
+		},
+		{
+			name:     "empty overlay value keeps the base",
+			base:     "cluster: tally",
+			overlay:  "cluster:",
+			expected: "cluster: tally",
+		},
+		{
+			name:     "node groups merged by asg name",
+			base:     "nodeGroups:\n  - asg:\n      name: workers\n      max: 3\n  - asg:\n      name: batch\n      max: 2",
+			overlay:  "nodeGroups:\n  - asg:\n      name: batch\n      max: 8\n  - asg:\n      name: gpu\n      max: 1",
+			expected: "nodeGroups:\n  - asg:\n      name: workers\n      max: 3\n  - asg:\n      name: batch\n      max: 8\n  - asg:\n      name: gpu\n      max: 1",
+		},
+		{
+			name:     "references resolved after the merge",
+			base:     "cluster: ${UNSET}",
+			overlay:  "cluster: ${CLUSTER}",
+			expected: "cluster: tally",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config, err := loadTestConfig(t, tt.base, tt.overlay)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if config != normalizeYaml(t, tt.expected) {
+				t.Fatalf("loaded\n%v\nexpected\n%v", config, normalizeYaml(t, tt.expected))
+			}
+		})
+	}
+}
+
+func TestConfigOverlayPath(t *testing.T) {
+	tests := map[string]string{
+		"config.yaml":         "config.prod.yaml",
+		"conf/nodes.yml":      "conf/nodes.prod.yml",
+		"/etc/manager/config": "/etc/manager/config.prod",
+	}
+
+	for base, expected := range tests {
+		if actual := ConfigOverlayPath(base, "prod"); actual != expected {
+			t.Fatalf("overlay of %v is %v, expected %v", base, actual, expected)
+		}
+	}
+}
@@ -0,0 +1,241 @@
+package controllers
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+)
+
+//DefaultControlsPath represents where the daemon keeps its controls
+const DefaultControlsPath = ".node-group-manager/controls.json"
+
+//NodeGroupControls represents the operator decisions taken through the api: paused rollouts and approved amis.
+//Controls loaded from a file are saved to it on every change and survive a restart of the daemon, they are read
+//again when another process, such as the tui next to the daemon, changed the file. The others only live in memory.
+//Nil controls pause nothing and approve nothing.
+type NodeGroupControls struct {
+	mu       sync.Mutex
+	paused   map[string]bool
+	approved map[string]string
+	pending  map[string]string
+	// path is the file the controls are saved to, empty when they are not saved
+	path string
+	// info tells whether the file changed since it was last read or written, every save renames a new file over it
+	info os.FileInfo
+}
+
+// savedControls is the content of the controls file
+type savedControls struct {
+	Paused   map[string]bool   `json:"paused,omitempty"`
+	Approved map[string]string `json:"approved,omitempty"`
+	Pending  map[string]string `json:"pending,omitempty"`
+}
+
+//NewNodeGroupControls represents controls kept in memory only
+func NewNodeGroupControls() *NodeGroupControls {
+	return &NodeGroupControls{
+		paused:   make(map[string]bool),
+		approved: make(map[string]string),
+		pending:  make(map[string]string),
+	}
+}
+
+//LoadNodeGroupControls represents reading the controls saved to the file, empty controls when it does not exist yet
+func LoadNodeGroupControls(path string) (*NodeGroupControls, error) {
+	controls := NewNodeGroupControls()
+	controls.path = path
+	if err := controls.load(); err != nil {
+		return nil, err
+	}
+
+	return controls, nil
+}
+
+//Pause represents stopping the rollout of the node group before its next instance is replaced
+func (r *NodeGroupControls) Pause(nodeGroup string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err := r.load(); err != nil {
+		return err
+	}
+	r.paused[nodeGroup] = true
+
+	return r.save()
+}
+
+//Resume represents
+func (r *NodeGroupControls) Resume(nodeGroup string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err := r.load(); err != nil {
+		return err
+	}
+	delete(r.paused, nodeGroup)
+
+	return r.save()
+}
+
+//Paused represents
+func (r *NodeGroupControls) Paused(nodeGroup string) bool {
+	if r == nil {
+		return false
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.reload()
+	return r.paused[nodeGroup]
+}
+
+//ApproveAmi represents allowing the node group to roll out the ami
+func (r *NodeGroupControls) ApproveAmi(nodeGroup string, ami string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err := r.load(); err != nil {
+		return err
+	}
+	r.approved[nodeGroup] = ami
+	if r.pending[nodeGroup] == ami {
+		delete(r.pending, nodeGroup)
+	}
+
+	return r.save()
+}
+
+//AmiApproved represents
+func (r *NodeGroupControls) AmiApproved(nodeGroup string, ami string) bool {
+	if r == nil {
+		return false
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.reload()
+	return r.approved[nodeGroup] == ami
+}
+
+// setPendingAmi records the ami waiting for approval and reports whether it was not already waiting
+func (r *NodeGroupControls) setPendingAmi(nodeGroup string, ami string) bool {
+	if r == nil {
+		return true
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.reload()
+	if r.pending[nodeGroup] == ami {
+		return false
+	}
+	r.pending[nodeGroup] = ami
+
+	// the notification is sent again after a restart at worst
+	if err := r.save(); err != nil {
+		logrus.WithError(err).WithField(NodeGroupField, nodeGroup).Warn("Failed to save the ami waiting for approval")
+	}
+
+	return true
+}
+
+//PendingAmi represents the ami of the node group waiting for approval, empty when there is none
+func (r *NodeGroupControls) PendingAmi(nodeGroup string) string {
+	if r == nil {
+		return ""
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.reload()
+	return r.pending[nodeGroup]
+}
+
+// load reads the controls saved to the file when it changed since it was last read or written, called with the
+// mutex held. A change is read before it is made so the decisions of another process are kept.
+func (r *NodeGroupControls) load() error {
+	if r.path == "" {
+		return nil
+	}
+
+	info, err := os.Stat(r.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if r.info != nil && os.SameFile(info, r.info) && info.ModTime().Equal(r.info.ModTime()) && info.Size() == r.info.Size() {
+		return nil
+	}
+
+	content, err := ioutil.ReadFile(r.path)
+	if err != nil {
+		return err
+	}
+
+	saved := savedControls{}
+	if err := json.Unmarshal(content, &saved); err != nil {
+		return err
+	}
+	r.paused, r.approved, r.pending = make(map[string]bool), make(map[string]string), make(map[string]string)
+	for k, v := range saved.Paused {
+		r.paused[k] = v
+	}
+	for k, v := range saved.Approved {
+		r.approved[k] = v
+	}
+	for k, v := range saved.Pending {
+		r.pending[k] = v
+	}
+	r.info = info
+
+	return nil
+}
+
+// reload loads the file for the lookups, which go on with the controls in memory when it can not be read
+func (r *NodeGroupControls) reload() {
+	if err := r.load(); err != nil {
+		logrus.WithError(err).WithField("path", r.path).Warn("Failed to read the controls")
+	}
+}
+
+// save writes the controls to a temporary file renamed over the previous one, called with the mutex held
+func (r *NodeGroupControls) save() error {
+	if r.path == "" {
+		return nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(r.path), 0755); err != nil {
+		return err
+	}
+
+	content, err := json.MarshalIndent(savedControls{Paused: r.paused, Approved: r.approved, Pending: r.pending}, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	// a temporary file of its own, the processes sharing the controls may save at once
+	tmp, err := ioutil.TempFile(filepath.Dir(r.path), filepath.Base(r.path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(content); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), r.path); err != nil {
+		return err
+	}
+
+	if info, err := os.Stat(r.path); err == nil {
+		r.info = info
+	}
+
+	return nil
+}
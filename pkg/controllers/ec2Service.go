@@ -2,9 +2,9 @@ package controllers
 
 import (
 	"encoding/base64"
-	"log"
-	"reflect"
+	"fmt"
 	"strconv"
+	"strings"
 	"time"
 
 	apiTypes "github.com/anyo/aws-node-group-manager/pkg/apis"
@@ -12,16 +12,24 @@ import (
 	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/sirupsen/logrus"
 )
 
 //Ec2Service represents ssm operations
 type Ec2Service struct {
 	AwsSession session.Session
 	Region     string
+	Cache      *APICache
+	Log        *logrus.Entry
+	Auditor    *Auditor
 }
 
 //GetLaunchTemplate represents
 func (r *Ec2Service) GetLaunchTemplate(name string) *ec2.LaunchTemplate {
+	if cached, ok := r.Cache.Get(launchTemplateCacheKey(name)); ok {
+		return cached.(*ec2.LaunchTemplate)
+	}
+
 	ec2Svc := ec2.New(&r.AwsSession)
 
 	names := []*string{&name}
@@ -29,18 +37,18 @@ func (r *Ec2Service) GetLaunchTemplate(name string) *ec2.LaunchTemplate {
 		LaunchTemplateNames: names,
 	}
 
-	log.Println("Getting launch template: ", name)
+	r.logger().WithField(LaunchTemplateField, name).Debug("Getting launch template")
 	response, err := ec2Svc.DescribeLaunchTemplates(&input)
 	if err != nil {
 		if aErr, ok := err.(awserr.RequestFailure); ok {
 			switch aErr.StatusCode() {
 			case 400:
-				log.Println("Launch template does not exits")
+				r.logger().WithField(LaunchTemplateField, name).Debug("Launch template does not exist")
 				return nil
 			}
 		}
 
-		log.Println("Unknown error while getting launch template", err)
+		withAwsError(r.logger(), err).WithField(LaunchTemplateField, name).Error("Unknown error while getting launch template")
 		return nil
 	}
 
@@ -48,11 +56,39 @@ func (r *Ec2Service) GetLaunchTemplate(name string) *ec2.LaunchTemplate {
 		return nil
 	}
 
+	r.Cache.Set(launchTemplateCacheKey(name), response.LaunchTemplates[0])
 	return response.LaunchTemplates[0]
 }
 
-//GetLaunchTemplateVersion represents
-func (r *Ec2Service) GetLaunchTemplateVersion(name *string, version *string) *ec2.LaunchTemplateVersion {
+//FindLaunchTemplates represents getting the launch templates carrying all of the given tags
+func (r *Ec2Service) FindLaunchTemplates(tags map[string]string) []*ec2.LaunchTemplate {
+	ec2Svc := ec2.New(&r.AwsSession)
+
+	filters := []*ec2.Filter{}
+	for k, v := range tags {
+		filters = append(filters, &ec2.Filter{Name: aws.String("tag:" + k), Values: aws.StringSlice([]string{v})})
+	}
+
+	input := ec2.DescribeLaunchTemplatesInput{
+		Filters: filters,
+	}
+
+	templates := []*ec2.LaunchTemplate{}
+	err := ec2Svc.DescribeLaunchTemplatesPages(&input, func(page *ec2.DescribeLaunchTemplatesOutput, lastPage bool) bool {
+		templates = append(templates, page.LaunchTemplates...)
+		return true
+	})
+	if err != nil {
+		withAwsError(r.logger(), err).Error("Error while finding launch templates by tags")
+		return nil
+	}
+
+	return templates
+}
+
+//GetLaunchTemplateVersion represents the version of the launch template, nil without an error when the launch template
+//or the version does not exist
+func (r *Ec2Service) GetLaunchTemplateVersion(name *string, version *string) (*ec2.LaunchTemplateVersion, error) {
 	ec2Svc := ec2.New(&r.AwsSession)
 
 	versions := []*string{version}
@@ -61,50 +97,237 @@ func (r *Ec2Service) GetLaunchTemplateVersion(name *string, version *string) *ec
 		Versions:           versions,
 	}
 
-	log.Printf("Getting launch template with version: '%v', version: '%v'", *name, *version)
+	r.logger().WithFields(logrus.Fields{LaunchTemplateField: *name, VersionField: *version}).Debug("Getting launch template version")
 	response, err := ec2Svc.DescribeLaunchTemplateVersions(&input)
+	if aErr, ok := err.(awserr.Error); ok && launchTemplateVersionNotFound[aErr.Code()] {
+		return nil, nil
+	}
 	if err != nil {
-		log.Fatal("Error while getting launch template version", err)
+		return nil, err
 	}
 
-	if response.LaunchTemplateVersions == nil {
-		return nil
+	if len(response.LaunchTemplateVersions) == 0 {
+		return nil, nil
 	}
 
-	return response.LaunchTemplateVersions[0]
+	return response.LaunchTemplateVersions[0], nil
 }
 
-//GetLaunchTemplates represents
-func (r *Ec2Service) GetLaunchTemplates() []*ec2.LaunchTemplate {
+// launchTemplateVersionNotFound holds the error codes of a missing launch template or version
+var launchTemplateVersionNotFound = map[string]bool{
+	"InvalidLaunchTemplateName.NotFoundException": true,
+	"InvalidLaunchTemplateId.NotFound":            true,
+	"InvalidLaunchTemplateId.VersionNotFound":     true,
+}
+
+//GetLaunchTemplateVersions represents getting every version of a launch template
+func (r *Ec2Service) GetLaunchTemplateVersions(name *string) ([]*ec2.LaunchTemplateVersion, error) {
 	ec2Svc := ec2.New(&r.AwsSession)
 
-	input := ec2.DescribeLaunchTemplatesInput{}
-	response, err := ec2Svc.DescribeLaunchTemplates(&input)
+	input := ec2.DescribeLaunchTemplateVersionsInput{
+		LaunchTemplateName: name,
+	}
+
+	versions := []*ec2.LaunchTemplateVersion{}
+	err := ec2Svc.DescribeLaunchTemplateVersionsPages(&input, func(page *ec2.DescribeLaunchTemplateVersionsOutput, lastPage bool) bool {
+		versions = append(versions, page.LaunchTemplateVersions...)
+		return true
+	})
 	if err != nil {
-		log.Fatal("Error while getting asgs", err)
+		withAwsError(r.logger(), err).WithField(LaunchTemplateField, *name).Error("Error while getting launch template versions")
+		return nil, err
 	}
 
-	if response.LaunchTemplates == nil {
-		return nil
+	return versions, nil
+}
+
+//GetReferencedLaunchTemplateVersions represents getting the versions of a launch template used by instances that have not been terminated
+func (r *Ec2Service) GetReferencedLaunchTemplateVersions(templateID *string) (map[string]bool, error) {
+	ec2Svc := ec2.New(&r.AwsSession)
+
+	input := ec2.DescribeInstancesInput{
+		Filters: []*ec2.Filter{
+			{Name: aws.String("tag:aws:ec2launchtemplate:id"), Values: []*string{templateID}},
+			{Name: aws.String("instance-state-name"), Values: aws.StringSlice([]string{"pending", "running", "stopping", "stopped"})},
+		},
 	}
 
-	return response.LaunchTemplates
+	referenced := make(map[string]bool)
+	err := ec2Svc.DescribeInstancesPages(&input, func(page *ec2.DescribeInstancesOutput, lastPage bool) bool {
+		for _, reservation := range page.Reservations {
+			for _, instance := range reservation.Instances {
+				for _, v := range instance.Tags {
+					if *v.Key == "aws:ec2launchtemplate:version" {
+						referenced[*v.Value] = true
+					}
+				}
+			}
+		}
+		return true
+	})
+	if err != nil {
+		withAwsError(r.logger(), err).WithField("launchTemplateId", *templateID).Error("Error while getting instances of launch template")
+		return nil, err
+	}
+
+	return referenced, nil
 }
 
-// CreateLaunchTemplate represents
-func (r *Ec2Service) CreateLaunchTemplate(configOptions *apiTypes.LaunchTemplateOptions) (*ec2.LaunchTemplate, error) {
+//GetInstanceLaunchTemplateVersions represents getting the launch template version each instance was launched from
+func (r *Ec2Service) GetInstanceLaunchTemplateVersions(instanceIDs []*string) (map[string]string, error) {
+	instances, err := r.DescribeInstances(instanceIDs)
+	if err != nil {
+		withAwsError(r.logger(), err).Error("Error while getting launch template versions of instances")
+		return nil, err
+	}
+
+	versions := make(map[string]string)
+	for _, instance := range instances {
+		for _, v := range instance.Tags {
+			if *v.Key == "aws:ec2launchtemplate:version" {
+				versions[*instance.InstanceId] = *v.Value
+			}
+		}
+	}
+
+	return versions, nil
+}
+
+//GetInstanceNodeNames represents getting the private dns name of each instance, the name it registers with as a kubernetes node
+func (r *Ec2Service) GetInstanceNodeNames(instanceIDs []*string) (map[string]string, error) {
+	instances, err := r.DescribeInstances(instanceIDs)
+	if err != nil {
+		withAwsError(r.logger(), err).Error("Error while getting node names of instances")
+		return nil, err
+	}
+
+	names := make(map[string]string)
+	for _, instance := range instances {
+		if aws.StringValue(instance.PrivateDnsName) != "" {
+			names[*instance.InstanceId] = *instance.PrivateDnsName
+		}
+	}
+
+	return names, nil
+}
+
+//DescribeInstances represents describing instances by id, 200 per request. Instances described earlier in the pass
+//come from the cache, only their launch details and tags are relied on so the state may be out of date.
+func (r *Ec2Service) DescribeInstances(instanceIDs []*string) ([]*ec2.Instance, error) {
 	ec2Svc := ec2.New(&r.AwsSession)
 
-	templateRequest := r.getLaunchTemplateDataRequest(configOptions)
+	instances := []*ec2.Instance{}
+	missing := []*string{}
+	for _, v := range instanceIDs {
+		if cached, ok := r.Cache.Get(instanceCacheKey(*v)); ok {
+			instances = append(instances, cached.(*ec2.Instance))
+		} else {
+			missing = append(missing, v)
+		}
+	}
 
-	input := ec2.CreateLaunchTemplateInput{
-		LaunchTemplateName: aws.String(configOptions.Name),
-		LaunchTemplateData: templateRequest,
+	for start := 0; start < len(missing); start += 200 {
+		end := start + 200
+		if end > len(missing) {
+			end = len(missing)
+		}
+
+		input := ec2.DescribeInstancesInput{
+			InstanceIds: missing[start:end],
+		}
+
+		err := ec2Svc.DescribeInstancesPages(&input, func(page *ec2.DescribeInstancesOutput, lastPage bool) bool {
+			for _, reservation := range page.Reservations {
+				for _, instance := range reservation.Instances {
+					r.Cache.Set(instanceCacheKey(*instance.InstanceId), instance)
+					instances = append(instances, instance)
+				}
+			}
+			return true
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return instances, nil
+}
+
+//DeleteLaunchTemplateVersions represents deleting launch template versions, at most 200 per request
+func (r *Ec2Service) DeleteLaunchTemplateVersions(name *string, versions []string) error {
+	ec2Svc := ec2.New(&r.AwsSession)
+
+	for start := 0; start < len(versions); start += 200 {
+		end := start + 200
+		if end > len(versions) {
+			end = len(versions)
+		}
+
+		input := ec2.DeleteLaunchTemplateVersionsInput{
+			LaunchTemplateName: name,
+			Versions:           aws.StringSlice(versions[start:end]),
+		}
+
+		output, err := ec2Svc.DeleteLaunchTemplateVersions(&input)
+		r.Auditor.Record("DeleteLaunchTemplateVersions", *name, map[string][]string{"versions": versions[start:end]}, nil, err)
+		if err != nil {
+			withAwsError(r.logger(), err).WithField(LaunchTemplateField, *name).Error("Failed to delete launch template versions")
+			return err
+		}
+
+		for _, v := range output.UnsuccessfullyDeletedLaunchTemplateVersions {
+			r.logger().WithFields(logrus.Fields{LaunchTemplateField: *name, VersionField: *v.VersionNumber, "cause": aws.StringValue(v.ResponseError.Message)}).Error("Failed to delete launch template version")
+		}
+
+		if len(output.UnsuccessfullyDeletedLaunchTemplateVersions) > 0 {
+			return fmt.Errorf("failed to delete %v versions of launch template %v", len(output.UnsuccessfullyDeletedLaunchTemplateVersions), *name)
+		}
+	}
+
+	return nil
+}
+
+//DeleteLaunchTemplate represents deleting a launch template with all of its versions
+func (r *Ec2Service) DeleteLaunchTemplate(name *string) error {
+	ec2Svc := ec2.New(&r.AwsSession)
+
+	before := r.auditLaunchTemplateState(*name)
+	_, err := ec2Svc.DeleteLaunchTemplate(&ec2.DeleteLaunchTemplateInput{LaunchTemplateName: name})
+	r.Cache.Invalidate(launchTemplateCacheKey(*name))
+	r.Auditor.Record("DeleteLaunchTemplate", *name, before, nil, err)
+	if err != nil {
+		withAwsError(r.logger(), err).WithField(LaunchTemplateField, *name).Error("Failed to delete launch template")
+		return err
+	}
+
+	return nil
+}
+
+//GetLaunchTemplates represents
+func (r *Ec2Service) GetLaunchTemplates() ([]*ec2.LaunchTemplate, error) {
+	ec2Svc := ec2.New(&r.AwsSession)
+
+	templates := []*ec2.LaunchTemplate{}
+	input := ec2.DescribeLaunchTemplatesInput{}
+	err := ec2Svc.DescribeLaunchTemplatesPages(&input, func(page *ec2.DescribeLaunchTemplatesOutput, lastPage bool) bool {
+		templates = append(templates, page.LaunchTemplates...)
+		return true
+	})
+	if err != nil {
+		return nil, err
 	}
 
-	response, err := ec2Svc.CreateLaunchTemplate(&input)
+	return templates, nil
+}
+
+// CreateLaunchTemplate represents
+func (r *Ec2Service) CreateLaunchTemplate(configOptions *apiTypes.LaunchTemplateOptions) (*ec2.LaunchTemplate, error) {
+	ec2Svc := ec2.New(&r.AwsSession)
+
+	response, err := ec2Svc.CreateLaunchTemplate(r.getCreateLaunchTemplateInput(configOptions))
+	r.Cache.Invalidate(launchTemplateCacheKey(configOptions.Name))
+	r.Auditor.Record("CreateLaunchTemplate", configOptions.Name, nil, auditLaunchTemplateOptions(configOptions), err)
 	if err != nil {
-		log.Fatal("Error creating new launch template", err)
 		return nil, err
 	}
 
@@ -113,21 +336,29 @@ func (r *Ec2Service) CreateLaunchTemplate(configOptions *apiTypes.LaunchTemplate
 
 //UpdateLaunchTemplate represents
 func (r *Ec2Service) UpdateLaunchTemplate(configOptions *apiTypes.LaunchTemplateOptions) (*ec2.LaunchTemplate, error) {
-	ec2Svc := ec2.New(&r.AwsSession)
-
 	latestVersion, err := r.CreateLaunchTemplateVersion(configOptions)
 	if err != nil {
 		return nil, err
 	}
 
+	return r.SetDefaultLaunchTemplateVersion(aws.String(configOptions.Name), latestVersion)
+}
+
+//SetDefaultLaunchTemplateVersion represents
+func (r *Ec2Service) SetDefaultLaunchTemplateVersion(name *string, version string) (*ec2.LaunchTemplate, error) {
+	ec2Svc := ec2.New(&r.AwsSession)
+
 	input := ec2.ModifyLaunchTemplateInput{
-		LaunchTemplateName: aws.String(configOptions.Name),
-		DefaultVersion:     &latestVersion,
+		LaunchTemplateName: name,
+		DefaultVersion:     aws.String(version),
 	}
 
+	before := r.auditLaunchTemplateState(*name)
 	output, err := ec2Svc.ModifyLaunchTemplate(&input)
+	r.Cache.Invalidate(launchTemplateCacheKey(*name))
+	r.Auditor.Record("SetDefaultLaunchTemplateVersion", *name, before, map[string]string{"defaultVersion": version}, err)
 	if err != nil {
-		log.Println("Failed to update Launch Template version to the latest version:", latestVersion, err)
+		withAwsError(r.logger(), err).WithFields(logrus.Fields{LaunchTemplateField: *name, VersionField: version}).Error("Failed to update launch template default version")
 		return nil, err
 	}
 
@@ -136,49 +367,114 @@ func (r *Ec2Service) UpdateLaunchTemplate(configOptions *apiTypes.LaunchTemplate
 
 //CompareLaunchTemplateData represents
 func (r *Ec2Service) CompareLaunchTemplateData(new *apiTypes.LaunchTemplateOptions, current *ec2.ResponseLaunchTemplateData) (*apiTypes.LaunchTemplateOptions, bool) {
-	changed := false
-	if new.AmiID != *current.ImageId {
-		log.Println("AMI has changed: ", new.AmiID, *current.ImageId)
-		changed = true
-	}
+	return new, len(r.LaunchTemplateDrift(new, current)) > 0
+}
 
-	if new.PublicIps != *current.NetworkInterfaces[0].AssociatePublicIpAddress {
-		log.Println("Public Ips setting has changed: ", new.PublicIps, *current.NetworkInterfaces[0].AssociatePublicIpAddress)
-		changed = true
+//LaunchTemplateDrift represents the fields of the launch template data that differ from the options
+func (r *Ec2Service) LaunchTemplateDrift(new *apiTypes.LaunchTemplateOptions, current *ec2.ResponseLaunchTemplateData) []string {
+	// templates made outside the manager, such as imported ones, may lack any of the fields it sets
+	drift := []string{}
+	if new.AmiID != aws.StringValue(current.ImageId) {
+		r.logger().WithFields(logrus.Fields{"desired": new.AmiID, "current": aws.StringValue(current.ImageId)}).Info("AMI has changed")
+		drift = append(drift, "amiId")
 	}
 
-	currentTags := make(map[string]string)
-	for _, v := range current.TagSpecifications[0].Tags {
-		currentTags[*v.Key] = *v.Value
+	publicIps := false
+	if len(current.NetworkInterfaces) > 0 {
+		publicIps = aws.BoolValue(current.NetworkInterfaces[0].AssociatePublicIpAddress)
+	}
+	if new.PublicIps != publicIps {
+		r.logger().WithFields(logrus.Fields{"desired": new.PublicIps, "current": publicIps}).Info("Public Ips setting has changed")
+		drift = append(drift, "publicIps")
 	}
 
-	if !reflect.DeepEqual(new.Tags, currentTags) {
-		log.Println("Tags have changed.")
-		changed = true
+	currentTags := launchTemplateInstanceTags(current)
+	tagsChanged := len(new.Tags) != len(currentTags)
+	for k, v := range new.Tags {
+		if value, ok := currentTags[k]; !ok || value != v {
+			tagsChanged = true
+		}
+	}
+	if tagsChanged {
+		r.logger().Info("Tags have changed")
+		drift = append(drift, "tags")
 	}
 
-	if new.IamInstanceProfile != *current.IamInstanceProfile.Name {
-		log.Println("IamInstanceProfile has changed.")
-		changed = true
+	if !sameInstanceProfile(new.IamInstanceProfile, current.IamInstanceProfile) {
+		r.logger().Info("IamInstanceProfile has changed")
+		drift = append(drift, "iamInstanceProfile")
 	}
 
-	if new.InstanceType != *current.InstanceType {
-		log.Println("InstanceType has changed.")
-		changed = true
+	if new.InstanceType != aws.StringValue(current.InstanceType) {
+		r.logger().WithFields(logrus.Fields{"desired": new.InstanceType, "current": aws.StringValue(current.InstanceType)}).Info("InstanceType has changed")
+		drift = append(drift, "instanceType")
 	}
 
-	cUserData, _ := base64.StdEncoding.DecodeString(*current.UserData)
+	cUserData, _ := base64.StdEncoding.DecodeString(aws.StringValue(current.UserData))
 	if new.UserData != string(cUserData) {
-		log.Println("UserData has changed.")
-		changed = true
+		r.logger().Info("UserData has changed")
+		drift = append(drift, "userData")
+	}
+
+	if new.KeyName != aws.StringValue(current.KeyName) {
+		r.logger().Info("Key has changed")
+		drift = append(drift, "keyName")
+	}
+
+	return drift
+}
+
+// launchTemplateInstanceTags returns the tags the launch template puts on instances
+func launchTemplateInstanceTags(data *ec2.ResponseLaunchTemplateData) map[string]string {
+	tags := make(map[string]string)
+	for _, spec := range data.TagSpecifications {
+		if aws.StringValue(spec.ResourceType) != "instance" {
+			continue
+		}
+		for _, v := range spec.Tags {
+			tags[aws.StringValue(v.Key)] = aws.StringValue(v.Value)
+		}
 	}
 
-	if new.KeyName != *current.KeyName {
-		log.Println("Key has changed.")
-		changed = true
+	return tags
+}
+
+// launchTemplateInstanceProfile returns the name of the instance profile of a launch template, taken from the arn of
+// the profiles referenced by arn only
+func launchTemplateInstanceProfile(profile *ec2.LaunchTemplateIamInstanceProfileSpecification) string {
+	if profile == nil {
+		return ""
+	}
+	if name := aws.StringValue(profile.Name); name != "" {
+		return name
 	}
 
-	return new, changed
+	arn := aws.StringValue(profile.Arn)
+	return arn[strings.LastIndex(arn, "/")+1:]
+}
+
+// sameInstanceProfile reports whether the configured profile, a name or an arn, is the one of the launch template
+func sameInstanceProfile(configured string, profile *ec2.LaunchTemplateIamInstanceProfileSpecification) bool {
+	if profile != nil && configured != "" && configured == aws.StringValue(profile.Arn) {
+		return true
+	}
+
+	return configured == launchTemplateInstanceProfile(profile)
+}
+
+func (r *Ec2Service) getCreateLaunchTemplateInput(configOptions *apiTypes.LaunchTemplateOptions) *ec2.CreateLaunchTemplateInput {
+	input := ec2.CreateLaunchTemplateInput{
+		LaunchTemplateName: aws.String(configOptions.Name),
+		LaunchTemplateData: r.getLaunchTemplateDataRequest(configOptions),
+	}
+
+	if len(configOptions.ResourceTags) > 0 {
+		input.TagSpecifications = []*ec2.TagSpecification{
+			{ResourceType: aws.String("launch-template"), Tags: r.getEc2Tags(configOptions.ResourceTags)},
+		}
+	}
+
+	return &input
 }
 
 func (r *Ec2Service) getLaunchTemplateDataRequest(configOptions *apiTypes.LaunchTemplateOptions) *ec2.RequestLaunchTemplateData {
@@ -215,7 +511,7 @@ func (r *Ec2Service) getLaunchTemplateDataRequest(configOptions *apiTypes.Launch
 		ImageId:           aws.String(configOptions.AmiID),
 		InstanceType:      aws.String(configOptions.InstanceType),
 		KeyName:           aws.String(configOptions.KeyName),
-		UserData:          aws.String(configOptions.UserData),
+		UserData:          aws.String(base64.StdEncoding.EncodeToString([]byte(configOptions.UserData))),
 		TagSpecifications: tagSpecificationRequest,
 		NetworkInterfaces: networkInterfaces,
 	}
@@ -234,14 +530,17 @@ func (r *Ec2Service) CreateLaunchTemplateVersion(configOptions *apiTypes.LaunchT
 	}
 
 	var latestVersion string
+	before := r.auditLaunchTemplateState(configOptions.Name)
 	ltOutput, err := ec2Svc.CreateLaunchTemplateVersion(&launchTemplateVersionInput)
+	r.Cache.Invalidate(launchTemplateCacheKey(configOptions.Name))
+	r.Auditor.Record("CreateLaunchTemplateVersion", configOptions.Name, before, auditLaunchTemplateOptions(configOptions), err)
 	if err != nil {
-		log.Println("Failed to create Launch Template version.", err)
+		withAwsError(r.logger(), err).WithField(LaunchTemplateField, configOptions.Name).Error("Failed to create launch template version")
 		return latestVersion, err
 	}
 
 	latestVersion = strconv.Itoa(int(*ltOutput.LaunchTemplateVersion.VersionNumber))
-	log.Printf("Created Launch Template version: %v for %v", latestVersion, configOptions.Name)
+	r.logger().WithFields(logrus.Fields{LaunchTemplateField: configOptions.Name, VersionField: latestVersion}).Info("Created launch template version")
 	return latestVersion, nil
 }
 
@@ -256,6 +555,146 @@ func (r *Ec2Service) getEc2Tags(tags map[string]string) []*ec2.Tag {
 	return ec2Tags
 }
 
+//TagResources represents adding or overwriting tags on ec2 resources
+func (r *Ec2Service) TagResources(resourceIDs []*string, tags map[string]string) error {
+	ec2Svc := ec2.New(&r.AwsSession)
+
+	input := ec2.CreateTagsInput{
+		Resources: resourceIDs,
+		Tags:      r.getEc2Tags(tags),
+	}
+
+	_, err := ec2Svc.CreateTags(&input)
+	for _, v := range resourceIDs {
+		r.Auditor.Record("CreateTags", *v, nil, tags, err)
+	}
+	r.Cache.InvalidatePrefix(launchTemplateCacheKey(""))
+	for _, v := range resourceIDs {
+		r.Cache.Invalidate(instanceCacheKey(*v))
+	}
+	if err != nil {
+		withAwsError(r.logger(), err).WithField("resources", aws.StringValueSlice(resourceIDs)).Error("Failed to tag resources")
+		return err
+	}
+
+	return nil
+}
+
+//ResolveSecurityGroups represents looking up the security groups matched by each selector
+func (r *Ec2Service) ResolveSecurityGroups(selectors []apiTypes.ResourceSelector) ([]*ec2.SecurityGroup, error) {
+	ec2Svc := ec2.New(&r.AwsSession)
+
+	groups := []*ec2.SecurityGroup{}
+	seen := make(map[string]bool)
+	for _, selector := range selectors {
+		input := ec2.DescribeSecurityGroupsInput{
+			Filters: r.getSelectorFilters(selector, "group-id"),
+		}
+
+		response, err := ec2Svc.DescribeSecurityGroups(&input)
+		if err != nil {
+			withAwsError(r.logger(), err).Error("Failed to describe security groups")
+			return nil, err
+		}
+
+		if len(response.SecurityGroups) == 0 {
+			return nil, fmt.Errorf("security group selector %+v did not match any security groups", selector)
+		}
+
+		for _, v := range response.SecurityGroups {
+			if !seen[*v.GroupId] {
+				seen[*v.GroupId] = true
+				groups = append(groups, v)
+			}
+		}
+	}
+
+	return groups, nil
+}
+
+//ResolveSubnets represents looking up the subnets matched by each selector
+func (r *Ec2Service) ResolveSubnets(selectors []apiTypes.ResourceSelector) ([]*ec2.Subnet, error) {
+	ec2Svc := ec2.New(&r.AwsSession)
+
+	subnets := []*ec2.Subnet{}
+	seen := make(map[string]bool)
+	for _, selector := range selectors {
+		input := ec2.DescribeSubnetsInput{
+			Filters: r.getSelectorFilters(selector, "subnet-id"),
+		}
+
+		response, err := ec2Svc.DescribeSubnets(&input)
+		if err != nil {
+			withAwsError(r.logger(), err).Error("Failed to describe subnets")
+			return nil, err
+		}
+
+		if len(response.Subnets) == 0 {
+			return nil, fmt.Errorf("subnet selector %+v did not match any subnets", selector)
+		}
+
+		for _, v := range response.Subnets {
+			if !seen[*v.SubnetId] {
+				seen[*v.SubnetId] = true
+				subnets = append(subnets, v)
+			}
+		}
+	}
+
+	return subnets, nil
+}
+
+func (r *Ec2Service) getSelectorFilters(selector apiTypes.ResourceSelector, idFilterName string) []*ec2.Filter {
+	filters := []*ec2.Filter{}
+	if selector.ID != "" {
+		filters = append(filters, &ec2.Filter{Name: aws.String(idFilterName), Values: aws.StringSlice([]string{selector.ID})})
+	}
+
+	if selector.Name != "" {
+		filters = append(filters, &ec2.Filter{Name: aws.String("tag:Name"), Values: aws.StringSlice([]string{selector.Name})})
+	}
+
+	for k, v := range selector.Tags {
+		if v == "" {
+			filters = append(filters, &ec2.Filter{Name: aws.String("tag-key"), Values: aws.StringSlice([]string{k})})
+			continue
+		}
+		filters = append(filters, &ec2.Filter{Name: aws.String("tag:" + k), Values: aws.StringSlice([]string{v})})
+	}
+
+	return filters
+}
+
+//FindInstances represents getting the instances that have not been terminated carrying all of the given tags
+func (r *Ec2Service) FindInstances(tags map[string]string) ([]*ec2.Instance, error) {
+	ec2Svc := ec2.New(&r.AwsSession)
+
+	filters := []*ec2.Filter{
+		{Name: aws.String("instance-state-name"), Values: aws.StringSlice([]string{"pending", "running", "stopping", "stopped"})},
+	}
+	for k, v := range tags {
+		filters = append(filters, &ec2.Filter{Name: aws.String("tag:" + k), Values: aws.StringSlice([]string{v})})
+	}
+
+	input := ec2.DescribeInstancesInput{
+		Filters: filters,
+	}
+
+	instances := []*ec2.Instance{}
+	err := ec2Svc.DescribeInstancesPages(&input, func(page *ec2.DescribeInstancesOutput, lastPage bool) bool {
+		for _, reservation := range page.Reservations {
+			instances = append(instances, reservation.Instances...)
+		}
+		return true
+	})
+	if err != nil {
+		withAwsError(r.logger(), err).Error("Error while finding instances by tags")
+		return nil, err
+	}
+
+	return instances, nil
+}
+
 // ShutDownInstance represents
 func (r *Ec2Service) ShutDownInstance(instanceID *string) bool {
 	ec2Svc := ec2.New(&r.AwsSession)
@@ -264,29 +703,32 @@ func (r *Ec2Service) ShutDownInstance(instanceID *string) bool {
 		InstanceIds: []*string{instanceID},
 	}
 
+	logger := r.logger().WithField(InstanceIDField, *instanceID)
 	output, err := ec2Svc.StopInstances(&input)
+	r.Auditor.Record("StopInstances", *instanceID, nil, nil, err)
 	if err != nil {
-		log.Printf("Failed to stop instance: %v, error: %v", *instanceID, err)
+		withAwsError(logger, err).Error("Failed to stop instance")
 		return false
 	}
 
 	state := output.StoppingInstances[0].CurrentState.Name
-	log.Printf("Stopping instance: '%v', State: '%v'", *instanceID, *state)
+	logger.WithField("state", *state).Info("Stopping instance")
 
+	backoff := NewPollBackoff(2*time.Second, 30*time.Second)
 	for {
 		if *state == "stopped" || *state == "terminated" {
-			log.Printf("Instance stopped: '%v', State: '%v'", *instanceID, *state)
+			logger.WithField("state", *state).Info("Instance stopped")
 			return true
 		}
 
 		state = r.GetInstanceState(instanceID)
 		if state == nil {
-			time.Sleep(2 * time.Second)
+			backoff.Wait()
 			continue
 		}
 
-		log.Printf("Stopping instance: '%v', State: '%v'", *instanceID, *state)
-		time.Sleep(2 * time.Second)
+		logger.WithField("state", *state).Debug("Stopping instance")
+		backoff.Wait()
 	}
 }
 
@@ -298,51 +740,145 @@ func (r *Ec2Service) TerminateInstance(instanceID *string) bool {
 		InstanceIds: []*string{instanceID},
 	}
 
+	logger := r.logger().WithField(InstanceIDField, *instanceID)
 	output, err := ec2Svc.TerminateInstances(&input)
+	r.Auditor.Record("TerminateInstances", *instanceID, nil, nil, err)
 	if err != nil {
-		log.Printf("Failed to terminate instance: %v, error: %v", *instanceID, err)
+		withAwsError(logger, err).Error("Failed to terminate instance")
 		return false
 	}
 
 	state := output.TerminatingInstances[0].CurrentState.Name
-	log.Printf("Terminating instance: '%v', State: '%v'", *instanceID, *state)
+	logger.WithField("state", *state).Info("Terminating instance")
 
+	backoff := NewPollBackoff(2*time.Second, 30*time.Second)
 	for {
 		if *state == "terminated" {
-			log.Printf("Instance terminated: '%v', State: '%v'", *instanceID, *state)
+			logger.WithField("state", *state).Info("Instance terminated")
 			return true
 		}
 
 		state = r.GetInstanceState(instanceID)
 		if state == nil {
-			time.Sleep(2 * time.Second)
+			backoff.Wait()
 			continue
 		}
 
-		log.Printf("Termnate instance: '%v', State: '%v'", *instanceID, *state)
-		time.Sleep(2 * time.Second)
+		logger.WithField("state", *state).Debug("Terminating instance")
+		backoff.Wait()
 	}
 }
 
-// GetInstanceState represents
-func (r *Ec2Service) GetInstanceState(instanceID *string) *string {
+//GetImageCreationDate represents
+func (r *Ec2Service) GetImageCreationDate(imageID *string) (time.Time, error) {
+	if cached, ok := r.Cache.Get(imageCacheKey(*imageID)); ok {
+		return cached.(time.Time), nil
+	}
+
+	ec2Svc := ec2.New(&r.AwsSession)
+
+	output, err := ec2Svc.DescribeImages(&ec2.DescribeImagesInput{ImageIds: []*string{imageID}})
+	if err != nil {
+		withAwsError(r.logger(), err).WithField("ami", *imageID).Error("Failed to describe image")
+		return time.Time{}, err
+	}
+
+	if len(output.Images) == 0 {
+		return time.Time{}, fmt.Errorf("image %v does not exist", *imageID)
+	}
+
+	created, err := time.Parse(time.RFC3339, aws.StringValue(output.Images[0].CreationDate))
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	r.Cache.Set(imageCacheKey(*imageID), created)
+	return created, nil
+}
+
+//InstanceTypeExists represents checking the instance type is offered in the region of the session
+func (r *Ec2Service) InstanceTypeExists(instanceType string) (bool, error) {
 	ec2Svc := ec2.New(&r.AwsSession)
 
-	input := ec2.DescribeInstanceStatusInput{
-		InstanceIds:         []*string{instanceID},
-		IncludeAllInstances: aws.Bool(true),
+	output, err := ec2Svc.DescribeInstanceTypes(&ec2.DescribeInstanceTypesInput{InstanceTypes: []*string{aws.String(instanceType)}})
+	if err != nil {
+		if aerr, ok := err.(awserr.Error); ok && aerr.Code() == "InvalidInstanceType" {
+			return false, nil
+		}
+		withAwsError(r.logger(), err).WithField("instanceType", instanceType).Error("Failed to describe instance type")
+		return false, err
 	}
 
-	output, err := ec2Svc.DescribeInstanceStatus(&input)
+	return len(output.InstanceTypes) > 0, nil
+}
+
+//InstanceGone represents checking whether an instance is shutting down, terminated or no longer known to ec2
+func (r *Ec2Service) InstanceGone(instanceID *string) (bool, error) {
+	states, err := r.GetInstanceStates([]*string{instanceID})
 	if err != nil {
-		log.Printf("Failed to get instance state: %v, error: %v", *instanceID, err)
+		if aerr, ok := err.(awserr.Error); ok && aerr.Code() == "InvalidInstanceID.NotFound" {
+			return true, nil
+		}
+		return false, err
+	}
+
+	state, ok := states[*instanceID]
+	return !ok || state == ec2.InstanceStateNameShuttingDown || state == ec2.InstanceStateNameTerminated, nil
+}
+
+// GetInstanceState represents
+func (r *Ec2Service) GetInstanceState(instanceID *string) *string {
+	states, err := r.GetInstanceStates([]*string{instanceID})
+	if err != nil {
+		withAwsError(r.logger(), err).WithField(InstanceIDField, *instanceID).Error("Failed to get instance state")
 		return nil
 	}
 
-	for _, v := range output.InstanceStatuses {
-		currentState := v.InstanceState.Name
-		return currentState
+	if state, ok := states[*instanceID]; ok {
+		return aws.String(state)
 	}
 
 	return nil
 }
+
+//GetInstanceStates represents getting the current state of each instance, 100 per request. Never cached as it is polled.
+func (r *Ec2Service) GetInstanceStates(instanceIDs []*string) (map[string]string, error) {
+	ec2Svc := ec2.New(&r.AwsSession)
+
+	states := make(map[string]string)
+	for start := 0; start < len(instanceIDs); start += 100 {
+		end := start + 100
+		if end > len(instanceIDs) {
+			end = len(instanceIDs)
+		}
+
+		input := ec2.DescribeInstanceStatusInput{
+			InstanceIds:         instanceIDs[start:end],
+			IncludeAllInstances: aws.Bool(true),
+		}
+
+		err := ec2Svc.DescribeInstanceStatusPages(&input, func(page *ec2.DescribeInstanceStatusOutput, lastPage bool) bool {
+			for _, v := range page.InstanceStatuses {
+				states[*v.InstanceId] = *v.InstanceState.Name
+			}
+			return true
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return states, nil
+}
+
+func launchTemplateCacheKey(name string) string {
+	return "launchtemplate:" + name
+}
+
+func instanceCacheKey(instanceID string) string {
+	return "instance:" + instanceID
+}
+
+func imageCacheKey(imageID string) string {
+	return "image:" + imageID
+}
@@ -0,0 +1,93 @@
+package controllers
+
+import (
+	"encoding/base64"
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+// importedLaunchTemplateData is a launch template made outside the manager: security groups without network
+// interfaces, an instance profile referenced by arn, no tag specifications, no key and no block device mappings
+func importedLaunchTemplateData() *ec2.ResponseLaunchTemplateData {
+	return &ec2.ResponseLaunchTemplateData{
+		ImageId:            aws.String("ami-1"),
+		InstanceType:       aws.String("m5.large"),
+		SecurityGroupIds:   aws.StringSlice([]string{"sg-1"}),
+		IamInstanceProfile: &ec2.LaunchTemplateIamInstanceProfileSpecification{Arn: aws.String("arn:aws:iam::123456789012:instance-profile/eks/workers")},
+	}
+}
+
+func TestLaunchTemplateDrift(t *testing.T) {
+	tests := []struct {
+		name   string
+		data   func() *ec2.ResponseLaunchTemplateData
+		change func(data *ec2.ResponseLaunchTemplateData)
+		// drift is what differs from the options imported from the unchanged data
+		drift []string
+	}{
+		{name: "imported template", data: importedLaunchTemplateData, change: func(*ec2.ResponseLaunchTemplateData) {}},
+		{name: "new ami", data: importedLaunchTemplateData, change: func(d *ec2.ResponseLaunchTemplateData) { d.ImageId = aws.String("ami-2") }, drift: []string{"amiId"}},
+		{
+			name: "instance profile referenced by name",
+			data: importedLaunchTemplateData,
+			change: func(d *ec2.ResponseLaunchTemplateData) {
+				d.IamInstanceProfile = &ec2.LaunchTemplateIamInstanceProfileSpecification{Name: aws.String("workers")}
+			},
+		},
+		{
+			name:   "instance profile removed",
+			data:   importedLaunchTemplateData,
+			change: func(d *ec2.ResponseLaunchTemplateData) { d.IamInstanceProfile = nil },
+			drift:  []string{"iamInstanceProfile"},
+		},
+		{
+			name: "public ips and tags added",
+			data: importedLaunchTemplateData,
+			change: func(d *ec2.ResponseLaunchTemplateData) {
+				d.NetworkInterfaces = []*ec2.LaunchTemplateInstanceNetworkInterfaceSpecification{{AssociatePublicIpAddress: aws.Bool(true)}}
+				d.TagSpecifications = []*ec2.LaunchTemplateTagSpecification{
+					{ResourceType: aws.String("volume"), Tags: []*ec2.Tag{{Key: aws.String("team"), Value: aws.String("b")}}},
+					{ResourceType: aws.String("instance"), Tags: []*ec2.Tag{{Key: aws.String("team"), Value: aws.String("a")}}},
+				}
+			},
+			drift: []string{"publicIps", "tags"},
+		},
+		{
+			name: "user data and key added",
+			data: importedLaunchTemplateData,
+			change: func(d *ec2.ResponseLaunchTemplateData) {
+				d.UserData = aws.String(base64.StdEncoding.EncodeToString([]byte("#!/bin/bash")))
+				d.KeyName = aws.String("ops")
+			},
+			drift: []string{"userData", "keyName"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			options := importLaunchTemplateData("workers-lt", tt.data())
+			options.AmiID = "ami-1"
+
+			current := tt.data()
+			tt.change(current)
+			drift := (&Ec2Service{}).LaunchTemplateDrift(&options, current)
+			if len(drift) != len(tt.drift) || (len(drift) > 0 && !reflect.DeepEqual(drift, tt.drift)) {
+				t.Fatalf("drift %v, expected %v", drift, tt.drift)
+			}
+		})
+	}
+}
+
+func TestLaunchTemplateDriftEmptyTags(t *testing.T) {
+	options := importLaunchTemplateData("workers-lt", importedLaunchTemplateData())
+	options.AmiID = "ami-1"
+	// a config without tags has no map at all
+	options.Tags = nil
+
+	if drift := (&Ec2Service{}).LaunchTemplateDrift(&options, importedLaunchTemplateData()); len(drift) > 0 {
+		t.Fatalf("drift %v of a template without tags", drift)
+	}
+}
@@ -0,0 +1,107 @@
+package controllers
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+//KubeService represents kubernetes node operations, performed through kubectl
+type KubeService struct {
+	Kubectl    string
+	Kubeconfig string
+	Log        *logrus.Entry
+}
+
+//IsNodeReady represents checking the Ready condition of a node, a node that has not registered yet is not ready
+func (r *KubeService) IsNodeReady(nodeName string) (bool, error) {
+	output, err := r.kubectl("get", "node", nodeName, "--ignore-not-found", "-o", `jsonpath={.status.conditions[?(@.type=="Ready")].status}`)
+	if err != nil {
+		return false, err
+	}
+
+	return strings.TrimSpace(string(output)) == "True", nil
+}
+
+//WaitForNodesReady represents waiting for every node to register and become Ready
+func (r *KubeService) WaitForNodesReady(nodeNames []string, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	backoff := NewPollBackoff(5*time.Second, time.Minute)
+
+	for {
+		pending := 0
+		for _, v := range nodeNames {
+			ready, err := r.IsNodeReady(v)
+			if err != nil {
+				return err
+			}
+			if !ready {
+				pending++
+			}
+		}
+
+		if pending == 0 {
+			r.logger().WithField("nodes", len(nodeNames)).Info("All nodes are Ready")
+			return nil
+		}
+
+		if timeout > 0 && time.Now().After(deadline) {
+			return fmt.Errorf("timed out after %v waiting for %v of %v nodes to be Ready", timeout, pending, len(nodeNames))
+		}
+
+		r.logger().WithFields(logrus.Fields{"nodes": len(nodeNames), "pending": pending}).Info("Awaiting nodes to be Ready")
+		backoff.Wait()
+	}
+}
+
+//CordonNode represents marking a node unschedulable
+func (r *KubeService) CordonNode(nodeName string) error {
+	_, err := r.kubectl("cordon", nodeName)
+	return err
+}
+
+//UncordonNode represents marking a node schedulable again
+func (r *KubeService) UncordonNode(nodeName string) error {
+	_, err := r.kubectl("uncordon", nodeName)
+	return err
+}
+
+//DrainNode represents evicting all pods from a node, daemonsets are left in place
+func (r *KubeService) DrainNode(nodeName string, timeout time.Duration) error {
+	r.logger().WithField("node", nodeName).Info("Draining node")
+	_, err := r.kubectl("drain", nodeName, "--ignore-daemonsets", "--delete-emptydir-data", "--force", "--timeout="+timeout.String())
+	return err
+}
+
+func (r *KubeService) kubectl(args ...string) ([]byte, error) {
+	return r.kubectlWithInput(nil, args...)
+}
+
+// kubectlWithInput runs kubectl with the given content on stdin, for commands reading manifests from "-f -"
+func (r *KubeService) kubectlWithInput(input []byte, args ...string) ([]byte, error) {
+	kubectl := r.Kubectl
+	if kubectl == "" {
+		kubectl = "kubectl"
+	}
+
+	if r.Kubeconfig != "" {
+		args = append([]string{"--kubeconfig", r.Kubeconfig}, args...)
+	}
+
+	cmd := exec.Command(kubectl, args...)
+	if input != nil {
+		cmd.Stdin = bytes.NewReader(input)
+	}
+
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		r.logger().WithError(err).WithFields(logrus.Fields{"args": strings.Join(args, " "), "output": strings.TrimSpace(string(output))}).Error("kubectl failed")
+		return output, fmt.Errorf("kubectl %v: %v", args[0], err)
+	}
+
+	return output, nil
+}
@@ -0,0 +1,568 @@
+package controllers
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	apiTypes "github.com/anyo/aws-node-group-manager/pkg/apis"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	defaultLockPath      = ".node-group-manager/locks"
+	defaultLockTTL       = 2 * time.Minute
+	defaultLockNamespace = "kube-system"
+)
+
+//Locker represents a lease on a node group, held by one acquisition at a time until released or expired. Each
+//acquisition gets its own token, an acquisition by the same process is refused like any other.
+type Locker interface {
+	// Acquire fails when another acquisition holds a lease that has not expired, the token identifies this one
+	Acquire(nodeGroup string) (string, error)
+	// Renew extends the lease of the acquisition
+	Renew(nodeGroup string, token string) error
+	Release(nodeGroup string, token string) error
+}
+
+//NewLocker represents creating the locker for the configured backend, holder identifies this process
+func NewLocker(options apiTypes.LockOptions, awsSession session.Session, kube KubeService, holder string) (Locker, error) {
+	ttl := defaultLockTTL
+	if options.TTLSeconds > 0 {
+		ttl = time.Duration(options.TTLSeconds) * time.Second
+	}
+
+	switch options.Backend {
+	case "", "file":
+		path := options.Path
+		if path == "" {
+			path = defaultLockPath
+		}
+		return &FileLocker{Dir: path, Holder: holder, TTL: ttl}, nil
+	case "dynamodb":
+		if options.Table == "" {
+			return nil, fmt.Errorf("the dynamodb lock backend requires a table")
+		}
+		return &DynamoDBLocker{AwsSession: awsSession, Table: options.Table, Holder: holder, TTL: ttl}, nil
+	case "kubernetes":
+		namespace := options.Namespace
+		if namespace == "" {
+			namespace = defaultLockNamespace
+		}
+		return &KubeLeaseLocker{KubeService: kube, Namespace: namespace, Holder: holder, TTL: ttl}, nil
+	}
+
+	return nil, fmt.Errorf("unknown lock backend %v, expected one of: file, dynamodb, kubernetes", options.Backend)
+}
+
+//LockHolder represents the identity of this process in locks, host name and process id
+func LockHolder() string {
+	hostname, _ := os.Hostname()
+	return fmt.Sprintf("%v-%v", hostname, os.Getpid())
+}
+
+// newLockToken identifies one acquisition of the holder
+func newLockToken(holder string) string {
+	suffix := make([]byte, 8)
+	if _, err := rand.Read(suffix); err != nil {
+		return fmt.Sprintf("%v-%v", holder, time.Now().UnixNano())
+	}
+
+	return holder + "-" + hex.EncodeToString(suffix)
+}
+
+// nodeGroupMutexes serializes the callers of this process working on the same node group, the lockers tell
+// acquisitions apart but a caller waits here instead of failing
+var nodeGroupMutexes sync.Map
+
+//WithNodeGroupLock represents running fn while holding the lock of the node group, renewing it in the background.
+//When a renewal fails another holder may take over, the context passed to fn is cancelled, the mutating steps of
+//the reconciler stop and an error is returned.
+func (r *ReconcilerService) WithNodeGroupLock(model *apiTypes.OperatorModel, fn func(ctx context.Context) error) error {
+	locker := r.Locker
+	if locker == nil {
+		var err error
+		locker, err = NewLocker(model.LockOptions, r.Ec2Service.AwsSession, r.KubeService, LockHolder())
+		if err != nil {
+			return err
+		}
+	}
+
+	nodeGroup := model.NodeGroupKey()
+	mutex, _ := nodeGroupMutexes.LoadOrStore(nodeGroup, &sync.Mutex{})
+	mutex.(*sync.Mutex).Lock()
+	defer mutex.(*sync.Mutex).Unlock()
+
+	token, err := locker.Acquire(nodeGroup)
+	if err != nil {
+		return fmt.Errorf("failed to lock node group %v: %v", nodeGroup, err)
+	}
+	r.logger().WithField(NodeGroupField, nodeGroup).Info("Acquired lock of node group")
+
+	ttl := defaultLockTTL
+	if model.LockOptions.TTLSeconds > 0 {
+		ttl = time.Duration(model.LockOptions.TTLSeconds) * time.Second
+	}
+
+	ctx, cancel := context.WithCancelCause(context.Background())
+	defer cancel(nil)
+
+	stop := make(chan struct{})
+	renewed := make(chan struct{})
+	go func() {
+		defer close(renewed)
+		ticker := time.NewTicker(ttl / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				if err := locker.Renew(nodeGroup, token); err != nil {
+					withAwsError(r.logger(), err).WithField(NodeGroupField, nodeGroup).Error("Failed to renew lock of node group, stopping")
+					cancel(fmt.Errorf("lost the lock of node group %v: %v", nodeGroup, err))
+					return
+				}
+			}
+		}
+	}()
+
+	previous := r.lockContext
+	r.lockContext = ctx
+	err = fn(ctx)
+	r.lockContext = previous
+
+	close(stop)
+	<-renewed
+	if releaseErr := locker.Release(nodeGroup, token); releaseErr != nil {
+		withAwsError(r.logger(), releaseErr).WithField(NodeGroupField, nodeGroup).Error("Failed to release lock of node group")
+	}
+
+	if lost := context.Cause(ctx); err == nil && lost != nil {
+		return lost
+	}
+
+	return err
+}
+
+// lockLost returns an error once the lock held by WithNodeGroupLock could not be renewed, checked before mutating steps
+func (r *ReconcilerService) lockLost() error {
+	if r.lockContext == nil {
+		return nil
+	}
+
+	return context.Cause(r.lockContext)
+}
+
+// sleepLocked waits for d, cut short with the error of lockLost when the lock is lost meanwhile
+func (r *ReconcilerService) sleepLocked(d time.Duration) error {
+	if r.lockContext == nil {
+		time.Sleep(d)
+		return nil
+	}
+
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-r.lockContext.Done():
+		return r.lockLost()
+	}
+}
+
+//FileLocker represents locks kept as files in a local directory, only safe between processes on one machine
+type FileLocker struct {
+	Dir    string
+	Holder string
+	TTL    time.Duration
+}
+
+type fileLock struct {
+	Holder    string    `json:"holder"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+//Acquire represents creating the lock file exclusively, taking it over when it has expired
+func (r *FileLocker) Acquire(nodeGroup string) (string, error) {
+	if err := os.MkdirAll(r.Dir, 0755); err != nil {
+		return "", err
+	}
+
+	token := newLockToken(r.Holder)
+	err := r.create(nodeGroup, token)
+	if err == nil {
+		return token, nil
+	}
+	if !os.IsExist(err) {
+		return "", err
+	}
+
+	current, err := r.read(r.path(nodeGroup))
+	if os.IsNotExist(err) {
+		// released in the meantime
+		if err := r.create(nodeGroup, token); err != nil {
+			return "", err
+		}
+		return token, nil
+	}
+	if err != nil {
+		return "", err
+	}
+
+	if time.Now().Before(current.ExpiresAt) {
+		return "", fmt.Errorf("held by %v until %v", current.Holder, current.ExpiresAt.Format(time.RFC3339))
+	}
+
+	logrus.WithFields(logrus.Fields{NodeGroupField: nodeGroup, "holder": current.Holder}).Info("Taking over expired lock of node group")
+	if err := r.takeOver(nodeGroup, current, token); err != nil {
+		return "", err
+	}
+
+	return token, nil
+}
+
+//Renew represents extending the lock file of the acquisition, unless it has expired
+func (r *FileLocker) Renew(nodeGroup string, token string) error {
+	current, err := r.read(r.path(nodeGroup))
+	if err != nil {
+		return err
+	}
+
+	if current.Holder != token {
+		return fmt.Errorf("lock taken over by %v", current.Holder)
+	}
+	// an expired lock may be taken over at any time, it is not extended
+	if time.Now().After(current.ExpiresAt) {
+		return fmt.Errorf("lock expired at %v", current.ExpiresAt.Format(time.RFC3339))
+	}
+
+	// replaced by a rename, readers never see a partial file
+	temp, err := r.writeTemp(nodeGroup, token)
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(temp, r.path(nodeGroup))
+}
+
+//Release represents removing the lock file of the acquisition
+func (r *FileLocker) Release(nodeGroup string, token string) error {
+	current, err := r.read(r.path(nodeGroup))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	// an expired lock is left to be taken over, another process may be replacing it
+	if current.Holder != token || time.Now().After(current.ExpiresAt) {
+		return nil
+	}
+
+	return os.Remove(r.path(nodeGroup))
+}
+
+// create writes the lock to a temporary file and links it in place, which fails when the lock file exists
+func (r *FileLocker) create(nodeGroup string, token string) error {
+	temp, err := r.writeTemp(nodeGroup, token)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(temp)
+
+	return os.Link(temp, r.path(nodeGroup))
+}
+
+// takeOver replaces an expired lock. The first process to create the guard file of the expired acquisition wins,
+// the guard is removed once the lock is replaced so a process that read the expired lock late finds the new one.
+func (r *FileLocker) takeOver(nodeGroup string, expired *fileLock, token string) error {
+	temp, err := r.writeTemp(nodeGroup, token)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(temp)
+
+	guard := r.path(nodeGroup) + "." + expired.Holder + ".takeover"
+	if err := os.Link(temp, guard); err != nil {
+		if !os.IsExist(err) {
+			return err
+		}
+		// left by a process that stopped while taking over
+		if info, statErr := os.Stat(guard); statErr == nil && time.Since(info.ModTime()) > r.TTL {
+			os.Remove(guard)
+		}
+		return fmt.Errorf("taken over by another holder")
+	}
+	defer os.Remove(guard)
+
+	current, err := r.read(r.path(nodeGroup))
+	if os.IsNotExist(err) {
+		return os.Link(temp, r.path(nodeGroup))
+	}
+	if err != nil {
+		return err
+	}
+
+	if current.Holder != expired.Holder || !current.ExpiresAt.Equal(expired.ExpiresAt) {
+		return fmt.Errorf("held by %v", current.Holder)
+	}
+
+	return os.Rename(temp, r.path(nodeGroup))
+}
+
+func (r *FileLocker) writeTemp(nodeGroup string, token string) (string, error) {
+	file, err := ioutil.TempFile(r.Dir, nodeGroup+".lock.")
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	if err := json.NewEncoder(file).Encode(fileLock{Holder: token, ExpiresAt: time.Now().Add(r.TTL)}); err != nil {
+		os.Remove(file.Name())
+		return "", err
+	}
+
+	return file.Name(), nil
+}
+
+func (r *FileLocker) read(path string) (*fileLock, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	current := fileLock{}
+	if err := json.Unmarshal(content, &current); err != nil {
+		return nil, err
+	}
+
+	return &current, nil
+}
+
+func (r *FileLocker) path(nodeGroup string) string {
+	return filepath.Join(r.Dir, nodeGroup+".lock")
+}
+
+//DynamoDBLocker represents locks kept as items of a table keyed by the LockKey string attribute, taken with conditional writes
+type DynamoDBLocker struct {
+	AwsSession session.Session
+	Table      string
+	Holder     string
+	TTL        time.Duration
+}
+
+//Acquire represents writing the lock item unless another acquisition has one that has not expired
+func (r *DynamoDBLocker) Acquire(nodeGroup string) (string, error) {
+	dynamoSvc := dynamodb.New(&r.AwsSession)
+
+	token := newLockToken(r.Holder)
+	now := time.Now()
+	input := dynamodb.PutItemInput{
+		TableName: aws.String(r.Table),
+		Item: map[string]*dynamodb.AttributeValue{
+			"LockKey":   {S: aws.String(nodeGroup)},
+			"Holder":    {S: aws.String(token)},
+			"ExpiresAt": {N: aws.String(strconv.FormatInt(now.Add(r.TTL).Unix(), 10))},
+		},
+		ConditionExpression: aws.String("attribute_not_exists(LockKey) OR ExpiresAt < :now"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":now": {N: aws.String(strconv.FormatInt(now.Unix(), 10))},
+		},
+	}
+
+	_, err := dynamoSvc.PutItem(&input)
+	if aErr, ok := err.(awserr.Error); ok && aErr.Code() == dynamodb.ErrCodeConditionalCheckFailedException {
+		return "", fmt.Errorf("held by another holder")
+	}
+	if err != nil {
+		return "", err
+	}
+
+	return token, nil
+}
+
+//Renew represents extending the expiry of the lock item held by the acquisition
+func (r *DynamoDBLocker) Renew(nodeGroup string, token string) error {
+	dynamoSvc := dynamodb.New(&r.AwsSession)
+
+	input := dynamodb.UpdateItemInput{
+		TableName:           aws.String(r.Table),
+		Key:                 map[string]*dynamodb.AttributeValue{"LockKey": {S: aws.String(nodeGroup)}},
+		UpdateExpression:    aws.String("SET ExpiresAt = :expires"),
+		ConditionExpression: aws.String("Holder = :holder"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":expires": {N: aws.String(strconv.FormatInt(time.Now().Add(r.TTL).Unix(), 10))},
+			":holder":  {S: aws.String(token)},
+		},
+	}
+
+	_, err := dynamoSvc.UpdateItem(&input)
+	return err
+}
+
+//Release represents deleting the lock item when held by the acquisition
+func (r *DynamoDBLocker) Release(nodeGroup string, token string) error {
+	dynamoSvc := dynamodb.New(&r.AwsSession)
+
+	input := dynamodb.DeleteItemInput{
+		TableName:           aws.String(r.Table),
+		Key:                 map[string]*dynamodb.AttributeValue{"LockKey": {S: aws.String(nodeGroup)}},
+		ConditionExpression: aws.String("Holder = :holder"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":holder": {S: aws.String(token)},
+		},
+	}
+
+	_, err := dynamoSvc.DeleteItem(&input)
+	if aErr, ok := err.(awserr.Error); ok && aErr.Code() == dynamodb.ErrCodeConditionalCheckFailedException {
+		return nil
+	}
+
+	return err
+}
+
+//KubeLeaseLocker represents locks kept as coordination.k8s.io Lease objects. Writes carry the resourceVersion that was
+//read, so the api server rejects a write racing with another holder.
+type KubeLeaseLocker struct {
+	KubeService KubeService
+	Namespace   string
+	Holder      string
+	TTL         time.Duration
+}
+
+type kubeLease struct {
+	APIVersion string                 `json:"apiVersion"`
+	Kind       string                 `json:"kind"`
+	Metadata   map[string]interface{} `json:"metadata"`
+	Spec       kubeLeaseSpec          `json:"spec"`
+}
+
+type kubeLeaseSpec struct {
+	HolderIdentity       string `json:"holderIdentity"`
+	LeaseDurationSeconds int64  `json:"leaseDurationSeconds"`
+	AcquireTime          string `json:"acquireTime,omitempty"`
+	RenewTime            string `json:"renewTime,omitempty"`
+}
+
+// kubernetes MicroTime
+const kubeMicroTime = "2006-01-02T15:04:05.000000Z07:00"
+
+//Acquire represents creating the Lease, or taking it over when it is free or has expired
+func (r *KubeLeaseLocker) Acquire(nodeGroup string) (string, error) {
+	lease, err := r.get(nodeGroup)
+	if err != nil {
+		return "", err
+	}
+
+	token := newLockToken(r.Holder)
+	now := time.Now().UTC().Format(kubeMicroTime)
+	if lease == nil {
+		lease = &kubeLease{
+			APIVersion: "coordination.k8s.io/v1",
+			Kind:       "Lease",
+			Metadata:   map[string]interface{}{"name": r.leaseName(nodeGroup), "namespace": r.Namespace},
+		}
+		lease.Spec = kubeLeaseSpec{HolderIdentity: token, LeaseDurationSeconds: int64(r.TTL.Seconds()), AcquireTime: now, RenewTime: now}
+		return token, r.apply("create", lease)
+	}
+
+	if lease.Spec.HolderIdentity != "" && !r.expired(lease) {
+		return "", fmt.Errorf("held by %v", lease.Spec.HolderIdentity)
+	}
+
+	lease.Spec = kubeLeaseSpec{HolderIdentity: token, LeaseDurationSeconds: int64(r.TTL.Seconds()), AcquireTime: now, RenewTime: now}
+	return token, r.apply("replace", lease)
+}
+
+//Renew represents
+func (r *KubeLeaseLocker) Renew(nodeGroup string, token string) error {
+	lease, err := r.get(nodeGroup)
+	if err != nil {
+		return err
+	}
+
+	if lease == nil || lease.Spec.HolderIdentity != token {
+		return fmt.Errorf("lease of node group %v is no longer held", nodeGroup)
+	}
+
+	lease.Spec.RenewTime = time.Now().UTC().Format(kubeMicroTime)
+	return r.apply("replace", lease)
+}
+
+//Release represents clearing the holder so the next holder does not wait for expiry
+func (r *KubeLeaseLocker) Release(nodeGroup string, token string) error {
+	lease, err := r.get(nodeGroup)
+	if err != nil || lease == nil || lease.Spec.HolderIdentity != token {
+		return err
+	}
+
+	lease.Spec.HolderIdentity = ""
+	return r.apply("replace", lease)
+}
+
+func (r *KubeLeaseLocker) get(nodeGroup string) (*kubeLease, error) {
+	output, err := r.KubeService.kubectl("get", "lease", r.leaseName(nodeGroup), "-n", r.Namespace, "-o", "json", "--ignore-not-found")
+	if err != nil {
+		return nil, err
+	}
+
+	if len(strings.TrimSpace(string(output))) == 0 {
+		return nil, nil
+	}
+
+	lease := kubeLease{}
+	if err := json.Unmarshal(output, &lease); err != nil {
+		return nil, err
+	}
+
+	return &lease, nil
+}
+
+func (r *KubeLeaseLocker) apply(verb string, lease *kubeLease) error {
+	content, err := json.Marshal(lease)
+	if err != nil {
+		return err
+	}
+
+	_, err = r.KubeService.kubectlWithInput(content, verb, "-f", "-")
+	return err
+}
+
+func (r *KubeLeaseLocker) expired(lease *kubeLease) bool {
+	renewed, err := time.Parse(kubeMicroTime, lease.Spec.RenewTime)
+	if err != nil {
+		return true
+	}
+
+	return time.Now().After(renewed.Add(time.Duration(lease.Spec.LeaseDurationSeconds) * time.Second))
+}
+
+var invalidLeaseNameChars = regexp.MustCompile(`[^a-z0-9-]+`)
+
+// leaseName turns the node group key into a valid object name, the namespace of the key separated by a dot since
+// namespaces have none
+func (r *KubeLeaseLocker) leaseName(nodeGroup string) string {
+	parts := strings.Split(nodeGroup, "/")
+	for i, v := range parts {
+		parts[i] = strings.Trim(invalidLeaseNameChars.ReplaceAllString(strings.ToLower(v), "-"), "-")
+	}
+
+	return "node-group-manager-" + strings.Join(parts, ".")
+}
@@ -0,0 +1,155 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	apiTypes "github.com/anyo/aws-node-group-manager/pkg/apis"
+)
+
+func TestFileLockerAcquire(t *testing.T) {
+	locker := &FileLocker{Dir: t.TempDir(), Holder: "host-1", TTL: time.Minute}
+
+	token, err := locker.Acquire("workers")
+	if err != nil {
+		t.Fatalf("acquire: %v", err)
+	}
+
+	// the same holder gets no second acquisition
+	if _, err := locker.Acquire("workers"); err == nil {
+		t.Fatal("acquired a lock that is held")
+	}
+
+	if err := locker.Renew("workers", "other"); err == nil {
+		t.Fatal("renewed the lock with another token")
+	}
+	if err := locker.Renew("workers", token); err != nil {
+		t.Fatalf("renew: %v", err)
+	}
+
+	if err := locker.Release("workers", "other"); err != nil {
+		t.Fatalf("release with another token: %v", err)
+	}
+	if _, err := locker.Acquire("workers"); err == nil {
+		t.Fatal("released the lock with another token")
+	}
+
+	if err := locker.Release("workers", token); err != nil {
+		t.Fatalf("release: %v", err)
+	}
+	if _, err := locker.Acquire("workers"); err != nil {
+		t.Fatalf("acquire after release: %v", err)
+	}
+}
+
+func TestFileLockerTakeOver(t *testing.T) {
+	dir := t.TempDir()
+	expired := &FileLocker{Dir: dir, Holder: "host-1", TTL: -time.Second}
+	token, err := expired.Acquire("workers")
+	if err != nil {
+		t.Fatalf("acquire: %v", err)
+	}
+
+	// every process sees the expired lock, only one takes it over
+	locker := &FileLocker{Dir: dir, Holder: "host-2", TTL: time.Minute}
+	var wg sync.WaitGroup
+	tokens := make(chan string, 20)
+	for i := 0; i < cap(tokens); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if token, err := locker.Acquire("workers"); err == nil {
+				tokens <- token
+			}
+		}()
+	}
+	wg.Wait()
+	close(tokens)
+
+	if len(tokens) != 1 {
+		t.Fatalf("expected one acquisition to take over the expired lock, got %v", len(tokens))
+	}
+
+	if err := expired.Renew("workers", token); err == nil {
+		t.Fatal("renewed a lock that was taken over")
+	}
+	if err := expired.Release("workers", token); err != nil {
+		t.Fatalf("release of a lock taken over: %v", err)
+	}
+	if _, err := locker.Acquire("workers"); err == nil {
+		t.Fatal("the release of the expired acquisition removed the lock taken over")
+	}
+}
+
+// lostLocker acquires and releases every lock but fails to renew
+type lostLocker struct{}
+
+func (lostLocker) Acquire(nodeGroup string) (string, error) { return "token", nil }
+
+func (lostLocker) Renew(nodeGroup string, token string) error { return errors.New("lease expired") }
+
+func (lostLocker) Release(nodeGroup string, token string) error { return nil }
+
+func TestWithNodeGroupLockCancelsWhenLost(t *testing.T) {
+	model := apiTypes.OperatorModel{}
+	model.AutoScalingGroupOptions.Name = "workers"
+	model.LockOptions.TTLSeconds = 1
+
+	svc := &ReconcilerService{Locker: lostLocker{}}
+	err := svc.WithNodeGroupLock(&model, func(ctx context.Context) error {
+		select {
+		case <-ctx.Done():
+		case <-time.After(5 * time.Second):
+			t.Error("context was not cancelled after the renewal failed")
+		}
+		return svc.lockLost()
+	})
+
+	if err == nil {
+		t.Fatal("expected an error once the lock is lost")
+	}
+	if svc.lockLost() != nil {
+		t.Fatal("the lock context outlived WithNodeGroupLock")
+	}
+}
+
+func TestWithNodeGroupLockSerializesCallers(t *testing.T) {
+	model := apiTypes.OperatorModel{}
+	model.AutoScalingGroupOptions.Name = "workers"
+	model.LockOptions.Path = t.TempDir()
+
+	var mutex sync.Mutex
+	running, overlapped := 0, false
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			svc := &ReconcilerService{}
+			err := svc.WithNodeGroupLock(&model, func(context.Context) error {
+				mutex.Lock()
+				running++
+				overlapped = overlapped || running > 1
+				mutex.Unlock()
+
+				time.Sleep(10 * time.Millisecond)
+
+				mutex.Lock()
+				running--
+				mutex.Unlock()
+				return nil
+			})
+			if err != nil {
+				t.Errorf("lock: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if overlapped {
+		t.Fatal("two callers held the lock of the node group at once")
+	}
+}
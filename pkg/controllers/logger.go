@@ -0,0 +1,105 @@
+package controllers
+
+import (
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/sirupsen/logrus"
+)
+
+// log field names shared by every service, so log lines can be queried by them
+const (
+	NodeGroupField      = "nodeGroup"
+	AsgField            = "asg"
+	LaunchTemplateField = "launchTemplate"
+	VersionField        = "version"
+	InstanceIDField     = "instanceId"
+	StepField           = "step"
+	AwsRequestIDField   = "awsRequestId"
+	AwsErrorCodeField   = "awsErrorCode"
+)
+
+//ConfigureLogger represents setting the format, json or logfmt, and level of the logger every service logs to
+func ConfigureLogger(logger *logrus.Logger, format string, level string) error {
+	switch format {
+	case "", "logfmt":
+		logger.SetFormatter(&logrus.TextFormatter{DisableColors: true, FullTimestamp: true})
+	case "json":
+		logger.SetFormatter(&logrus.JSONFormatter{})
+	default:
+		return fmt.Errorf("unknown log format %v, expected one of: logfmt, json", format)
+	}
+
+	if level == "" {
+		level = "info"
+	}
+
+	parsed, err := logrus.ParseLevel(level)
+	if err != nil {
+		return err
+	}
+	logger.SetLevel(parsed)
+
+	return nil
+}
+
+// loggerOrDefault falls back to the standard logger for services created without one
+func loggerOrDefault(entry *logrus.Entry) *logrus.Entry {
+	if entry == nil {
+		return logrus.NewEntry(logrus.StandardLogger())
+	}
+
+	return entry
+}
+
+// withAwsError adds the error, and the request id and error code of aws errors, to the log entry
+func withAwsError(entry *logrus.Entry, err error) *logrus.Entry {
+	entry = entry.WithError(err)
+	if aErr, ok := err.(awserr.Error); ok {
+		entry = entry.WithField(AwsErrorCodeField, aErr.Code())
+	}
+	if rErr, ok := err.(awserr.RequestFailure); ok {
+		entry = entry.WithField(AwsRequestIDField, rErr.RequestID())
+	}
+
+	return entry
+}
+
+//WithLogger represents setting the logger of the reconciler and every service it embeds
+func (r *ReconcilerService) WithLogger(entry *logrus.Entry) {
+	r.Log = entry
+	r.AsgService.Log = entry
+	r.Ec2Service.Log = entry
+	r.SsmService.Log = entry
+	r.KubeService.Log = entry
+	r.CloudWatchService.Log = entry
+	r.PricingService.Log = entry
+}
+
+func (r *ReconcilerService) logger() *logrus.Entry {
+	return loggerOrDefault(r.Log)
+}
+
+func (r *AsgService) logger() *logrus.Entry {
+	return loggerOrDefault(r.Log)
+}
+
+func (r *Ec2Service) logger() *logrus.Entry {
+	return loggerOrDefault(r.Log)
+}
+
+func (r *SsmService) logger() *logrus.Entry {
+	return loggerOrDefault(r.Log)
+}
+
+func (r *KubeService) logger() *logrus.Entry {
+	return loggerOrDefault(r.Log)
+}
+
+func (r *CloudWatchService) logger() *logrus.Entry {
+	return loggerOrDefault(r.Log)
+}
+
+func (r *PricingService) logger() *logrus.Entry {
+	return loggerOrDefault(r.Log)
+}
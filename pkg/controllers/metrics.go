@@ -0,0 +1,141 @@
+package controllers
+
+import (
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const metricsNamespace = "node_group_manager"
+
+var (
+	reconcileDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "reconcile_duration_seconds",
+		Help:      "Duration of node group reconciles.",
+		Buckets:   []float64{5, 15, 30, 60, 120, 300, 600, 1200, 1800, 3600, 7200},
+	}, []string{"node_group"})
+
+	reconcileTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "reconcile_total",
+		Help:      "Node group reconciles by result: succeeded, failed or skipped.",
+	}, []string{"node_group", "result"})
+
+	reconcileInProgress = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "reconcile_in_progress",
+		Help:      "Whether a reconcile of the node group is running.",
+	}, []string{"node_group"})
+
+	reconcileStartTime = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "reconcile_start_timestamp_seconds",
+		Help:      "Start of the last reconcile of the node group, a stuck reconcile keeps an old start while in progress.",
+	}, []string{"node_group"})
+
+	lastSuccessTime = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "last_success_timestamp_seconds",
+		Help:      "End of the last successful reconcile of the node group.",
+	}, []string{"node_group"})
+
+	driftTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "drift_detected_total",
+		Help:      "Fields found to differ from the config, by resource and field.",
+	}, []string{"node_group", "resource", "field"})
+
+	instancesReplacedTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "instances_replaced_total",
+		Help:      "Stale instances drained and terminated.",
+	}, []string{"node_group"})
+
+	staleInstanceCount = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "stale_instances",
+		Help:      "Instances not running the desired launch template version.",
+	}, []string{"node_group"})
+
+	rolloutProgress = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "rollout_progress_ratio",
+		Help:      "Share of the stale instances of the current rollout that have been replaced, 1 when none are left.",
+	}, []string{"node_group"})
+
+	amiAge = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "ami_age_seconds",
+		Help:      "Age of the ami the node group is configured with.",
+	}, []string{"node_group"})
+
+	awsCallsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "aws_api_calls_total",
+		Help:      "AWS api requests sent, retries included.",
+	}, []string{"service", "operation"})
+
+	awsErrorsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "aws_api_errors_total",
+		Help:      "AWS api calls failed after retries, by error code.",
+	}, []string{"service", "operation", "code"})
+)
+
+func recordReconcileStart(nodeGroup string) {
+	reconcileInProgress.WithLabelValues(nodeGroup).Set(1)
+	reconcileStartTime.WithLabelValues(nodeGroup).SetToCurrentTime()
+}
+
+func recordReconcileResult(result NodeGroupResult) {
+	outcome := "succeeded"
+	if result.Skipped {
+		outcome = "skipped"
+	} else if !result.Success {
+		outcome = "failed"
+	}
+
+	reconcileTotal.WithLabelValues(result.NodeGroup, outcome).Inc()
+	if result.Skipped {
+		return
+	}
+
+	reconcileInProgress.WithLabelValues(result.NodeGroup).Set(0)
+	reconcileDuration.WithLabelValues(result.NodeGroup).Observe(result.Duration.Seconds())
+	if result.Success {
+		lastSuccessTime.WithLabelValues(result.NodeGroup).SetToCurrentTime()
+	}
+}
+
+func recordDrift(nodeGroup string, resource string, fields []string) {
+	for _, v := range fields {
+		driftTotal.WithLabelValues(nodeGroup, resource, v).Inc()
+	}
+}
+
+// recordRolloutProgress tracks the instances replaced so far out of those stale when the rollout started
+func recordRolloutProgress(nodeGroup string, replaced int, remaining int) {
+	staleInstanceCount.WithLabelValues(nodeGroup).Set(float64(remaining))
+
+	progress := 1.0
+	if replaced+remaining > 0 {
+		progress = float64(replaced) / float64(replaced+remaining)
+	}
+	rolloutProgress.WithLabelValues(nodeGroup).Set(progress)
+}
+
+func recordAmiAge(nodeGroup string, created time.Time) {
+	amiAge.WithLabelValues(nodeGroup).Set(time.Since(created).Seconds())
+}
+
+func recordAwsError(service string, operation string, err error) {
+	code := "Unknown"
+	if aErr, ok := err.(awserr.Error); ok {
+		code = aErr.Code()
+	}
+
+	awsErrorsTotal.WithLabelValues(service, operation, code).Inc()
+}
@@ -0,0 +1,318 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	apiTypes "github.com/anyo/aws-node-group-manager/pkg/apis"
+	"github.com/anyo/aws-node-group-manager/pkg/apis/v1alpha1"
+
+	"github.com/sirupsen/logrus"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+const (
+	defaultOperatorResync = 10 * time.Minute
+	// teardownPollInterval is how often a deleted node group checks its asgs are gone
+	teardownPollInterval   = 30 * time.Second
+	dependencyPollInterval = 30 * time.Second
+	// conflictPollInterval is how often a node group whose asg is managed from another resource checks it is free
+	conflictPollInterval = 5 * time.Minute
+)
+
+// permissions of the operator, the ones of the aws calls are granted to its iam role
+//+kubebuilder:rbac:groups=nodegroupmanager.anyo.io,resources=nodegroups,verbs=get;list;watch;update;patch
+//+kubebuilder:rbac:groups=nodegroupmanager.anyo.io,resources=nodegroups/status,verbs=get;update;patch
+//+kubebuilder:rbac:groups=nodegroupmanager.anyo.io,resources=nodegroups/finalizers,verbs=update
+//+kubebuilder:rbac:groups=coordination.k8s.io,resources=leases,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups="",resources=nodes,verbs=get;list;watch;patch
+//+kubebuilder:rbac:groups="",resources=pods,verbs=get;list
+//+kubebuilder:rbac:groups="",resources=pods/eviction,verbs=create
+//+kubebuilder:rbac:groups=apps,resources=daemonsets,verbs=get;list
+//+kubebuilder:rbac:groups="",resources=events,verbs=create;patch
+
+//NodeGroupOperator represents the operator mode: NodeGroup resources reconciled by the ReconcilerService, with the
+//status written back to the resource and a finalizer tearing the node group down when the resource is deleted
+type NodeGroupOperator struct {
+	client.Client
+	Reconciler ReconcilerService
+	// K8sVersion selects the recommended ami
+	K8sVersion string
+	// Resync is how often a node group is reconciled without changes, to pick up drift and new amis
+	Resync time.Duration
+	// Concurrency is the number of node groups reconciled in parallel
+	Concurrency int
+	Log         *logrus.Entry
+}
+
+//SetupWithManager represents registering the operator with the controller manager
+func (r *NodeGroupOperator) SetupWithManager(mgr ctrl.Manager) error {
+	concurrency := r.Concurrency
+	if concurrency <= 0 {
+		concurrency = 1
+	}
+
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&v1alpha1.NodeGroup{}).
+		WithOptions(controller.Options{MaxConcurrentReconciles: concurrency}).
+		Complete(r)
+}
+
+//Reconcile represents one pass over a NodeGroup resource: teardown when it is being deleted, otherwise the full
+//reconcile of the node group once its dependencies are ready
+func (r *NodeGroupOperator) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	nodeGroup := v1alpha1.NodeGroup{}
+	if err := r.Get(ctx, req.NamespacedName, &nodeGroup); err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+
+	model := nodeGroup.Model()
+	logger := r.logger().WithFields(logrus.Fields{NodeGroupField: model.NodeGroupName(), "resource": req.NamespacedName.String()})
+
+	reconciler := r.Reconciler
+	reconciler.WithLogger(logger)
+	svc, err := reconciler.ForNodeGroup(&model)
+	if err == nil && !nodeGroup.DeletionTimestamp.IsZero() {
+		return r.teardown(ctx, svc, &nodeGroup, &model)
+	}
+
+	if errs := append(ValidateNodeGroup(&model), ValidateOperatorNodeGroup(&model)...); len(errs) > 0 {
+		logger.WithError(errs).Error("Invalid node group spec")
+		r.setCondition(&nodeGroup, v1alpha1.ConditionReady, metav1.ConditionFalse, "InvalidSpec", errs.Error())
+		// the spec has to change before another attempt, which triggers a reconcile
+		return ctrl.Result{}, r.updateStatus(ctx, &nodeGroup)
+	}
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+
+	if owner, err := r.conflictingNodeGroup(ctx, &nodeGroup, &model); err != nil || owner != "" {
+		if owner != "" {
+			logger.WithField("owner", owner).Error("Node group is managed by another resource")
+			r.setCondition(&nodeGroup, v1alpha1.ConditionReady, metav1.ConditionFalse, "Conflict", fmt.Sprintf("asg %v is managed by node group %v", svc.ResourceName(&model, model.AutoScalingGroupOptions.Name), owner))
+			err = r.updateStatus(ctx, &nodeGroup)
+		}
+		return ctrl.Result{RequeueAfter: conflictPollInterval}, err
+	}
+
+	if !controllerutil.ContainsFinalizer(&nodeGroup, v1alpha1.Finalizer) {
+		controllerutil.AddFinalizer(&nodeGroup, v1alpha1.Finalizer)
+		if err := r.Update(ctx, &nodeGroup); err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+
+	if waiting, err := r.waitingDependency(ctx, &nodeGroup, &model); err != nil || waiting != "" {
+		if waiting != "" {
+			logger.WithField("dependency", waiting).Info("Waiting for dependency to be ready")
+			r.setCondition(&nodeGroup, v1alpha1.ConditionReady, metav1.ConditionFalse, "DependencyNotReady", fmt.Sprintf("node group %v is not ready", waiting))
+			err = r.updateStatus(ctx, &nodeGroup)
+		}
+		return ctrl.Result{RequeueAfter: dependencyPollInterval}, err
+	}
+
+	logger.Info("Reconciling node group")
+	recordReconcileStart(model.NodeGroupName())
+	start := time.Now()
+	reconcileErr := svc.WithNodeGroupLock(&model, func(context.Context) error {
+		return svc.ReconcileNodeGroup(&model, r.K8sVersion)
+	})
+	recordReconcileResult(NodeGroupResult{NodeGroup: model.NodeGroupName(), Success: reconcileErr == nil, Err: reconcileErr, Duration: time.Since(start)})
+
+	if reconcileErr != nil {
+		logger.WithError(reconcileErr).Error("Failed to reconcile node group")
+		r.setCondition(&nodeGroup, v1alpha1.ConditionReady, metav1.ConditionFalse, "ReconcileFailed", reconcileErr.Error())
+		if err := r.updateStatus(ctx, &nodeGroup); err != nil {
+			return ctrl.Result{}, err
+		}
+		return ctrl.Result{}, reconcileErr
+	}
+
+	if err := r.observe(svc, &nodeGroup, &model); err != nil {
+		logger.WithError(err).Warn("Failed to get node group status")
+	}
+	r.setCondition(&nodeGroup, v1alpha1.ConditionReady, metav1.ConditionTrue, "Reconciled", "node group matches the spec")
+
+	return ctrl.Result{RequeueAfter: r.resync()}, r.updateStatus(ctx, &nodeGroup)
+}
+
+// teardown deletes the node group in aws, the finalizer is removed once nothing is left
+func (r *NodeGroupOperator) teardown(ctx context.Context, svc *ReconcilerService, nodeGroup *v1alpha1.NodeGroup, model *apiTypes.OperatorModel) (ctrl.Result, error) {
+	if !controllerutil.ContainsFinalizer(nodeGroup, v1alpha1.Finalizer) {
+		return ctrl.Result{}, nil
+	}
+
+	// the asgs belong to the resource managing them, which keeps them
+	owner, err := r.conflictingNodeGroup(ctx, nodeGroup, model)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	if owner != "" {
+		r.logger().WithField("owner", owner).Info("Node group is managed by another resource, nothing to tear down")
+		controllerutil.RemoveFinalizer(nodeGroup, v1alpha1.Finalizer)
+		return ctrl.Result{}, r.Update(ctx, nodeGroup)
+	}
+
+	done := false
+	err = svc.WithNodeGroupLock(model, func(context.Context) error {
+		var err error
+		done, err = svc.Teardown(model)
+		return err
+	})
+	if err != nil {
+		r.setCondition(nodeGroup, v1alpha1.ConditionReady, metav1.ConditionFalse, "TeardownFailed", err.Error())
+		if updateErr := r.updateStatus(ctx, nodeGroup); updateErr != nil {
+			return ctrl.Result{}, updateErr
+		}
+		return ctrl.Result{}, err
+	}
+
+	if !done {
+		r.setCondition(nodeGroup, v1alpha1.ConditionReady, metav1.ConditionFalse, "TearingDown", "waiting for the asgs to be deleted")
+		return ctrl.Result{RequeueAfter: teardownPollInterval}, r.updateStatus(ctx, nodeGroup)
+	}
+
+	controllerutil.RemoveFinalizer(nodeGroup, v1alpha1.Finalizer)
+	return ctrl.Result{}, r.Update(ctx, nodeGroup)
+}
+
+// waitingDependency returns the first dependency in the namespace that is not ready with its current spec
+func (r *NodeGroupOperator) waitingDependency(ctx context.Context, nodeGroup *v1alpha1.NodeGroup, model *apiTypes.OperatorModel) (string, error) {
+	if len(model.DependsOn) == 0 {
+		return "", nil
+	}
+
+	list := v1alpha1.NodeGroupList{}
+	if err := r.List(ctx, &list, client.InNamespace(nodeGroup.Namespace)); err != nil {
+		return "", err
+	}
+
+	for _, name := range model.DependsOn {
+		ready := false
+		for i := range list.Items {
+			dependency := &list.Items[i]
+			dependencyModel := dependency.Model()
+			if dependencyModel.NodeGroupName() != name {
+				continue
+			}
+			ready = dependency.Status.ObservedGeneration == dependency.Generation &&
+				meta.IsStatusConditionTrue(dependency.Status.Conditions, v1alpha1.ConditionReady)
+		}
+		if !ready {
+			return name, nil
+		}
+	}
+
+	return "", nil
+}
+
+// conflictingNodeGroup returns the older resource, in any namespace the operator watches, managing the asg or launch template the node
+// group would, empty when the node group is the one managing them. Only the oldest resource reconciles or tears them
+// down, the names in aws do not carry the namespace.
+func (r *NodeGroupOperator) conflictingNodeGroup(ctx context.Context, nodeGroup *v1alpha1.NodeGroup, model *apiTypes.OperatorModel) (string, error) {
+	list := v1alpha1.NodeGroupList{}
+	if err := r.List(ctx, &list); err != nil {
+		return "", err
+	}
+
+	asgName := r.Reconciler.ResourceName(model, model.AutoScalingGroupOptions.Name)
+	launchTemplateName := r.Reconciler.ResourceName(model, model.LaunchTemplateOptions.Name)
+	for i := range list.Items {
+		other := &list.Items[i]
+		if other.Namespace == nodeGroup.Namespace && other.Name == nodeGroup.Name {
+			continue
+		}
+
+		otherModel := other.Model()
+		sameAsg := r.Reconciler.ResourceName(&otherModel, otherModel.AutoScalingGroupOptions.Name) == asgName
+		sameLaunchTemplate := otherModel.LaunchTemplateOptions.Name != "" &&
+			r.Reconciler.ResourceName(&otherModel, otherModel.LaunchTemplateOptions.Name) == launchTemplateName
+		if !sameAsg && !sameLaunchTemplate {
+			continue
+		}
+		if olderNodeGroup(other, nodeGroup) {
+			return other.Namespace + "/" + other.Name, nil
+		}
+	}
+
+	return "", nil
+}
+
+// olderNodeGroup reports whether a was created before b, resources created in the same second are ordered by name
+func olderNodeGroup(a *v1alpha1.NodeGroup, b *v1alpha1.NodeGroup) bool {
+	if !a.CreationTimestamp.Equal(&b.CreationTimestamp) {
+		return a.CreationTimestamp.Before(&b.CreationTimestamp)
+	}
+	if a.Namespace != b.Namespace {
+		return a.Namespace < b.Namespace
+	}
+
+	return a.Name < b.Name
+}
+
+// observe copies what the node group runs in aws into the status of the resource
+func (r *NodeGroupOperator) observe(svc *ReconcilerService, nodeGroup *v1alpha1.NodeGroup, model *apiTypes.OperatorModel) error {
+	status, err := svc.NodeGroupStatus(model, r.K8sVersion)
+	if err != nil {
+		return err
+	}
+
+	now := metav1.Now()
+	nodeGroup.Status.LastReconciled = &now
+	nodeGroup.Status.PendingAmi = status.PendingAmi
+	nodeGroup.Status.Instances = len(status.Instances)
+	nodeGroup.Status.StaleInstances = status.StaleInstances
+	if status.Actual != nil {
+		nodeGroup.Status.AsgName = status.Actual.AsgName
+		nodeGroup.Status.LaunchTemplate = status.Actual.LaunchTemplate
+		nodeGroup.Status.LaunchTemplateVersion = status.Actual.TargetVersion
+		nodeGroup.Status.AmiID = status.Actual.AmiID
+	}
+
+	if status.StaleInstances > 0 || status.Rollout != nil {
+		r.setCondition(nodeGroup, v1alpha1.ConditionProgressing, metav1.ConditionTrue, "RollingOut", fmt.Sprintf("%v instances run a stale launch template version", status.StaleInstances))
+	} else {
+		r.setCondition(nodeGroup, v1alpha1.ConditionProgressing, metav1.ConditionFalse, "UpToDate", "every instance runs the target launch template version")
+	}
+
+	return nil
+}
+
+func (r *NodeGroupOperator) setCondition(nodeGroup *v1alpha1.NodeGroup, conditionType string, status metav1.ConditionStatus, reason string, message string) {
+	meta.SetStatusCondition(&nodeGroup.Status.Conditions, metav1.Condition{
+		Type:               conditionType,
+		Status:             status,
+		Reason:             reason,
+		Message:            message,
+		ObservedGeneration: nodeGroup.Generation,
+	})
+}
+
+func (r *NodeGroupOperator) updateStatus(ctx context.Context, nodeGroup *v1alpha1.NodeGroup) error {
+	nodeGroup.Status.ObservedGeneration = nodeGroup.Generation
+	err := r.Status().Update(ctx, nodeGroup)
+	if apierrors.IsNotFound(err) {
+		return nil
+	}
+
+	return err
+}
+
+func (r *NodeGroupOperator) resync() time.Duration {
+	if r.Resync > 0 {
+		return r.Resync
+	}
+
+	return defaultOperatorResync
+}
+
+func (r *NodeGroupOperator) logger() *logrus.Entry {
+	return loggerOrDefault(r.Log)
+}
@@ -0,0 +1,251 @@
+package controllers
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	apiTypes "github.com/anyo/aws-node-group-manager/pkg/apis"
+	"github.com/anyo/aws-node-group-manager/pkg/apis/v1alpha1"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+// testCreationTime is when the test resources were created, the oldest resource manages a shared asg
+var testCreationTime = time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+
+// testNodeGroupResource is a NodeGroup resource with a valid spec for the operator
+func testNodeGroupResource(namespace string, name string) *v1alpha1.NodeGroup {
+	model := apiTypes.OperatorModel{}
+	model.AutoScalingGroupOptions = apiTypes.AutoScalingGroupOptions{MinInstances: 1, DesiredInstances: 2, MaxInstances: 3}
+	model.LaunchTemplateOptions = apiTypes.LaunchTemplateOptions{InstanceType: "m5.large", EbsVolume: apiTypes.EbsVolume{VolumeType: "gp3", VolumeSize: 50}}
+	model.StateOptions = apiTypes.StateOptions{Backend: "dynamodb", Table: "node-group-manager-state"}
+
+	return &v1alpha1.NodeGroup{
+		ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name, Generation: 1, CreationTimestamp: metav1.NewTime(testCreationTime)},
+		Spec:       v1alpha1.NodeGroupSpec{OperatorModel: model},
+	}
+}
+
+func TestNodeGroupOperatorReconcile(t *testing.T) {
+	tests := []struct {
+		name      string
+		resources func() []*v1alpha1.NodeGroup
+		// reason is the reason of the Ready condition, empty when the resource is left alone
+		reason    string
+		message   string
+		finalizer bool
+		requeue   bool
+	}{
+		{
+			name:      "missing resource",
+			resources: func() []*v1alpha1.NodeGroup { return nil },
+		},
+		{
+			name: "invalid spec",
+			resources: func() []*v1alpha1.NodeGroup {
+				nodeGroup := testNodeGroupResource("team-a", "workers")
+				nodeGroup.Spec.MaxInstances = 0
+				return []*v1alpha1.NodeGroup{nodeGroup}
+			},
+			reason:  "InvalidSpec",
+			message: "asg.max must be above zero",
+		},
+		{
+			name: "file state backend",
+			resources: func() []*v1alpha1.NodeGroup {
+				nodeGroup := testNodeGroupResource("team-a", "workers")
+				nodeGroup.Spec.StateOptions = apiTypes.StateOptions{}
+				return []*v1alpha1.NodeGroup{nodeGroup}
+			},
+			reason:  "InvalidSpec",
+			message: "the operator keeps rollout state in s3 or dynamodb",
+		},
+		{
+			name: "file lock backend",
+			resources: func() []*v1alpha1.NodeGroup {
+				nodeGroup := testNodeGroupResource("team-a", "workers")
+				nodeGroup.Spec.LockOptions.Backend = "file"
+				return []*v1alpha1.NodeGroup{nodeGroup}
+			},
+			reason:  "InvalidSpec",
+			message: "the operator locks node groups with kubernetes or dynamodb",
+		},
+		{
+			name: "dependency not ready",
+			resources: func() []*v1alpha1.NodeGroup {
+				nodeGroup := testNodeGroupResource("team-a", "workers")
+				nodeGroup.Spec.DependsOn = []string{"system"}
+				return []*v1alpha1.NodeGroup{nodeGroup, testNodeGroupResource("team-a", "system")}
+			},
+			reason:    "DependencyNotReady",
+			message:   "node group system is not ready",
+			finalizer: true,
+			requeue:   true,
+		},
+		{
+			name: "dependency of another namespace",
+			resources: func() []*v1alpha1.NodeGroup {
+				nodeGroup := testNodeGroupResource("team-a", "workers")
+				nodeGroup.Spec.DependsOn = []string{"system"}
+				dependency := testNodeGroupResource("team-b", "system")
+				dependency.Status.ObservedGeneration = dependency.Generation
+				dependency.Status.Conditions = []metav1.Condition{{Type: v1alpha1.ConditionReady, Status: metav1.ConditionTrue, Reason: "Reconciled"}}
+				return []*v1alpha1.NodeGroup{nodeGroup, dependency}
+			},
+			reason:    "DependencyNotReady",
+			message:   "node group system is not ready",
+			finalizer: true,
+			requeue:   true,
+		},
+		{
+			name: "asg managed from another namespace",
+			resources: func() []*v1alpha1.NodeGroup {
+				owner := testNodeGroupResource("team-b", "workers")
+				owner.CreationTimestamp = metav1.NewTime(testCreationTime.Add(-time.Hour))
+				return []*v1alpha1.NodeGroup{testNodeGroupResource("team-a", "workers"), owner}
+			},
+			reason:  "Conflict",
+			message: "asg OperatorGenerated-workers is managed by node group team-b/workers",
+			requeue: true,
+		},
+		{
+			name: "launch template managed from another namespace",
+			resources: func() []*v1alpha1.NodeGroup {
+				nodeGroup := testNodeGroupResource("team-a", "workers")
+				nodeGroup.Spec.LaunchTemplateOptions.Name = "shared"
+				owner := testNodeGroupResource("team-b", "batch")
+				owner.Spec.LaunchTemplateOptions.Name = "shared"
+				owner.CreationTimestamp = metav1.NewTime(testCreationTime.Add(-time.Hour))
+				return []*v1alpha1.NodeGroup{nodeGroup, owner}
+			},
+			reason:  "Conflict",
+			message: "managed by node group team-b/batch",
+			requeue: true,
+		},
+		{
+			name: "asg of a newer resource in another namespace",
+			resources: func() []*v1alpha1.NodeGroup {
+				nodeGroup := testNodeGroupResource("team-a", "workers")
+				nodeGroup.CreationTimestamp = metav1.NewTime(testCreationTime.Add(-time.Hour))
+				nodeGroup.Spec.DependsOn = []string{"system"}
+				return []*v1alpha1.NodeGroup{nodeGroup, testNodeGroupResource("team-b", "workers")}
+			},
+			reason:    "DependencyNotReady",
+			message:   "node group system is not ready",
+			finalizer: true,
+			requeue:   true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			scheme := runtime.NewScheme()
+			if err := v1alpha1.AddToScheme(scheme); err != nil {
+				t.Fatalf("scheme: %v", err)
+			}
+			builder := fake.NewClientBuilder().WithScheme(scheme).WithStatusSubresource(&v1alpha1.NodeGroup{})
+			for _, v := range tt.resources() {
+				builder = builder.WithObjects(v)
+			}
+			operator := &NodeGroupOperator{Client: builder.Build()}
+
+			key := types.NamespacedName{Namespace: "team-a", Name: "workers"}
+			result, err := operator.Reconcile(context.Background(), ctrl.Request{NamespacedName: key})
+			if err != nil {
+				t.Fatalf("reconcile: %v", err)
+			}
+			if requeued := result.RequeueAfter > 0; requeued != tt.requeue {
+				t.Fatalf("requeue after %v, expected a requeue: %v", result.RequeueAfter, tt.requeue)
+			}
+			if tt.reason == "" {
+				return
+			}
+
+			nodeGroup := v1alpha1.NodeGroup{}
+			if err := operator.Get(context.Background(), key, &nodeGroup); err != nil {
+				t.Fatalf("get: %v", err)
+			}
+			if controllerutil.ContainsFinalizer(&nodeGroup, v1alpha1.Finalizer) != tt.finalizer {
+				t.Fatalf("finalizers %v, expected the finalizer: %v", nodeGroup.Finalizers, tt.finalizer)
+			}
+
+			ready := meta.FindStatusCondition(nodeGroup.Status.Conditions, v1alpha1.ConditionReady)
+			switch {
+			case ready == nil:
+				t.Fatalf("no ready condition in %+v", nodeGroup.Status)
+			case ready.Status != metav1.ConditionFalse || ready.Reason != tt.reason:
+				t.Fatalf("ready condition %v %v, expected False %v", ready.Status, ready.Reason, tt.reason)
+			case !strings.Contains(ready.Message, tt.message):
+				t.Fatalf("ready message %q, expected it to contain %q", ready.Message, tt.message)
+			case nodeGroup.Status.ObservedGeneration != nodeGroup.Generation:
+				t.Fatalf("observed generation %v of generation %v", nodeGroup.Status.ObservedGeneration, nodeGroup.Generation)
+			}
+		})
+	}
+}
+
+func TestNodeGroupOperatorTeardownConflict(t *testing.T) {
+	nodeGroup := testNodeGroupResource("team-a", "workers")
+	now := metav1.Now()
+	nodeGroup.DeletionTimestamp = &now
+	nodeGroup.Finalizers = []string{v1alpha1.Finalizer}
+	owner := testNodeGroupResource("team-b", "workers")
+	owner.CreationTimestamp = metav1.NewTime(testCreationTime.Add(-time.Hour))
+
+	scheme := runtime.NewScheme()
+	if err := v1alpha1.AddToScheme(scheme); err != nil {
+		t.Fatalf("scheme: %v", err)
+	}
+	operator := &NodeGroupOperator{Client: fake.NewClientBuilder().WithScheme(scheme).WithObjects(nodeGroup, owner).Build()}
+
+	// tearing down would delete the asg of team-b, the finalizer is removed without touching aws
+	key := types.NamespacedName{Namespace: "team-a", Name: "workers"}
+	if _, err := operator.Reconcile(context.Background(), ctrl.Request{NamespacedName: key}); err != nil {
+		t.Fatalf("reconcile: %v", err)
+	}
+	if err := operator.Get(context.Background(), key, &v1alpha1.NodeGroup{}); !apierrors.IsNotFound(err) {
+		t.Fatalf("get: %v, expected the resource to be gone", err)
+	}
+}
+
+func TestNodeGroupResourceKeys(t *testing.T) {
+	older, newer := testNodeGroupResource("team-a", "workers"), testNodeGroupResource("team-b", "workers")
+	older.CreationTimestamp = metav1.NewTime(testCreationTime.Add(-time.Hour))
+	first, second := older.Model(), newer.Model()
+
+	scheme := runtime.NewScheme()
+	if err := v1alpha1.AddToScheme(scheme); err != nil {
+		t.Fatalf("scheme: %v", err)
+	}
+	operator := &NodeGroupOperator{Client: fake.NewClientBuilder().WithScheme(scheme).WithObjects(older, newer).Build()}
+	if owner, err := operator.conflictingNodeGroup(context.Background(), newer, &second); err != nil || owner != "team-a/workers" {
+		t.Fatalf("owner %q (%v) of the asg of the newer resource, expected team-a/workers", owner, err)
+	}
+	if owner, err := operator.conflictingNodeGroup(context.Background(), older, &first); err != nil || owner != "" {
+		t.Fatalf("owner %q (%v) of the asg of the older resource, expected none", owner, err)
+	}
+
+	if first.NodeGroupKey() == second.NodeGroupKey() {
+		t.Fatalf("resources of different namespaces share the key %v", first.NodeGroupKey())
+	}
+	if first.LockOptions.Backend != "kubernetes" {
+		t.Fatalf("lock backend %q, expected kubernetes by default", first.LockOptions.Backend)
+	}
+
+	locker := KubeLeaseLocker{}
+	if a, b := locker.leaseName(first.NodeGroupKey()), locker.leaseName(second.NodeGroupKey()); a == b {
+		t.Fatalf("resources of different namespaces share the lease %v", a)
+	}
+	if name := locker.leaseName("team-a/Workers_1"); name != "node-group-manager-team-a.workers-1" {
+		t.Fatalf("lease name %v", name)
+	}
+}
@@ -0,0 +1,265 @@
+package controllers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"text/template"
+	"time"
+
+	apiTypes "github.com/anyo/aws-node-group-manager/pkg/apis"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/sns"
+	"github.com/sirupsen/logrus"
+)
+
+const notifierTimeout = 10 * time.Second
+
+// defaultNotificationTemplate is the message of slack and sns notifiers without a template
+const defaultNotificationTemplate = `[{{.NodeGroup}}] {{.Message}}` +
+	`{{if .Version}} (version {{.Version}}){{end}}` +
+	`{{if .Error}}: {{.Error}}{{end}}`
+
+//Notifier represents a destination of rollout events
+type Notifier interface {
+	Notify(event apiTypes.NotificationEvent) error
+}
+
+//Notifications represents the notifiers of a node group and the events each is subscribed to. Nil sends nothing.
+type Notifications struct {
+	NodeGroup string
+
+	notifiers []subscribedNotifier
+}
+
+type subscribedNotifier struct {
+	notifier Notifier
+	events   map[string]bool
+}
+
+//NewNotifications represents creating the configured notifiers, failing on an unknown type or an invalid template
+func NewNotifications(options apiTypes.NotificationOptions, awsSession session.Session, nodeGroup string) (*Notifications, error) {
+	notifications := &Notifications{NodeGroup: nodeGroup}
+
+	for i, v := range options.Notifiers {
+		notifier, err := newNotifier(v, awsSession)
+		if err != nil {
+			return nil, fmt.Errorf("notifier %v: %v", i, err)
+		}
+
+		events := make(map[string]bool)
+		for _, e := range v.Events {
+			switch e {
+			case apiTypes.RolloutStarted, apiTypes.BatchCompleted, apiTypes.RolloutFailed, apiTypes.RolledBack, apiTypes.AmiAvailable:
+				events[e] = true
+			default:
+				return nil, fmt.Errorf("notifier %v: unknown event %v", i, e)
+			}
+		}
+
+		notifications.notifiers = append(notifications.notifiers, subscribedNotifier{notifier: notifier, events: events})
+	}
+
+	return notifications, nil
+}
+
+func newNotifier(options apiTypes.NotifierOptions, awsSession session.Session) (Notifier, error) {
+	var tmpl *template.Template
+	if options.Template != "" {
+		var err error
+		tmpl, err = template.New("notification").Parse(options.Template)
+		if err != nil {
+			return nil, fmt.Errorf("invalid template: %v", err)
+		}
+	}
+
+	switch options.Type {
+	case "webhook":
+		if options.URL == "" {
+			return nil, fmt.Errorf("the webhook notifier requires a url")
+		}
+		return &WebhookNotifier{URL: options.URL, Headers: options.Headers, Template: tmpl}, nil
+	case "slack":
+		if options.URL == "" {
+			return nil, fmt.Errorf("the slack notifier requires a url")
+		}
+		return &SlackNotifier{URL: options.URL, Template: defaultTemplate(tmpl)}, nil
+	case "sns":
+		if options.TopicArn == "" {
+			return nil, fmt.Errorf("the sns notifier requires a topicArn")
+		}
+		return &SnsNotifier{AwsSession: awsSession, TopicArn: options.TopicArn, Template: defaultTemplate(tmpl)}, nil
+	}
+
+	return nil, fmt.Errorf("unknown notifier type %v, expected one of: webhook, slack, sns", options.Type)
+}
+
+func defaultTemplate(tmpl *template.Template) *template.Template {
+	if tmpl != nil {
+		return tmpl
+	}
+
+	return template.Must(template.New("notification").Parse(defaultNotificationTemplate))
+}
+
+//Send represents sending the event to the notifiers subscribed to it. Failures are logged, a notification never
+//fails a rollout.
+func (r *Notifications) Send(event apiTypes.NotificationEvent) {
+	if r == nil {
+		return
+	}
+
+	if event.Time.IsZero() {
+		event.Time = time.Now().UTC()
+	}
+	if event.NodeGroup == "" {
+		event.NodeGroup = r.NodeGroup
+	}
+
+	for _, v := range r.notifiers {
+		if len(v.events) > 0 && !v.events[event.Type] {
+			continue
+		}
+
+		if err := v.notifier.Notify(event); err != nil {
+			withAwsError(logrus.WithFields(logrus.Fields{NodeGroupField: event.NodeGroup, "event": event.Type}), err).Error("Failed to send notification")
+		}
+	}
+}
+
+//UseNodeGroupNotifications represents sending the rollout events of the node group to its configured notifiers
+func (r *ReconcilerService) UseNodeGroupNotifications(model *apiTypes.OperatorModel) error {
+	notifications, err := NewNotifications(model.NotificationOptions, r.Ec2Service.AwsSession, model.NodeGroupName())
+	if err != nil {
+		return err
+	}
+
+	r.Notifications = notifications
+	return nil
+}
+
+//WebhookNotifier represents posting events to a url, as json or as the rendered template
+type WebhookNotifier struct {
+	URL      string
+	Headers  map[string]string
+	Template *template.Template
+	// Client defaults to a client with a short timeout
+	Client *http.Client
+}
+
+//Notify represents
+func (r *WebhookNotifier) Notify(event apiTypes.NotificationEvent) error {
+	contentType := "application/json"
+
+	var body []byte
+	var err error
+	if r.Template != nil {
+		body, err = renderNotification(r.Template, event)
+		contentType = "text/plain"
+	} else {
+		body, err = json.Marshal(event)
+	}
+	if err != nil {
+		return err
+	}
+
+	return postNotification(r.Client, r.URL, contentType, r.Headers, body)
+}
+
+//SlackNotifier represents posting events to a slack compatible incoming webhook
+type SlackNotifier struct {
+	URL      string
+	Template *template.Template
+	Client   *http.Client
+}
+
+//Notify represents
+func (r *SlackNotifier) Notify(event apiTypes.NotificationEvent) error {
+	text, err := renderNotification(r.Template, event)
+	if err != nil {
+		return err
+	}
+
+	body, err := json.Marshal(map[string]string{"text": string(text)})
+	if err != nil {
+		return err
+	}
+
+	return postNotification(r.Client, r.URL, "application/json", nil, body)
+}
+
+//SnsNotifier represents publishing events to an sns topic, the event type is sent as a message attribute to filter on
+type SnsNotifier struct {
+	AwsSession session.Session
+	TopicArn   string
+	Template   *template.Template
+}
+
+//Notify represents
+func (r *SnsNotifier) Notify(event apiTypes.NotificationEvent) error {
+	snsSvc := sns.New(&r.AwsSession)
+
+	message, err := renderNotification(r.Template, event)
+	if err != nil {
+		return err
+	}
+
+	// subjects are limited to 100 characters
+	subject := fmt.Sprintf("%v: %v", event.NodeGroup, event.Type)
+	if len(subject) > 100 {
+		subject = subject[:100]
+	}
+
+	input := sns.PublishInput{
+		TopicArn: aws.String(r.TopicArn),
+		Subject:  aws.String(subject),
+		Message:  aws.String(string(message)),
+		MessageAttributes: map[string]*sns.MessageAttributeValue{
+			"event": {DataType: aws.String("String"), StringValue: aws.String(event.Type)},
+		},
+	}
+
+	_, err = snsSvc.Publish(&input)
+	return err
+}
+
+func renderNotification(tmpl *template.Template, event apiTypes.NotificationEvent) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, event); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+func postNotification(client *http.Client, url string, contentType string, headers map[string]string, body []byte) error {
+	if client == nil {
+		client = &http.Client{Timeout: notifierTimeout}
+	}
+
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", contentType)
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		content, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("notification to %v failed with status %v: %v", url, resp.StatusCode, string(content))
+	}
+
+	return nil
+}
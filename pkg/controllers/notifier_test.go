@@ -0,0 +1,219 @@
+package controllers
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"text/template"
+	"time"
+
+	apiTypes "github.com/anyo/aws-node-group-manager/pkg/apis"
+
+	"github.com/aws/aws-sdk-go/aws/session"
+)
+
+// notificationRequest is a request received by the test server
+type notificationRequest struct {
+	header http.Header
+	body   []byte
+}
+
+// notificationServer records the requests it receives and answers them with status
+func notificationServer(t *testing.T, status int) (*httptest.Server, func() []notificationRequest) {
+	var mutex sync.Mutex
+	requests := []notificationRequest{}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		body, _ := ioutil.ReadAll(req.Body)
+		mutex.Lock()
+		requests = append(requests, notificationRequest{header: req.Header, body: body})
+		mutex.Unlock()
+
+		w.WriteHeader(status)
+		w.Write([]byte("denied"))
+	}))
+	t.Cleanup(server.Close)
+
+	return server, func() []notificationRequest {
+		mutex.Lock()
+		defer mutex.Unlock()
+		return append([]notificationRequest{}, requests...)
+	}
+}
+
+func testNotificationEvent() apiTypes.NotificationEvent {
+	return apiTypes.NotificationEvent{
+		Type:      apiTypes.RolloutFailed,
+		Time:      time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC),
+		NodeGroup: "workers",
+		Message:   "Rollout failed",
+		Version:   "3",
+		Error:     "health gate asg failed",
+	}
+}
+
+func TestWebhookNotifier(t *testing.T) {
+	server, requests := notificationServer(t, http.StatusOK)
+	notifier := WebhookNotifier{URL: server.URL, Headers: map[string]string{"Authorization": "Bearer abc", "X-Source": "manager"}}
+
+	if err := notifier.Notify(testNotificationEvent()); err != nil {
+		t.Fatalf("notify: %v", err)
+	}
+
+	received := requests()
+	if len(received) != 1 {
+		t.Fatalf("expected one request, got %v", len(received))
+	}
+	if v := received[0].header.Get("Content-Type"); v != "application/json" {
+		t.Fatalf("content type %q", v)
+	}
+	if v := received[0].header.Get("Authorization"); v != "Bearer abc" {
+		t.Fatalf("authorization header %q", v)
+	}
+	if v := received[0].header.Get("X-Source"); v != "manager" {
+		t.Fatalf("custom header %q", v)
+	}
+
+	event := apiTypes.NotificationEvent{}
+	if err := json.Unmarshal(received[0].body, &event); err != nil {
+		t.Fatalf("body is not an event: %v", err)
+	}
+	expected := testNotificationEvent()
+	if !event.Time.Equal(expected.Time) {
+		t.Fatalf("received time %v, expected %v", event.Time, expected.Time)
+	}
+	event.Time = expected.Time
+	if event != expected {
+		t.Fatalf("received %+v, expected %+v", event, expected)
+	}
+}
+
+func TestWebhookNotifierTemplate(t *testing.T) {
+	server, requests := notificationServer(t, http.StatusNoContent)
+	tmpl := template.Must(template.New("notification").Parse(`{{.Type}} {{.NodeGroup}}: {{.Error}}`))
+	notifier := WebhookNotifier{URL: server.URL, Template: tmpl}
+
+	if err := notifier.Notify(testNotificationEvent()); err != nil {
+		t.Fatalf("notify: %v", err)
+	}
+
+	received := requests()
+	if v := received[0].header.Get("Content-Type"); v != "text/plain" {
+		t.Fatalf("content type %q", v)
+	}
+	if body := string(received[0].body); body != "rolloutFailed workers: health gate asg failed" {
+		t.Fatalf("body %q", body)
+	}
+}
+
+func TestSlackNotifier(t *testing.T) {
+	server, requests := notificationServer(t, http.StatusOK)
+	notifier := SlackNotifier{URL: server.URL, Template: defaultTemplate(nil)}
+
+	if err := notifier.Notify(testNotificationEvent()); err != nil {
+		t.Fatalf("notify: %v", err)
+	}
+
+	received := requests()
+	if v := received[0].header.Get("Content-Type"); v != "application/json" {
+		t.Fatalf("content type %q", v)
+	}
+
+	payload := map[string]interface{}{}
+	if err := json.Unmarshal(received[0].body, &payload); err != nil {
+		t.Fatalf("body is not json: %v", err)
+	}
+	expected := map[string]interface{}{"text": "[workers] Rollout failed (version 3): health gate asg failed"}
+	if len(payload) != 1 || payload["text"] != expected["text"] {
+		t.Fatalf("payload %v, expected %v", payload, expected)
+	}
+}
+
+func TestNotifierStatus(t *testing.T) {
+	tests := []struct {
+		name   string
+		status int
+		err    bool
+	}{
+		{name: "ok", status: http.StatusOK},
+		{name: "accepted", status: http.StatusAccepted},
+		{name: "multiple choices", status: http.StatusMultipleChoices, err: true},
+		{name: "client error", status: http.StatusForbidden, err: true},
+		{name: "server error", status: http.StatusBadGateway, err: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, _ := notificationServer(t, tt.status)
+			notifiers := []Notifier{
+				&WebhookNotifier{URL: server.URL},
+				&SlackNotifier{URL: server.URL, Template: defaultTemplate(nil)},
+			}
+
+			for _, v := range notifiers {
+				err := v.Notify(testNotificationEvent())
+				if tt.err && (err == nil || !strings.Contains(err.Error(), "denied")) {
+					t.Fatalf("%T: expected an error with the response body, got %v", v, err)
+				}
+				if !tt.err && err != nil {
+					t.Fatalf("%T: unexpected error: %v", v, err)
+				}
+			}
+		})
+	}
+}
+
+func TestNotificationsSend(t *testing.T) {
+	server, requests := notificationServer(t, http.StatusOK)
+	options := apiTypes.NotificationOptions{Notifiers: []apiTypes.NotifierOptions{
+		{Type: "webhook", URL: server.URL, Events: []string{apiTypes.RolloutFailed}},
+	}}
+
+	notifications, err := NewNotifications(options, session.Session{}, "workers")
+	if err != nil {
+		t.Fatalf("new notifications: %v", err)
+	}
+
+	notifications.Send(apiTypes.NotificationEvent{Type: apiTypes.RolloutStarted, Message: "Rolling out"})
+	notifications.Send(apiTypes.NotificationEvent{Type: apiTypes.RolloutFailed, Message: "Rollout failed"})
+
+	received := requests()
+	if len(received) != 1 {
+		t.Fatalf("expected only the subscribed event, got %v requests", len(received))
+	}
+
+	event := apiTypes.NotificationEvent{}
+	if err := json.Unmarshal(received[0].body, &event); err != nil {
+		t.Fatalf("body is not an event: %v", err)
+	}
+	if event.Type != apiTypes.RolloutFailed || event.NodeGroup != "workers" || event.Time.IsZero() {
+		t.Fatalf("received %+v", event)
+	}
+}
+
+func TestNewNotificationsInvalid(t *testing.T) {
+	tests := []struct {
+		name     string
+		notifier apiTypes.NotifierOptions
+	}{
+		{name: "unknown type", notifier: apiTypes.NotifierOptions{Type: "email"}},
+		{name: "webhook without url", notifier: apiTypes.NotifierOptions{Type: "webhook"}},
+		{name: "slack without url", notifier: apiTypes.NotifierOptions{Type: "slack"}},
+		{name: "sns without topic", notifier: apiTypes.NotifierOptions{Type: "sns"}},
+		{name: "unknown event", notifier: apiTypes.NotifierOptions{Type: "webhook", URL: "http://localhost", Events: []string{"deployed"}}},
+		{name: "invalid template", notifier: apiTypes.NotifierOptions{Type: "slack", URL: "http://localhost", Template: "{{.Message"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			options := apiTypes.NotificationOptions{Notifiers: []apiTypes.NotifierOptions{tt.notifier}}
+			if _, err := NewNotifications(options, session.Session{}, "workers"); err == nil {
+				t.Fatal("expected an error")
+			}
+		})
+	}
+}
@@ -0,0 +1,96 @@
+package controllers
+
+import (
+	"fmt"
+	"strconv"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/endpoints"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/pricing"
+	"github.com/sirupsen/logrus"
+)
+
+//PricingService represents aws price list operations
+type PricingService struct {
+	AwsSession session.Session
+	Region     string
+	// Prices caches the price of each instance type, the reconciler is copied per node group so it is shared by pointer.
+	// Prices are looked up every time without it.
+	Prices *sync.Map
+	Log    *logrus.Entry
+}
+
+//GetOnDemandHourlyPrice represents getting the linux on demand price per hour in USD of an instance type in this region
+func (r *PricingService) GetOnDemandHourlyPrice(instanceType string) (float64, error) {
+	if r.Prices != nil {
+		if price, ok := r.Prices.Load(instanceType); ok {
+			return price.(float64), nil
+		}
+	}
+
+	// the price list api is only served from us-east-1 and filters by the region's display name
+	pricingSvc := pricing.New(&r.AwsSession, aws.NewConfig().WithRegion(endpoints.UsEast1RegionID))
+	region, ok := endpoints.AwsPartition().Regions()[r.Region]
+	if !ok {
+		return 0, fmt.Errorf("unknown region %v", r.Region)
+	}
+
+	filters := map[string]string{
+		"instanceType":    instanceType,
+		"location":        region.Description(),
+		"operatingSystem": "Linux",
+		"tenancy":         "Shared",
+		"preInstalledSw":  "NA",
+		"capacitystatus":  "Used",
+	}
+
+	input := pricing.GetProductsInput{
+		ServiceCode: aws.String("AmazonEC2"),
+		MaxResults:  aws.Int64(1),
+	}
+	for k, v := range filters {
+		input.Filters = append(input.Filters, &pricing.Filter{Type: aws.String("TERM_MATCH"), Field: aws.String(k), Value: aws.String(v)})
+	}
+
+	output, err := pricingSvc.GetProducts(&input)
+	if err != nil {
+		withAwsError(r.logger(), err).WithField("instanceType", instanceType).Error("Error while getting price of instance type")
+		return 0, err
+	}
+
+	if len(output.PriceList) == 0 {
+		return 0, fmt.Errorf("no on demand price for %v in %v", instanceType, r.Region)
+	}
+
+	price, err := onDemandPrice(output.PriceList[0])
+	if err != nil {
+		return 0, err
+	}
+
+	if r.Prices != nil {
+		r.Prices.Store(instanceType, price)
+	}
+
+	return price, nil
+}
+
+// onDemandPrice reads terms.OnDemand.<offer>.priceDimensions.<dimension>.pricePerUnit.USD from a price list entry
+func onDemandPrice(product aws.JSONValue) (float64, error) {
+	terms, _ := product["terms"].(map[string]interface{})
+	onDemand, _ := terms["OnDemand"].(map[string]interface{})
+	for _, v := range onDemand {
+		offer, _ := v.(map[string]interface{})
+		dimensions, _ := offer["priceDimensions"].(map[string]interface{})
+		for _, d := range dimensions {
+			dimension, _ := d.(map[string]interface{})
+			pricePerUnit, _ := dimension["pricePerUnit"].(map[string]interface{})
+			if usd, ok := pricePerUnit["USD"].(string); ok {
+				return strconv.ParseFloat(usd, 64)
+			}
+		}
+	}
+
+	return 0, fmt.Errorf("price list entry has no on demand USD price")
+}
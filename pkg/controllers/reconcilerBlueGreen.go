@@ -0,0 +1,330 @@
+package controllers
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	apiTypes "github.com/anyo/aws-node-group-manager/pkg/apis"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/sirupsen/logrus"
+)
+
+const defaultDrainTimeout = 5 * time.Minute
+
+// reconcileBlueGreen rolls a new launch template version out to a new (green) asg next to the live (blue) one
+func (r *ReconcilerService) reconcileBlueGreen(model *apiTypes.OperatorModel, blue *autoscaling.Group, desired apiTypes.AutoScalingGroupOptions, templateVersion string) (*autoscaling.Group, bool) {
+	green, err := r.findCandidateAsg(model)
+	if err != nil {
+		withAwsError(r.logger(), err).Error("Failed to look up candidate ASG")
+		return blue, false
+	}
+
+	staleInstances, err := r.findStaleInstances(blue, templateVersion)
+	if err != nil {
+		withAwsError(r.logger(), err).WithField(AsgField, *blue.AutoScalingGroupName).Error("Failed to check for stale instances")
+		return blue, false
+	}
+
+	staleInstanceCount.WithLabelValues(model.NodeGroupName()).Set(float64(len(staleInstances)))
+	if green == nil && len(staleInstances) == 0 {
+		return blue, true
+	}
+
+	if green == nil && r.Controls.Paused(model.NodeGroupName()) {
+		r.logger().WithField(AsgField, *blue.AutoScalingGroupName).Info("Rollout paused, resume it to start the blue/green replacement")
+		return blue, true
+	}
+
+	if green == nil {
+		r.Notifications.Send(apiTypes.NotificationEvent{
+			Type:      apiTypes.RolloutStarted,
+			Message:   fmt.Sprintf("Starting blue/green replacement to launch template version %v, %v stale instances", templateVersion, len(staleInstances)),
+			Asg:       *blue.AutoScalingGroupName,
+			Version:   templateVersion,
+			Remaining: len(staleInstances),
+		})
+	}
+
+	cutOver, err := r.BlueGreenRollout(model, blue, green, desired)
+	if err != nil {
+		logger := r.logger().WithField(AsgField, *blue.AutoScalingGroupName)
+		logger.WithError(err).Error("Blue/green replacement failed")
+		r.Notifications.Send(apiTypes.NotificationEvent{
+			Type:    apiTypes.RolloutFailed,
+			Message: "Blue/green replacement failed",
+			Asg:     *blue.AutoScalingGroupName,
+			Version: templateVersion,
+			Error:   err.Error(),
+		})
+		if model.AutoRollback {
+			logger.Warn("Aborting back to blue ASG")
+			if abortErr := r.Abort(model); abortErr != nil {
+				logger.WithError(abortErr).Error("Abort failed")
+			}
+		}
+		return blue, false
+	}
+
+	if !cutOver {
+		return blue, true
+	}
+
+	r.Notifications.Send(apiTypes.NotificationEvent{
+		Type:     apiTypes.BatchCompleted,
+		Message:  fmt.Sprintf("Cut over to green ASG %v", r.candidateName(blue)),
+		Asg:      r.candidateName(blue),
+		Version:  templateVersion,
+		Replaced: len(staleInstances),
+	})
+
+	// the rollout is done, old versions are no longer needed
+	if _, err := r.CollectLaunchTemplateVersions(model, false); err != nil {
+		r.logger().WithError(err).Error("Failed to garbage collect launch template versions")
+	}
+
+	return r.AsgService.GetAutoScalingGroup(r.candidateName(blue)), true
+}
+
+//BlueGreenRollout represents bringing up the green asg, waiting for its nodes to be Ready and cutting over from blue.
+//Returns false without an error when paused before the cutover.
+func (r *ReconcilerService) BlueGreenRollout(model *apiTypes.OperatorModel, blue *autoscaling.Group, green *autoscaling.Group, desired apiTypes.AutoScalingGroupOptions) (bool, error) {
+	// a candidate left from an earlier rollout of another version is replaced
+	if green != nil && (green.LaunchTemplate == nil || aws.StringValue(green.LaunchTemplate.Version) != desired.LaunchTemplateVersion) {
+		r.logger().WithFields(logrus.Fields{AsgField: *green.AutoScalingGroupName, VersionField: desired.LaunchTemplateVersion}).Info("Candidate ASG runs another launch template version, replacing it")
+		if err := r.Abort(model); err != nil {
+			return false, err
+		}
+		green = nil
+	}
+
+	if green == nil {
+		if err := r.lockLost(); err != nil {
+			return false, err
+		}
+		var err error
+		green, err = r.createCandidateAsg(blue, desired)
+		if err != nil {
+			return false, err
+		}
+	}
+
+	if err := r.AsgStatusMonitor(green.AutoScalingGroupName, r.healthTimeout(model)); err != nil {
+		return false, err
+	}
+
+	green = r.AsgService.GetAutoScalingGroup(*green.AutoScalingGroupName)
+	if err := r.waitForAsgNodesReady(green, r.healthTimeout(model)); err != nil {
+		return false, err
+	}
+
+	if model.PauseBeforeCutover || r.Controls.Paused(model.NodeGroupName()) {
+		r.logger().WithFields(logrus.Fields{AsgField: *green.AutoScalingGroupName, "blueAsg": *blue.AutoScalingGroupName}).Info("Blue/green replacement paused, green ASG is ready. Promote to cut over or abort to return to blue")
+		return false, nil
+	}
+
+	return true, r.cutover(blue, green)
+}
+
+//Promote represents cutting over from the blue asg to a ready green asg of a paused blue/green replacement
+func (r *ReconcilerService) Promote(model *apiTypes.OperatorModel) error {
+	blue, err := r.findOwnedAsg(model, r.ResourceName(model, model.AutoScalingGroupOptions.Name))
+	if err != nil {
+		return err
+	}
+
+	green, err := r.findCandidateAsg(model)
+	if err != nil {
+		return err
+	}
+
+	if green == nil {
+		return fmt.Errorf("node group %v has no blue/green replacement in progress", model.NodeGroupName())
+	}
+
+	if blue == nil {
+		// blue was deleted by an interrupted cutover
+		return r.AsgService.DeleteAsgTags(green.AutoScalingGroupName, []string{CandidateTagKey})
+	}
+
+	if err := r.waitForAsgNodesReady(green, r.healthTimeout(model)); err != nil {
+		return err
+	}
+
+	return r.cutover(blue, green)
+}
+
+//Abort represents deleting the green asg of a blue/green replacement, leaving the blue asg live and schedulable.
+//Green nodes are cordoned and drained first, the pods already scheduled on them move back to blue.
+func (r *ReconcilerService) Abort(model *apiTypes.OperatorModel) error {
+	green, err := r.findCandidateAsg(model)
+	if err != nil {
+		return err
+	}
+
+	if green == nil {
+		return fmt.Errorf("node group %v has no blue/green replacement in progress", model.NodeGroupName())
+	}
+
+	if blue, _ := r.findOwnedAsg(model, r.ResourceName(model, model.AutoScalingGroupOptions.Name)); blue != nil {
+		nodeNames, err := r.asgNodeNames(blue)
+		if err != nil {
+			return err
+		}
+
+		for _, v := range nodeNames {
+			_ = r.KubeService.UncordonNode(v)
+		}
+	}
+
+	nodeNames, err := r.asgNodeNames(green)
+	if err != nil {
+		return err
+	}
+
+	for _, v := range nodeNames {
+		if err := r.KubeService.CordonNode(v); err != nil {
+			return err
+		}
+	}
+
+	for _, v := range nodeNames {
+		if err := r.KubeService.DrainNode(v, defaultDrainTimeout); err != nil {
+			return err
+		}
+	}
+
+	if err := r.lockLost(); err != nil {
+		return err
+	}
+	r.logger().WithField(AsgField, *green.AutoScalingGroupName).Info("Deleting green ASG")
+	return r.AsgService.DeleteAsg(green.AutoScalingGroupName)
+}
+
+// cutover cordons and drains every blue node, deletes the blue asg and makes green the live asg
+func (r *ReconcilerService) cutover(blue *autoscaling.Group, green *autoscaling.Group) error {
+	logger := r.logger().WithFields(logrus.Fields{AsgField: *green.AutoScalingGroupName, "blueAsg": *blue.AutoScalingGroupName})
+	if err := r.lockLost(); err != nil {
+		return err
+	}
+	logger.Info("Cutting over from blue ASG to green ASG")
+
+	nodeNames, err := r.asgNodeNames(blue)
+	if err != nil {
+		return err
+	}
+
+	for _, v := range nodeNames {
+		if err := r.KubeService.CordonNode(v); err != nil {
+			return err
+		}
+	}
+
+	for _, v := range nodeNames {
+		if err := r.KubeService.DrainNode(v, defaultDrainTimeout); err != nil {
+			return err
+		}
+	}
+
+	if err := r.lockLost(); err != nil {
+		return err
+	}
+	if err := r.AsgService.DeleteAsg(blue.AutoScalingGroupName); err != nil {
+		return err
+	}
+
+	if err := r.AsgService.DeleteAsgTags(green.AutoScalingGroupName, []string{CandidateTagKey}); err != nil {
+		return err
+	}
+
+	logger.Info("Cut over to green ASG")
+	return nil
+}
+
+// findCandidateAsg looks up the green asg of a blue/green replacement in progress
+func (r *ReconcilerService) findCandidateAsg(model *apiTypes.OperatorModel) (*autoscaling.Group, error) {
+	tags := r.OwnershipTags(model)
+	tags[CandidateTagKey] = "true"
+
+	candidates := r.AsgService.FindAutoScalingGroups(tags)
+	if len(candidates) > 1 {
+		return nil, fmt.Errorf("found %v candidate asgs for node group %v", len(candidates), model.NodeGroupName())
+	}
+
+	if len(candidates) == 0 {
+		return nil, nil
+	}
+
+	return candidates[0], nil
+}
+
+// createCandidateAsg creates the green asg with the desired settings and the current capacity of blue
+func (r *ReconcilerService) createCandidateAsg(blue *autoscaling.Group, desired apiTypes.AutoScalingGroupOptions) (*autoscaling.Group, error) {
+	green := desired
+	green.Name = r.candidateName(blue)
+	if *blue.DesiredCapacity > green.DesiredInstances {
+		green.DesiredInstances = *blue.DesiredCapacity
+	}
+
+	green.Tags = make(map[string]string)
+	for k, v := range desired.Tags {
+		green.Tags[k] = v
+	}
+	green.Tags[ColorTagKey] = oppositeColor(blue)
+	green.Tags[CandidateTagKey] = "true"
+
+	r.logger().WithFields(logrus.Fields{AsgField: green.Name, VersionField: green.LaunchTemplateVersion}).Info("Creating green ASG")
+	if _, err := r.AsgService.CreateAsg(&green); err != nil {
+		return nil, err
+	}
+
+	return &autoscaling.Group{AutoScalingGroupName: aws.String(green.Name)}, nil
+}
+
+// candidateName is the blue asg name with the opposite color as suffix
+func (r *ReconcilerService) candidateName(blue *autoscaling.Group) string {
+	name := strings.TrimSuffix(strings.TrimSuffix(*blue.AutoScalingGroupName, "-blue"), "-green")
+	return name + "-" + oppositeColor(blue)
+}
+
+func oppositeColor(blue *autoscaling.Group) string {
+	if asgTagValue(blue, ColorTagKey) == "green" {
+		return "blue"
+	}
+
+	return "green"
+}
+
+func (r *ReconcilerService) waitForAsgNodesReady(asg *autoscaling.Group, timeout time.Duration) error {
+	nodeNames, err := r.asgNodeNames(asg)
+	if err != nil {
+		return err
+	}
+
+	return r.KubeService.WaitForNodesReady(nodeNames, timeout)
+}
+
+func (r *ReconcilerService) asgNodeNames(asg *autoscaling.Group) ([]string, error) {
+	if len(asg.Instances) == 0 {
+		return nil, nil
+	}
+
+	instanceIDs := []*string{}
+	for _, v := range asg.Instances {
+		instanceIDs = append(instanceIDs, v.InstanceId)
+	}
+
+	names, err := r.Ec2Service.GetInstanceNodeNames(instanceIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	nodeNames := []string{}
+	for _, v := range names {
+		nodeNames = append(nodeNames, v)
+	}
+
+	return nodeNames, nil
+}
@@ -0,0 +1,132 @@
+package controllers
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	apiTypes "github.com/anyo/aws-node-group-manager/pkg/apis"
+
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/sirupsen/logrus"
+)
+
+//HealthGateError represents a canary health gate that did not pass
+type HealthGateError struct {
+	Gate   string
+	Reason string
+}
+
+func (e *HealthGateError) Error() string {
+	return fmt.Sprintf("health gate %v failed: %v", e.Gate, e.Reason)
+}
+
+//RollingReplace represents replacing the stale instances of an asg in place. With a canary stage the configured
+//number of instances is replaced first and the rest only once the health gates pass after the soak period.
+func (r *ReconcilerService) RollingReplace(model *apiTypes.OperatorModel, asg *autoscaling.Group, templateVersion string) (int, error) {
+	canary := model.Canary
+	if canary.Instances <= 0 {
+		return r.ReplaceStaleInstances(model, asg, templateVersion, 0)
+	}
+
+	staleInstances, err := r.findStaleInstances(asg, templateVersion)
+	if err != nil || len(staleInstances) == 0 {
+		return 0, err
+	}
+
+	// instances already on the new version count towards the canaries of an interrupted rollout
+	if upToDate := len(asg.Instances) - len(staleInstances); upToDate < canary.Instances {
+		r.logger().WithFields(logrus.Fields{AsgField: *asg.AutoScalingGroupName, VersionField: templateVersion, "canaries": canary.Instances, "upToDate": upToDate}).Info("Replacing canary instances")
+		replaced, err := r.ReplaceStaleInstances(model, asg, templateVersion, canary.Instances-upToDate)
+		if err != nil || replaced == 0 {
+			return replaced, err
+		}
+
+		r.logger().WithField("soakSeconds", canary.SoakSeconds).Info("Soaking canary instances")
+		if err := r.sleepLocked(time.Duration(canary.SoakSeconds) * time.Second); err != nil {
+			return replaced, err
+		}
+	}
+
+	if r.Controls.Paused(model.NodeGroupName()) {
+		r.logger().Info("Rollout paused after the canary stage, resume it to replace the remaining instances")
+		return 0, nil
+	}
+
+	asg = r.AsgService.RefreshAutoScalingGroup(*asg.AutoScalingGroupName)
+	if asg == nil {
+		return 0, fmt.Errorf("asg disappeared during the canary stage")
+	}
+
+	if err := r.EvaluateHealthGates(asg, canary); err != nil {
+		return 0, err
+	}
+
+	r.logger().Info("Canary health gates passed, replacing the remaining instances")
+	return r.ReplaceStaleInstances(model, asg, templateVersion, 0)
+}
+
+//EvaluateHealthGates represents checking asg instance health, kubernetes node readiness and the optional http check and alarms
+func (r *ReconcilerService) EvaluateHealthGates(asg *autoscaling.Group, canary apiTypes.CanaryOptions) error {
+	for _, v := range asg.Instances {
+		if *v.HealthStatus != "Healthy" || *v.LifecycleState != "InService" {
+			return &HealthGateError{Gate: "asg", Reason: fmt.Sprintf("instance %v is %v/%v", *v.InstanceId, *v.HealthStatus, *v.LifecycleState)}
+		}
+	}
+
+	nodeNames, err := r.asgNodeNames(asg)
+	if err != nil {
+		return err
+	}
+
+	for _, v := range nodeNames {
+		ready, err := r.KubeService.IsNodeReady(v)
+		if err != nil {
+			return err
+		}
+		if !ready {
+			return &HealthGateError{Gate: "node", Reason: fmt.Sprintf("node %v is not Ready", v)}
+		}
+	}
+
+	if canary.HTTPCheckURL != "" {
+		client := http.Client{Timeout: 10 * time.Second}
+		response, err := client.Get(canary.HTTPCheckURL)
+		if err != nil {
+			return &HealthGateError{Gate: "http", Reason: err.Error()}
+		}
+		response.Body.Close()
+
+		if response.StatusCode < 200 || response.StatusCode > 299 {
+			return &HealthGateError{Gate: "http", Reason: fmt.Sprintf("%v answered %v", canary.HTTPCheckURL, response.Status)}
+		}
+	}
+
+	if len(canary.CloudWatchAlarms) > 0 {
+		states, err := r.CloudWatchService.GetAlarmStates(canary.CloudWatchAlarms)
+		if err != nil {
+			return err
+		}
+
+		// an alarm that does not exist cannot vouch for the canary
+		missing := []string{}
+		for _, v := range canary.CloudWatchAlarms {
+			if _, ok := states[v]; !ok {
+				missing = append(missing, v)
+			}
+		}
+		if len(missing) > 0 {
+			return &HealthGateError{Gate: "alarm", Reason: fmt.Sprintf("alarms %v not found", strings.Join(missing, ", "))}
+		}
+
+		for name, state := range states {
+			if state == "ALARM" {
+				return &HealthGateError{Gate: "alarm", Reason: fmt.Sprintf("alarm %v is in state %v", name, state)}
+			}
+		}
+	}
+
+	r.logger().WithField(AsgField, *asg.AutoScalingGroupName).Info("All health gates passed")
+	return nil
+}
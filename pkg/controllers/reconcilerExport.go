@@ -0,0 +1,77 @@
+package controllers
+
+import (
+	"fmt"
+	"sort"
+
+	apiTypes "github.com/anyo/aws-node-group-manager/pkg/apis"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+// latestTemplateVersion stands for the version the exported launch template is created with
+const latestTemplateVersion = "$Latest"
+
+//NodeGroupExport represents the launch template and asg of a node group as apply would create them, for other tooling
+type NodeGroupExport struct {
+	NodeGroup      string
+	LaunchTemplate *ec2.CreateLaunchTemplateInput
+	// Asg references the launch template by name, with the version $Latest when pinned and $Default otherwise
+	Asg *autoscaling.CreateAutoScalingGroupInput
+}
+
+//ExportNodeGroup represents resolving a node group the way ReconcileNodeGroup does, ami, networking, names and tags,
+//without changing anything. An ami waiting for approval is not exported, the one running stays.
+func (r *ReconcilerService) ExportNodeGroup(model *apiTypes.OperatorModel, k8sVersion string) (*NodeGroupExport, error) {
+	ami, err := r.GetLatestEksAmi(k8sVersion)
+	if err != nil {
+		return nil, err
+	}
+	if model.RequireAmiApproval && !r.Controls.AmiApproved(model.NodeGroupName(), ami) {
+		current, err := r.currentAmi(model)
+		if err != nil {
+			return nil, err
+		}
+		if current != "" {
+			ami = current
+		}
+	}
+	model.AmiID = ami
+
+	if err := r.ResolveNetworking(model); err != nil {
+		return nil, fmt.Errorf("invalid networking configuration: %v", err)
+	}
+
+	launchTemplate := r.desiredLaunchTemplate(model)
+	asg, err := r.desiredAsg(model, launchTemplate.Name, latestTemplateVersion)
+	if err != nil {
+		return nil, err
+	}
+
+	export := NodeGroupExport{
+		NodeGroup:      model.NodeGroupName(),
+		LaunchTemplate: r.Ec2Service.getCreateLaunchTemplateInput(&launchTemplate),
+		Asg:            r.AsgService.getCreateAsgInput(&asg),
+	}
+
+	// tags come from maps, sorted the templates do not change between runs
+	for _, v := range export.LaunchTemplate.TagSpecifications {
+		sortEc2Tags(v.Tags)
+	}
+	for _, v := range export.LaunchTemplate.LaunchTemplateData.TagSpecifications {
+		sortEc2Tags(v.Tags)
+	}
+	sort.Slice(export.Asg.Tags, func(i, j int) bool {
+		return aws.StringValue(export.Asg.Tags[i].Key) < aws.StringValue(export.Asg.Tags[j].Key)
+	})
+
+	return &export, nil
+}
+
+func sortEc2Tags(tags []*ec2.Tag) {
+	sort.Slice(tags, func(i, j int) bool {
+		return aws.StringValue(tags[i].Key) < aws.StringValue(tags[j].Key)
+	})
+}
@@ -0,0 +1,284 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	apiTypes "github.com/anyo/aws-node-group-manager/pkg/apis"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/sirupsen/logrus"
+)
+
+//NodeGroupResult represents the outcome of reconciling one node group
+type NodeGroupResult struct {
+	NodeGroup string
+	Success   bool
+	// Skipped is set when a node group this one depends on did not succeed
+	Skipped  bool
+	Err      error
+	Duration time.Duration
+}
+
+//ReconcileNodeGroup represents the full reconcile of one node group: ami lookup, networking, launch template, asg and orphans
+func (r *ReconcilerService) ReconcileNodeGroup(model *apiTypes.OperatorModel, k8sVersion string) error {
+	ami, err := r.GetLatestEksAmi(k8sVersion)
+	if err != nil {
+		return err
+	}
+	if model.RequireAmiApproval {
+		if ami, err = r.approvedAmi(model, ami); err != nil {
+			return err
+		}
+	}
+	model.AmiID = ami
+	if created, err := r.Ec2Service.GetImageCreationDate(&ami); err == nil {
+		recordAmiAge(model.NodeGroupName(), created)
+	}
+
+	if err := r.ResolveNetworking(model); err != nil {
+		return fmt.Errorf("invalid networking configuration: %v", err)
+	}
+
+	if err := r.lockLost(); err != nil {
+		return err
+	}
+	templateName, latestVersion, success := r.ReconcileLaunchTemplate(model)
+	if !success {
+		return fmt.Errorf("failed to reconcile launch template")
+	}
+
+	if err := r.lockLost(); err != nil {
+		return err
+	}
+	_, success = r.ReconcileAutoScalingGroup(model, templateName, latestVersion)
+	if !success {
+		return fmt.Errorf("failed to reconcile asg")
+	}
+
+	if err := r.lockLost(); err != nil {
+		return err
+	}
+	if !r.ReconcileOrphanInstances(model) {
+		return fmt.Errorf("failed to reconcile orphaned instances")
+	}
+
+	return nil
+}
+
+// approvedAmi holds a new ami back, keeping the one of the current launch template, until it is approved
+func (r *ReconcilerService) approvedAmi(model *apiTypes.OperatorModel, latest string) (string, error) {
+	current, err := r.currentAmi(model)
+	if err != nil {
+		return "", err
+	}
+	if current == "" || current == latest {
+		return latest, nil
+	}
+
+	if r.Controls.AmiApproved(model.NodeGroupName(), latest) {
+		r.logger().WithFields(logrus.Fields{"ami": latest, "currentAmi": current}).Info("Rolling out approved ami")
+		return latest, nil
+	}
+
+	r.logger().WithFields(logrus.Fields{"ami": latest, "currentAmi": current}).Info("New ami is waiting for approval")
+	if r.Controls.setPendingAmi(model.NodeGroupName(), latest) {
+		r.Notifications.Send(apiTypes.NotificationEvent{
+			Type:    apiTypes.AmiAvailable,
+			Message: fmt.Sprintf("New ami %v is waiting for approval, keeping %v", latest, current),
+			Ami:     latest,
+		})
+	}
+
+	return current, nil
+}
+
+// currentAmi returns the ami of the default version of the launch template, empty when there is none yet
+func (r *ReconcilerService) currentAmi(model *apiTypes.OperatorModel) (string, error) {
+	launchTemplate, err := r.findOwnedLaunchTemplate(model, r.ResourceName(model, model.LaunchTemplateOptions.Name))
+	if err != nil || launchTemplate == nil {
+		return "", err
+	}
+
+	version := strconv.FormatInt(*launchTemplate.DefaultVersionNumber, 10)
+	current, err := r.Ec2Service.GetLaunchTemplateVersion(launchTemplate.LaunchTemplateName, &version)
+	if err != nil || current == nil {
+		return "", err
+	}
+
+	return aws.StringValue(current.LaunchTemplateData.ImageId), nil
+}
+
+//ReconcileNodeGroups represents reconciling node groups in parallel, at most concurrency at a time. A node group starts once
+//every node group it depends on has succeeded; a failure skips its dependents but does not stop the other node groups.
+//Results are returned in config order.
+func (r *ReconcilerService) ReconcileNodeGroups(models []apiTypes.OperatorModel, k8sVersion string, concurrency int) ([]NodeGroupResult, error) {
+	if err := ValidateNodeGroupDependencies(models); err != nil {
+		return nil, err
+	}
+
+	if concurrency <= 0 {
+		concurrency = 1
+	}
+
+	// describe results are shared within the pass only
+	r.AsgService.Cache.Clear()
+	defer r.AsgService.Cache.Clear()
+
+	results := make(map[string]NodeGroupResult)
+	pending := make([]int, 0, len(models))
+	for i := range models {
+		pending = append(pending, i)
+	}
+
+	done := make(chan NodeGroupResult)
+	running := 0
+	for len(pending) > 0 || running > 0 {
+		waiting := pending[:0]
+		for _, i := range pending {
+			model := &models[i]
+			ready, blocked := dependencyStatus(model, results)
+			switch {
+			case blocked != "":
+				r.logger().WithFields(logrus.Fields{NodeGroupField: model.NodeGroupName(), "dependency": blocked}).Warn("Skipping node group, dependency did not succeed")
+				results[model.NodeGroupName()] = NodeGroupResult{NodeGroup: model.NodeGroupName(), Skipped: true, Err: fmt.Errorf("dependency %v did not succeed", blocked)}
+				recordReconcileResult(results[model.NodeGroupName()])
+			case ready && running < concurrency:
+				running++
+				go r.reconcileNodeGroupAsync(model, k8sVersion, done)
+			default:
+				waiting = append(waiting, i)
+			}
+		}
+		pending = waiting
+
+		if running == 0 {
+			continue
+		}
+
+		result := <-done
+		running--
+		results[result.NodeGroup] = result
+		recordReconcileResult(result)
+	}
+
+	ordered := []NodeGroupResult{}
+	for i := range models {
+		ordered = append(ordered, results[models[i].NodeGroupName()])
+	}
+
+	return ordered, nil
+}
+
+// reconcileNodeGroupAsync reconciles on a copy of the reconciler holding the node group's own state store
+func (r *ReconcilerService) reconcileNodeGroupAsync(model *apiTypes.OperatorModel, k8sVersion string, done chan<- NodeGroupResult) {
+	start := time.Now()
+	result := NodeGroupResult{NodeGroup: model.NodeGroupName()}
+
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			result.Err = fmt.Errorf("panic: %v", recovered)
+		}
+		result.Success = result.Err == nil
+		result.Duration = time.Since(start)
+		done <- result
+	}()
+
+	svc, err := r.ForNodeGroup(model)
+	if err != nil {
+		result.Err = err
+		return
+	}
+
+	svc.logger().Info("Reconciling node group")
+	recordReconcileStart(model.NodeGroupName())
+	result.Err = svc.WithNodeGroupLock(model, func(context.Context) error {
+		return svc.ReconcileNodeGroup(model, k8sVersion)
+	})
+}
+
+//ForNodeGroup represents a copy of the reconciler working on one node group: its own state store, auditor,
+//notifications and logger
+func (r *ReconcilerService) ForNodeGroup(model *apiTypes.OperatorModel) (*ReconcilerService, error) {
+	svc := *r
+	stateStore, err := NewStateStore(model.StateOptions, r.Ec2Service.AwsSession)
+	if err != nil {
+		return nil, err
+	}
+	svc.StateStore = stateStore
+
+	if err := svc.UseNodeGroupAuditor(model); err != nil {
+		return nil, err
+	}
+	if err := svc.UseNodeGroupNotifications(model); err != nil {
+		return nil, err
+	}
+
+	svc.WithLogger(r.logger().WithField(NodeGroupField, model.NodeGroupName()))
+	return &svc, nil
+}
+
+// dependencyStatus reports whether all dependencies succeeded, or the first dependency that failed or was skipped
+func dependencyStatus(model *apiTypes.OperatorModel, results map[string]NodeGroupResult) (bool, string) {
+	ready := true
+	for _, v := range model.DependsOn {
+		result, finished := results[v]
+		if !finished {
+			ready = false
+			continue
+		}
+		if !result.Success {
+			return false, v
+		}
+	}
+
+	return ready, ""
+}
+
+//ValidateNodeGroupDependencies represents checking node group names are unique and dependencies exist and have no cycles
+func ValidateNodeGroupDependencies(models []apiTypes.OperatorModel) error {
+	byName := make(map[string]*apiTypes.OperatorModel)
+	for i := range models {
+		name := models[i].NodeGroupName()
+		if _, ok := byName[name]; ok {
+			return fmt.Errorf("node group %v is defined more than once", name)
+		}
+		byName[name] = &models[i]
+	}
+
+	// depth first search, a node group reached again while still being visited is part of a cycle
+	const visiting, visited = 1, 2
+	marks := make(map[string]int)
+	var visit func(name string) error
+	visit = func(name string) error {
+		switch marks[name] {
+		case visiting:
+			return fmt.Errorf("node group %v depends on itself", name)
+		case visited:
+			return nil
+		}
+
+		marks[name] = visiting
+		for _, v := range byName[name].DependsOn {
+			if _, ok := byName[v]; !ok {
+				return fmt.Errorf("node group %v depends on unknown node group %v", name, v)
+			}
+			if err := visit(v); err != nil {
+				return err
+			}
+		}
+		marks[name] = visited
+
+		return nil
+	}
+
+	for i := range models {
+		if err := visit(models[i].NodeGroupName()); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,92 @@
+package controllers
+
+import (
+	"strings"
+	"testing"
+
+	apiTypes "github.com/anyo/aws-node-group-manager/pkg/apis"
+)
+
+func nodeGroupWithDependencies(name string, dependsOn ...string) apiTypes.OperatorModel {
+	model := apiTypes.OperatorModel{DependsOn: dependsOn}
+	model.AutoScalingGroupOptions.Name = name
+	return model
+}
+
+func TestValidateNodeGroupDependencies(t *testing.T) {
+	tests := []struct {
+		name   string
+		models []apiTypes.OperatorModel
+		// err is a substring of the expected error, empty when the models are valid
+		err string
+	}{
+		{
+			name:   "no dependencies",
+			models: []apiTypes.OperatorModel{nodeGroupWithDependencies("a"), nodeGroupWithDependencies("b")},
+		},
+		{
+			name: "chain",
+			models: []apiTypes.OperatorModel{
+				nodeGroupWithDependencies("c", "b"),
+				nodeGroupWithDependencies("b", "a"),
+				nodeGroupWithDependencies("a"),
+			},
+		},
+		{
+			name: "diamond",
+			models: []apiTypes.OperatorModel{
+				nodeGroupWithDependencies("a"),
+				nodeGroupWithDependencies("b", "a"),
+				nodeGroupWithDependencies("c", "a"),
+				nodeGroupWithDependencies("d", "b", "c"),
+			},
+		},
+		{
+			name:   "duplicate name",
+			models: []apiTypes.OperatorModel{nodeGroupWithDependencies("a"), nodeGroupWithDependencies("a")},
+			err:    "node group a is defined more than once",
+		},
+		{
+			name:   "unknown dependency",
+			models: []apiTypes.OperatorModel{nodeGroupWithDependencies("a", "missing")},
+			err:    "node group a depends on unknown node group missing",
+		},
+		{
+			name:   "self dependency",
+			models: []apiTypes.OperatorModel{nodeGroupWithDependencies("a", "a")},
+			err:    "depends on itself",
+		},
+		{
+			name: "cycle",
+			models: []apiTypes.OperatorModel{
+				nodeGroupWithDependencies("a", "c"),
+				nodeGroupWithDependencies("b", "a"),
+				nodeGroupWithDependencies("c", "b"),
+			},
+			err: "depends on itself",
+		},
+		{
+			name: "cycle behind a valid node group",
+			models: []apiTypes.OperatorModel{
+				nodeGroupWithDependencies("a", "b"),
+				nodeGroupWithDependencies("b", "c"),
+				nodeGroupWithDependencies("c", "b"),
+			},
+			err: "depends on itself",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateNodeGroupDependencies(tt.models)
+			switch {
+			case tt.err == "" && err != nil:
+				t.Fatalf("unexpected error: %v", err)
+			case tt.err != "" && err == nil:
+				t.Fatalf("expected an error containing %q", tt.err)
+			case tt.err != "" && !strings.Contains(err.Error(), tt.err):
+				t.Fatalf("expected an error containing %q, got %v", tt.err, err)
+			}
+		})
+	}
+}
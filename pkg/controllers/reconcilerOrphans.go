@@ -0,0 +1,143 @@
+package controllers
+
+import (
+	"time"
+
+	apiTypes "github.com/anyo/aws-node-group-manager/pkg/apis"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/sirupsen/logrus"
+)
+
+const defaultOrphanGracePeriod = 30 * time.Minute
+
+//FindOrphanInstances represents finding the instances owned by this manager in the cluster that are not in one of its asgs,
+//such as instances detached during a rollout that failed to terminate. Instances tracked by the persisted rollout of
+//their node group are still being replaced and are not orphans.
+func (r *ReconcilerService) FindOrphanInstances(model *apiTypes.OperatorModel) ([]apiTypes.OrphanInstance, error) {
+	tags := r.OwnershipTags(model)
+	delete(tags, NodeGroupTagKey)
+
+	instances, err := r.Ec2Service.FindInstances(tags)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(instances) == 0 {
+		return nil, nil
+	}
+
+	managed := make(map[string]bool)
+	for _, v := range r.AsgService.FindAutoScalingGroups(tags) {
+		managed[*v.AutoScalingGroupName] = true
+	}
+
+	instanceIDs := []*string{}
+	for _, v := range instances {
+		instanceIDs = append(instanceIDs, v.InstanceId)
+	}
+
+	groups, err := r.AsgService.GetInstanceAutoScalingGroups(instanceIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	rollouts := make(map[string]*apiTypes.RolloutState)
+	orphans := []apiTypes.OrphanInstance{}
+	for _, v := range instances {
+		if managed[groups[*v.InstanceId]] {
+			continue
+		}
+
+		orphan := apiTypes.OrphanInstance{
+			InstanceID:   *v.InstanceId,
+			InstanceType: aws.StringValue(v.InstanceType),
+			State:        aws.StringValue(v.State.Name),
+			LaunchTime:   aws.TimeValue(v.LaunchTime),
+			Age:          time.Since(aws.TimeValue(v.LaunchTime)).Round(time.Minute),
+		}
+
+		for _, t := range v.Tags {
+			if *t.Key == NodeGroupTagKey {
+				orphan.NodeGroup = *t.Value
+			}
+		}
+
+		if orphan.NodeGroup != "" {
+			// in operator mode the rollouts of the cluster are looked up in the namespace of this node group
+			key := apiTypes.NodeGroupKey(model.ResourceNamespace, orphan.NodeGroup)
+			state, ok := rollouts[key]
+			if !ok {
+				if state, err = r.stateStore().Load(key); err != nil {
+					return nil, err
+				}
+				rollouts[key] = state
+			}
+			if state != nil {
+				if _, tracked := state.Instances[orphan.InstanceID]; tracked {
+					continue
+				}
+			}
+		}
+
+		// stopped instances are not billed for compute
+		if orphan.State != "stopped" {
+			if price, err := r.PricingService.GetOnDemandHourlyPrice(orphan.InstanceType); err == nil {
+				orphan.EstimatedCost = price * orphan.Age.Hours()
+			}
+		}
+
+		orphans = append(orphans, orphan)
+	}
+
+	return orphans, nil
+}
+
+//CleanUpOrphanInstances represents draining and terminating orphaned instances, returning how many failed to terminate.
+//Instances launched within the grace period of the node group are left for a later run.
+func (r *ReconcilerService) CleanUpOrphanInstances(model *apiTypes.OperatorModel, orphans []apiTypes.OrphanInstance) int {
+	gracePeriod := defaultOrphanGracePeriod
+	if model.OrphanOptions.GracePeriodSeconds > 0 {
+		gracePeriod = time.Duration(model.OrphanOptions.GracePeriodSeconds) * time.Second
+	}
+
+	failed := 0
+	for i, v := range orphans {
+		logger := r.logger().WithFields(logrus.Fields{InstanceIDField: v.InstanceID, NodeGroupField: v.NodeGroup})
+		if err := r.lockLost(); err != nil {
+			logger.WithError(err).Error("Stopping orphaned instance cleanup")
+			return failed + len(orphans) - i
+		}
+		if time.Since(v.LaunchTime) < gracePeriod {
+			logger.WithField("age", v.Age.String()).Info("Orphaned instance is within its grace period, not terminating it yet")
+			continue
+		}
+
+		logger.Info("Terminating orphaned instance")
+		r.drainInstance(aws.String(v.InstanceID))
+		if !r.Ec2Service.TerminateInstance(aws.String(v.InstanceID)) {
+			failed++
+		}
+	}
+
+	return failed
+}
+
+//ReconcileOrphanInstances represents reporting orphaned instances and terminating them when configured to
+func (r *ReconcilerService) ReconcileOrphanInstances(model *apiTypes.OperatorModel) bool {
+	orphans, err := r.FindOrphanInstances(model)
+	if err != nil {
+		withAwsError(r.logger(), err).Error("Failed to look for orphaned instances")
+		return false
+	}
+
+	for _, v := range orphans {
+		r.logger().WithFields(logrus.Fields{InstanceIDField: v.InstanceID, NodeGroupField: v.NodeGroup, "state": v.State, "age": v.Age.String(), "estimatedCost": v.EstimatedCost}).Warn("Orphaned instance")
+	}
+
+	if len(orphans) == 0 || !model.OrphanOptions.Terminate {
+		return true
+	}
+
+	return r.CleanUpOrphanInstances(model, orphans) == 0
+}
@@ -0,0 +1,428 @@
+package controllers
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"time"
+
+	apiTypes "github.com/anyo/aws-node-group-manager/pkg/apis"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/sirupsen/logrus"
+)
+
+const defaultRollbackHealthTimeout = 10 * time.Minute
+
+//ReplaceStaleInstances represents replacing, one at a time, the instances not running the given launch template version.
+//Each stale instance is detached, the asg is given time to bring up its replacement and the old instance is drained
+//and terminated. Progress is persisted so an interrupted run cleans up detached instances on the next run.
+//A limit above zero replaces at most that many instances.
+func (r *ReconcilerService) ReplaceStaleInstances(model *apiTypes.OperatorModel, asg *autoscaling.Group, templateVersion string, limit int) (int, error) {
+	state, err := r.resumeRollout(model, asg, templateVersion)
+	if err != nil {
+		return 0, err
+	}
+
+	staleInstances, err := r.findStaleInstances(asg, templateVersion)
+	if err != nil {
+		return 0, err
+	}
+
+	if len(staleInstances) == 0 {
+		r.logger().WithFields(logrus.Fields{AsgField: *asg.AutoScalingGroupName, VersionField: templateVersion}).Info("No stale instances found in the ASG")
+		recordRolloutProgress(model.NodeGroupName(), 0, 0)
+		return 0, r.stateStore().Delete(model.NodeGroupKey())
+	}
+
+	r.logger().WithFields(logrus.Fields{AsgField: *asg.AutoScalingGroupName, VersionField: templateVersion, "stale": len(staleInstances)}).Info("Stale instances found in the ASG")
+	remaining := len(staleInstances)
+	if limit > 0 && limit < len(staleInstances) {
+		staleInstances = staleInstances[:limit]
+	}
+
+	// instances of the target version are only tracked once its rollout has started
+	if len(state.Instances) == 0 {
+		r.Notifications.Send(apiTypes.NotificationEvent{
+			Type:      apiTypes.RolloutStarted,
+			Message:   fmt.Sprintf("Rolling out launch template version %v, %v stale instances", templateVersion, remaining),
+			Asg:       *asg.AutoScalingGroupName,
+			Version:   templateVersion,
+			Remaining: remaining,
+		})
+	}
+
+	replaced := 0
+	detachFailed := []string{}
+	recordRolloutProgress(model.NodeGroupName(), replaced, remaining)
+	for _, v := range staleInstances {
+		if r.Controls.Paused(model.NodeGroupName()) {
+			r.logger().WithFields(logrus.Fields{"replaced": replaced, "remaining": remaining}).Info("Rollout paused, resume it to replace the remaining instances")
+			break
+		}
+		if err := r.lockLost(); err != nil {
+			return replaced, err
+		}
+
+		// recorded before detaching, a crash during the call must not lose track of the instance
+		if err := r.saveInstanceStep(state, *v.InstanceId, apiTypes.InstanceDetaching); err != nil {
+			return replaced, err
+		}
+
+		detached := r.AsgService.DetachInstance(v.InstanceId, asg.AutoScalingGroupName)
+		if !detached {
+			delete(state.Instances, *v.InstanceId)
+			detachFailed = append(detachFailed, *v.InstanceId)
+			continue
+		}
+
+		if err := r.saveInstanceStep(state, *v.InstanceId, apiTypes.InstanceDetached); err != nil {
+			return replaced, err
+		}
+
+		if err := r.AsgStatusMonitor(asg.AutoScalingGroupName, r.healthTimeout(model)); err != nil {
+			return replaced, err
+		}
+
+		if err := r.retireInstance(state, v.InstanceId, apiTypes.InstanceDetached); err != nil {
+			return replaced, err
+		}
+		replaced++
+		remaining--
+		instancesReplacedTotal.WithLabelValues(model.NodeGroupName()).Inc()
+		recordRolloutProgress(model.NodeGroupName(), replaced, remaining)
+	}
+
+	if replaced > 0 {
+		r.Notifications.Send(apiTypes.NotificationEvent{
+			Type:      apiTypes.BatchCompleted,
+			Message:   fmt.Sprintf("Replaced %v instances, %v remaining", replaced, remaining),
+			Asg:       *asg.AutoScalingGroupName,
+			Version:   templateVersion,
+			Replaced:  replaced,
+			Remaining: remaining,
+		})
+	}
+
+	if len(detachFailed) > 0 {
+		return replaced, fmt.Errorf("failed to detach instances %v", strings.Join(detachFailed, ", "))
+	}
+
+	if remaining == 0 {
+		return replaced, r.stateStore().Delete(model.NodeGroupKey())
+	}
+
+	return replaced, nil
+}
+
+// resumeRollout loads the persisted rollout of the node group and finishes replacing instances detached by an
+// interrupted run, which would otherwise keep running outside of the asg
+func (r *ReconcilerService) resumeRollout(model *apiTypes.OperatorModel, asg *autoscaling.Group, templateVersion string) (*apiTypes.RolloutState, error) {
+	state, err := r.stateStore().Load(model.NodeGroupKey())
+	if err != nil {
+		return nil, err
+	}
+
+	if state == nil {
+		state = &apiTypes.RolloutState{NodeGroup: model.NodeGroupKey(), Instances: make(map[string]string)}
+	}
+
+	inAsg := make(map[string]bool)
+	for _, v := range asg.Instances {
+		inAsg[*v.InstanceId] = true
+	}
+
+	for id, step := range state.Instances {
+		if step == apiTypes.InstanceTerminated {
+			continue
+		}
+
+		// the detach never happened, the instance is still stale or already replaced
+		if inAsg[id] {
+			delete(state.Instances, id)
+			continue
+		}
+
+		r.logger().WithFields(logrus.Fields{InstanceIDField: id, StepField: step, VersionField: state.TargetVersion}).Info("Cleaning up instance left by an interrupted rollout")
+		if err := r.retireInstance(state, aws.String(id), step); err != nil {
+			return nil, err
+		}
+	}
+
+	if state.TargetVersion != templateVersion {
+		state.Instances = make(map[string]string)
+	}
+	state.AsgName = *asg.AutoScalingGroupName
+	state.TargetVersion = templateVersion
+
+	return state, nil
+}
+
+// retireInstance drains and terminates a detached instance, resuming from the last step it completed. An instance
+// terminated by something else, a scale in, a spot reclaim or a person, is done already.
+func (r *ReconcilerService) retireInstance(state *apiTypes.RolloutState, instanceID *string, step string) error {
+	if err := r.lockLost(); err != nil {
+		return err
+	}
+
+	if r.instanceGone(instanceID) {
+		r.logger().WithField(InstanceIDField, *instanceID).Info("Instance is already terminated")
+		return r.saveInstanceStep(state, *instanceID, apiTypes.InstanceTerminated)
+	}
+
+	if step != apiTypes.InstanceDrained {
+		r.drainInstance(instanceID)
+		if err := r.saveInstanceStep(state, *instanceID, apiTypes.InstanceDrained); err != nil {
+			return err
+		}
+	}
+
+	_ = r.Ec2Service.ShutDownInstance(instanceID)
+	if !r.Ec2Service.TerminateInstance(instanceID) && !r.instanceGone(instanceID) {
+		return fmt.Errorf("failed to terminate instance %v", *instanceID)
+	}
+
+	return r.saveInstanceStep(state, *instanceID, apiTypes.InstanceTerminated)
+}
+
+func (r *ReconcilerService) instanceGone(instanceID *string) bool {
+	gone, err := r.Ec2Service.InstanceGone(instanceID)
+	if err != nil {
+		withAwsError(r.logger(), err).WithField(InstanceIDField, *instanceID).Warn("Failed to get instance state")
+	}
+
+	return gone
+}
+
+// drainInstance evicts the pods of the instance's node, best effort as the instance is terminated regardless
+func (r *ReconcilerService) drainInstance(instanceID *string) {
+	names, err := r.Ec2Service.GetInstanceNodeNames([]*string{instanceID})
+	if err != nil || names[*instanceID] == "" {
+		r.logger().WithField(InstanceIDField, *instanceID).Warn("Unable to find node of instance, skipping drain")
+		return
+	}
+
+	if err := r.KubeService.DrainNode(names[*instanceID], defaultDrainTimeout); err != nil {
+		r.logger().WithError(err).WithFields(logrus.Fields{InstanceIDField: *instanceID, "node": names[*instanceID]}).Warn("Failed to drain node, terminating regardless")
+	}
+}
+
+func (r *ReconcilerService) saveInstanceStep(state *apiTypes.RolloutState, instanceID string, step string) error {
+	state.Instances[instanceID] = step
+	state.UpdatedAt = time.Now()
+
+	if err := r.stateStore().Save(state); err != nil {
+		withAwsError(r.logger(), err).WithFields(logrus.Fields{InstanceIDField: instanceID, StepField: step}).Error("Failed to persist rollout state")
+		return err
+	}
+
+	return nil
+}
+
+// stateStore defaults to local files when no store is configured
+func (r *ReconcilerService) stateStore() StateStore {
+	if r.StateStore == nil {
+		return &FileStateStore{Dir: defaultStatePath}
+	}
+
+	return r.StateStore
+}
+
+// findStaleInstances returns the instances of the asg not running the given launch template version
+func (r *ReconcilerService) findStaleInstances(asg *autoscaling.Group, templateVersion string) ([]*autoscaling.Instance, error) {
+	versions, err := r.instanceLaunchTemplateVersions(asg)
+	if err != nil {
+		return nil, err
+	}
+
+	staleInstances := make([]*autoscaling.Instance, 0)
+	r.logger().WithFields(logrus.Fields{AsgField: *asg.AutoScalingGroupName, "instances": len(asg.Instances)}).Debug("Checking instances for stale launch template versions")
+	for _, v := range asg.Instances {
+		if currentVersion := versions[*v.InstanceId]; currentVersion != templateVersion {
+			r.logger().WithFields(logrus.Fields{InstanceIDField: *v.InstanceId, VersionField: templateVersion, "currentVersion": currentVersion}).Info("Stale instance")
+			staleInstances = append(staleInstances, v)
+		}
+	}
+
+	return staleInstances, nil
+}
+
+// instanceLaunchTemplateVersions maps the instances of the asg to the launch template version they were launched from
+func (r *ReconcilerService) instanceLaunchTemplateVersions(asg *autoscaling.Group) (map[string]string, error) {
+	// instances launched from $Default or $Latest report the alias, the launch template tag has the actual version
+	aliased := []*string{}
+	for _, v := range asg.Instances {
+		if v.LaunchTemplate != nil && strings.HasPrefix(aws.StringValue(v.LaunchTemplate.Version), "$") {
+			aliased = append(aliased, v.InstanceId)
+		}
+	}
+
+	launchedVersions := make(map[string]string)
+	if len(aliased) > 0 {
+		var err error
+		launchedVersions, err = r.Ec2Service.GetInstanceLaunchTemplateVersions(aliased)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	versions := make(map[string]string)
+	for _, v := range asg.Instances {
+		currentVersion := ""
+		if v.LaunchTemplate != nil {
+			currentVersion = aws.StringValue(v.LaunchTemplate.Version)
+		}
+		if version, ok := launchedVersions[*v.InstanceId]; ok {
+			currentVersion = version
+		}
+		versions[*v.InstanceId] = currentVersion
+	}
+
+	return versions, nil
+}
+
+//Rollback represents restoring a previous launch template version: the template default and the asg are pointed
+//at it and instances running any other version are replaced. Without a version the one before the current default is used.
+//The version rolled back from is remembered, the node group is not rolled forward to the same launch template again
+//until the config changes or ClearRollback is called.
+func (r *ReconcilerService) Rollback(model *apiTypes.OperatorModel, version string) error {
+	launchTemplate, err := r.findOwnedLaunchTemplate(model, r.ResourceName(model, model.LaunchTemplateOptions.Name))
+	if err != nil {
+		return err
+	}
+
+	if launchTemplate == nil {
+		return fmt.Errorf("launch template for node group %v does not exist", model.NodeGroupName())
+	}
+
+	asg, err := r.findOwnedAsg(model, r.ResourceName(model, model.AutoScalingGroupOptions.Name))
+	if err != nil {
+		return err
+	}
+
+	if asg == nil {
+		return fmt.Errorf("asg for node group %v does not exist", model.NodeGroupName())
+	}
+
+	if version == "" {
+		version, err = r.previousLaunchTemplateVersion(launchTemplate.LaunchTemplateName, *launchTemplate.DefaultVersionNumber)
+		if err != nil {
+			return err
+		}
+	}
+
+	target, err := r.Ec2Service.GetLaunchTemplateVersion(launchTemplate.LaunchTemplateName, &version)
+	if err != nil {
+		return err
+	}
+	if target == nil {
+		return fmt.Errorf("launch template %v has no version %v", *launchTemplate.LaunchTemplateName, version)
+	}
+
+	r.logger().WithFields(logrus.Fields{LaunchTemplateField: *launchTemplate.LaunchTemplateName, VersionField: version, "fromVersion": *launchTemplate.DefaultVersionNumber}).Warn("Rolling back launch template")
+	if _, err := r.Ec2Service.SetDefaultLaunchTemplateVersion(launchTemplate.LaunchTemplateName, version); err != nil {
+		return err
+	}
+
+	rolledBack := apiTypes.RolloutState{
+		NodeGroup:     rolledBackKey(model),
+		AsgName:       *asg.AutoScalingGroupName,
+		TargetVersion: strconv.FormatInt(*launchTemplate.DefaultVersionNumber, 10),
+		UpdatedAt:     time.Now(),
+	}
+	if err := r.stateStore().Save(&rolledBack); err != nil {
+		withAwsError(r.logger(), err).Error("Failed to persist the version rolled back from")
+		return err
+	}
+
+	// asgs referencing $Default follow the template default
+	if model.VersionPolicy != "default" {
+		if err := r.AsgService.SetLaunchTemplateVersion(asg.AutoScalingGroupName, launchTemplate.LaunchTemplateName, version); err != nil {
+			return err
+		}
+	}
+
+	r.Notifications.Send(apiTypes.NotificationEvent{
+		Type:    apiTypes.RolledBack,
+		Message: fmt.Sprintf("Rolled back launch template %v from version %v", *launchTemplate.LaunchTemplateName, *launchTemplate.DefaultVersionNumber),
+		Asg:     *asg.AutoScalingGroupName,
+		Version: version,
+	})
+
+	_, err = r.ReplaceStaleInstances(model, asg, version, 0)
+	return err
+}
+
+//ClearRollback represents forgetting the version the node group was rolled back from, the next reconcile rolls
+//forward to the config again even when it did not change
+func (r *ReconcilerService) ClearRollback(model *apiTypes.OperatorModel) error {
+	return r.stateStore().Delete(rolledBackKey(model))
+}
+
+// rolledBackKey is the state key of the version a rollback moved away from, its target version. It is kept apart
+// from the rollout state, which is deleted once the rollback has replaced the instances.
+func rolledBackKey(model *apiTypes.OperatorModel) string {
+	return model.NodeGroupKey() + ".rolled-back"
+}
+
+// rolledBackVersion returns the version the node group was rolled back from when it has the desired launch template
+// data, empty once the config changed
+func (r *ReconcilerService) rolledBackVersion(model *apiTypes.OperatorModel, name *string, desired *apiTypes.LaunchTemplateOptions) (string, error) {
+	state, err := r.stateStore().Load(rolledBackKey(model))
+	if err != nil || state == nil {
+		return "", err
+	}
+
+	version, err := r.Ec2Service.GetLaunchTemplateVersion(name, &state.TargetVersion)
+	if err != nil || version == nil {
+		return "", err
+	}
+
+	// the differences to the version rolled back from are not the drift of the template, they are not logged
+	quiet := logrus.New()
+	quiet.Out = ioutil.Discard
+	ec2Svc := r.Ec2Service
+	ec2Svc.Log = logrus.NewEntry(quiet)
+	if len(ec2Svc.LaunchTemplateDrift(desired, version.LaunchTemplateData)) > 0 {
+		return "", nil
+	}
+
+	return state.TargetVersion, nil
+}
+
+// previousLaunchTemplateVersion finds the highest existing version below the given one, older versions may have been collected
+func (r *ReconcilerService) previousLaunchTemplateVersion(name *string, current int64) (string, error) {
+	versions, err := r.Ec2Service.GetLaunchTemplateVersions(name)
+	if err != nil {
+		return "", err
+	}
+
+	previous := int64(0)
+	for _, v := range versions {
+		if *v.VersionNumber < current && *v.VersionNumber > previous {
+			previous = *v.VersionNumber
+		}
+	}
+
+	if previous == 0 {
+		return "", fmt.Errorf("launch template %v has no version before %v", *name, current)
+	}
+
+	return strconv.FormatInt(previous, 10), nil
+}
+
+// healthTimeout is how long a rollout waits for replacements to become healthy, indefinitely unless configured
+// or auto rollback is enabled
+func (r *ReconcilerService) healthTimeout(model *apiTypes.OperatorModel) time.Duration {
+	if model.HealthTimeoutSeconds > 0 {
+		return time.Duration(model.HealthTimeoutSeconds) * time.Second
+	}
+
+	if model.AutoRollback {
+		return defaultRollbackHealthTimeout
+	}
+
+	return 0
+}
@@ -0,0 +1,101 @@
+package controllers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	apiTypes "github.com/anyo/aws-node-group-manager/pkg/apis"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+)
+
+// testAwsSession is a session of a test server answering the query api actions with the given xml responses
+func testAwsSession(t *testing.T, responses map[string]string) session.Session {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		req.ParseForm()
+		response, ok := responses[req.Form.Get("Action")]
+		if !ok {
+			t.Errorf("unexpected action %v", req.Form.Get("Action"))
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "text/xml")
+		w.Write([]byte(response))
+	}))
+	t.Cleanup(server.Close)
+
+	awsSession := session.Must(session.NewSession(&aws.Config{
+		Region:      aws.String("us-east-1"),
+		Endpoint:    aws.String(server.URL),
+		Credentials: credentials.NewStaticCredentials("id", "secret", ""),
+		MaxRetries:  aws.Int(0),
+	}))
+	return *awsSession
+}
+
+const rolledBackVersionResponse = `<DescribeLaunchTemplateVersionsResponse xmlns="http://ec2.amazonaws.com/doc/2016-11-15/">
+  <requestId>1</requestId>
+  <launchTemplateVersionSet>
+    <item>
+      <launchTemplateName>workers-lt</launchTemplateName>
+      <versionNumber>3</versionNumber>
+      <launchTemplateData>
+        <imageId>ami-bad</imageId>
+        <instanceType>m5.large</instanceType>
+      </launchTemplateData>
+    </item>
+  </launchTemplateVersionSet>
+</DescribeLaunchTemplateVersionsResponse>`
+
+func TestRolledBackVersion(t *testing.T) {
+	tests := []struct {
+		name       string
+		rolledBack bool
+		ami        string
+		expected   string
+	}{
+		{name: "no rollback", ami: "ami-bad"},
+		{name: "config of the version rolled back from", rolledBack: true, ami: "ami-bad", expected: "3"},
+		{name: "config changed", rolledBack: true, ami: "ami-fixed"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			model := apiTypes.OperatorModel{}
+			model.AutoScalingGroupOptions.Name = "workers"
+
+			svc := ReconcilerService{StateStore: &FileStateStore{Dir: t.TempDir()}}
+			svc.Ec2Service.AwsSession = testAwsSession(t, map[string]string{"DescribeLaunchTemplateVersions": rolledBackVersionResponse})
+			if tt.rolledBack {
+				state := apiTypes.RolloutState{NodeGroup: rolledBackKey(&model), TargetVersion: "3", UpdatedAt: time.Now()}
+				if err := svc.StateStore.Save(&state); err != nil {
+					t.Fatalf("save: %v", err)
+				}
+			}
+
+			desired := apiTypes.LaunchTemplateOptions{AmiID: tt.ami, InstanceType: "m5.large"}
+			version, err := svc.rolledBackVersion(&model, aws.String("workers-lt"), &desired)
+			if err != nil {
+				t.Fatalf("rolled back version: %v", err)
+			}
+			if version != tt.expected {
+				t.Fatalf("rolled back from %q, expected %q", version, tt.expected)
+			}
+
+			// the rollout state of the node group does not share the key
+			if err := svc.StateStore.Delete(model.NodeGroupKey()); err != nil {
+				t.Fatalf("delete: %v", err)
+			}
+			if err := svc.ClearRollback(&model); err != nil {
+				t.Fatalf("clear: %v", err)
+			}
+			if version, _ := svc.rolledBackVersion(&model, aws.String("workers-lt"), &desired); version != "" {
+				t.Fatalf("rolled back from %v after the rollback was cleared", version)
+			}
+		})
+	}
+}
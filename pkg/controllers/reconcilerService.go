@@ -1,15 +1,40 @@
 package controllers
 
 import (
+	"context"
+	"crypto/sha256"
 	"encoding/base64"
-	"log"
+	"encoding/hex"
+	"fmt"
 	"strconv"
+	"strings"
 	"time"
 
 	apiTypes "github.com/anyo/aws-node-group-manager/pkg/apis"
 
+	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/autoscaling"
 	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v2"
+)
+
+// Ownership tags applied to every asg and launch template reconciled by the manager.
+// Existing resources are looked up by the manager id, cluster and node group tags.
+const (
+	ManagerIDTagKey  = "aws-node-group-manager/manager-id"
+	ClusterTagKey    = "aws-node-group-manager/cluster"
+	NodeGroupTagKey  = "aws-node-group-manager/node-group"
+	ConfigHashTagKey = "aws-node-group-manager/config-hash"
+	// ColorTagKey and CandidateTagKey tell apart the asgs of a node group during a blue/green replacement
+	ColorTagKey     = "aws-node-group-manager/color"
+	CandidateTagKey = "aws-node-group-manager/candidate"
+)
+
+const (
+	defaultNamingTemplate = "OperatorGenerated-{group}"
+	defaultManagerID      = "default"
+	defaultRetainVersions = 10
 )
 
 //ReconcilerService represents ssm operations
@@ -17,193 +42,704 @@ type ReconcilerService struct {
 	AsgService
 	Ec2Service
 	SsmService
+	KubeService
+	CloudWatchService
+	PricingService
+	StateStore StateStore
+	Locker     Locker
+	Log        *logrus.Entry
+	Auditor    *Auditor
+	// Notifications receives the rollout events of the node group being reconciled
+	Notifications *Notifications
+	// Controls holds rollouts paused and amis approved through the api
+	Controls *NodeGroupControls
+	// lockContext is cancelled when the lock held by WithNodeGroupLock is lost
+	lockContext context.Context
 }
 
 //ReconcileLaunchTemplate represents
-func (r *ReconcilerService) ReconcileLaunchTemplate(newLaunchTemplate *apiTypes.LaunchTemplateOptions) (*string, *string, bool) {
-	newLaunchTemplate.Name = "OperatorGenerated-" + newLaunchTemplate.Name
-	newLaunchTemplate.UserData = base64.StdEncoding.EncodeToString([]byte(newLaunchTemplate.UserData))
+func (r *ReconcilerService) ReconcileLaunchTemplate(model *apiTypes.OperatorModel) (*string, *string, bool) {
+	newLaunchTemplate := r.desiredLaunchTemplate(model)
 
-	launchTemplate := r.Ec2Service.GetLaunchTemplate(newLaunchTemplate.Name)
+	launchTemplate, err := r.findOwnedLaunchTemplate(model, newLaunchTemplate.Name)
+	if err != nil {
+		withAwsError(r.logger(), err).Error("Failed to look up launch template")
+		return nil, nil, false
+	}
 	var versionStr string
 
 	if launchTemplate != nil {
-		versionStr = strconv.Itoa(int(*launchTemplate.LatestVersionNumber))
+		newLaunchTemplate.Name = *launchTemplate.LaunchTemplateName
+		if !hasEc2Tags(launchTemplate.Tags, newLaunchTemplate.ResourceTags) {
+			if err := r.Ec2Service.TagResources([]*string{launchTemplate.LaunchTemplateId}, newLaunchTemplate.ResourceTags); err != nil {
+				return nil, nil, false
+			}
+		}
+
+		// the default version is the released one, versions after it may have been rolled back
+		versionStr = strconv.Itoa(int(*launchTemplate.DefaultVersionNumber))
 
-		v := r.Ec2Service.GetLaunchTemplateVersion(launchTemplate.LaunchTemplateName, &versionStr)
+		v, err := r.Ec2Service.GetLaunchTemplateVersion(launchTemplate.LaunchTemplateName, &versionStr)
+		if err != nil || v == nil {
+			withAwsError(r.logger(), err).WithFields(logrus.Fields{LaunchTemplateField: *launchTemplate.LaunchTemplateName, VersionField: versionStr}).Error("Failed to get launch template version")
+			return nil, nil, false
+		}
 
-		newLaunchTemplate, changed := r.Ec2Service.CompareLaunchTemplateData(newLaunchTemplate, v.LaunchTemplateData)
+		drift := r.Ec2Service.LaunchTemplateDrift(&newLaunchTemplate, v.LaunchTemplateData)
+		recordDrift(model.NodeGroupName(), "launchTemplate", drift)
+		if containsString(drift, "amiId") {
+			r.Notifications.Send(apiTypes.NotificationEvent{
+				Type:    apiTypes.AmiAvailable,
+				Message: fmt.Sprintf("New ami %v replaces %v", newLaunchTemplate.AmiID, aws.StringValue(v.LaunchTemplateData.ImageId)),
+				Ami:     newLaunchTemplate.AmiID,
+			})
+		}
 
-		// update the launch template since its changed compared to the current latest version
-		if changed {
-			updated, success := r.updateLaunchTemplate(v, newLaunchTemplate)
+		if len(drift) > 0 {
+			rolledBack, err := r.rolledBackVersion(model, launchTemplate.LaunchTemplateName, &newLaunchTemplate)
+			if err != nil {
+				withAwsError(r.logger(), err).Error("Failed to look up the version rolled back from")
+				return nil, &versionStr, false
+			}
+			if rolledBack != "" {
+				r.logger().WithFields(logrus.Fields{LaunchTemplateField: *launchTemplate.LaunchTemplateName, VersionField: versionStr, "rolledBackFrom": rolledBack}).Warn("Launch template was rolled back from the config, change it or clear the rollback to roll forward")
+				return launchTemplate.LaunchTemplateName, &versionStr, true
+			}
+		}
+
+		// update the launch template since its changed compared to the current default version
+		if len(drift) > 0 {
+			updated, success := r.updateLaunchTemplate(v, &newLaunchTemplate)
+			if !success {
+				return nil, &versionStr, false
+			}
+			versionStr = strconv.Itoa(int(*updated.DefaultVersionNumber))
 			return updated.LaunchTemplateName, &versionStr, success
 		}
 
-		log.Println("Launch template already exists and has not changed: ", *launchTemplate.LaunchTemplateName)
+		r.logger().WithFields(logrus.Fields{LaunchTemplateField: *launchTemplate.LaunchTemplateName, VersionField: versionStr}).Info("Launch template already exists and has not changed")
 		return launchTemplate.LaunchTemplateName, &versionStr, true
 	}
 
-	template, err := r.Ec2Service.CreateLaunchTemplate(newLaunchTemplate)
+	template, err := r.Ec2Service.CreateLaunchTemplate(&newLaunchTemplate)
 	if err != nil {
-		log.Println("Failed to create launch template", err)
+		withAwsError(r.logger(), err).WithField(LaunchTemplateField, newLaunchTemplate.Name).Error("Failed to create launch template")
 		return nil, &versionStr, false
 	}
 
-	log.Println("Launch template successfully created", template.LaunchTemplateName, &versionStr)
+	versionStr = strconv.Itoa(int(*template.LatestVersionNumber))
+	r.logger().WithFields(logrus.Fields{LaunchTemplateField: *template.LaunchTemplateName, VersionField: versionStr}).Info("Launch template successfully created")
 	return template.LaunchTemplateName, &versionStr, true
 }
 
 func (r *ReconcilerService) updateLaunchTemplate(launchTemplateVersion *ec2.LaunchTemplateVersion, newLaunchTemplate *apiTypes.LaunchTemplateOptions) (*ec2.LaunchTemplate, bool) {
-	// ensure name and ebs volume does not change
-	newLaunchTemplate.Name = *launchTemplateVersion.LaunchTemplateName
-	newLaunchTemplate.EbsVolume.VolumeType = *launchTemplateVersion.LaunchTemplateData.BlockDeviceMappings[0].Ebs.VolumeType
-	newLaunchTemplate.EbsVolume.VolumeSize = *launchTemplateVersion.LaunchTemplateData.BlockDeviceMappings[0].Ebs.VolumeSize
-	newLaunchTemplate.SecurityGroups = launchTemplateVersion.LaunchTemplateData.SecurityGroupIds
-
-	//encode userdata
-	newLaunchTemplate.UserData = base64.StdEncoding.EncodeToString([]byte(newLaunchTemplate.UserData))
+	// ensure name, ebs volume and security groups do not change, the configured ones stand in for the ones the
+	// template lacks
+	data := launchTemplateVersion.LaunchTemplateData
+	newLaunchTemplate.Name = aws.StringValue(launchTemplateVersion.LaunchTemplateName)
+	if len(data.BlockDeviceMappings) > 0 && data.BlockDeviceMappings[0].Ebs != nil {
+		newLaunchTemplate.EbsVolume.VolumeType = aws.StringValue(data.BlockDeviceMappings[0].Ebs.VolumeType)
+		newLaunchTemplate.EbsVolume.VolumeSize = aws.Int64Value(data.BlockDeviceMappings[0].Ebs.VolumeSize)
+	}
+	if len(data.SecurityGroupIds) > 0 {
+		newLaunchTemplate.SecurityGroups = data.SecurityGroupIds
+	} else if len(data.NetworkInterfaces) > 0 && len(data.NetworkInterfaces[0].Groups) > 0 {
+		newLaunchTemplate.SecurityGroups = data.NetworkInterfaces[0].Groups
+	}
 
-	log.Println("Launch template has changed: ", newLaunchTemplate.Name)
+	r.logger().WithField(LaunchTemplateField, newLaunchTemplate.Name).Info("Launch template has changed")
 	updated, err := r.Ec2Service.UpdateLaunchTemplate(newLaunchTemplate)
 
 	if err != nil {
-		log.Println("Failed to update launch template.", err, newLaunchTemplate.Name)
+		withAwsError(r.logger(), err).WithField(LaunchTemplateField, newLaunchTemplate.Name).Error("Failed to update launch template")
 		return updated, false
 	}
 
-	log.Printf("Launch template: %v has been update to version: %v. \n", *updated.LaunchTemplateName, *updated.LatestVersionNumber)
+	r.logger().WithFields(logrus.Fields{LaunchTemplateField: *updated.LaunchTemplateName, VersionField: *updated.LatestVersionNumber}).Info("Launch template has been updated")
 	return updated, true
 }
 
 //ReconcileAutoScalingGroup represents
-func (r *ReconcilerService) ReconcileAutoScalingGroup(asgInstance *apiTypes.AutoScalingGroupOptions, templateName *string, templateVersion *string) (*autoscaling.Group, bool) {
-	asgInstance.Name = "OperatorGenerated-" + asgInstance.Name
-	asgInstance.LaunchTemplateName = *templateName
+func (r *ReconcilerService) ReconcileAutoScalingGroup(model *apiTypes.OperatorModel, templateName *string, templateVersion *string) (*autoscaling.Group, bool) {
+	asgInstance, err := r.desiredAsg(model, *templateName, *templateVersion)
+	if err != nil {
+		r.logger().WithField("versionPolicy", model.VersionPolicy).Error("Unknown version policy, expected pinned or default")
+		return nil, false
+	}
 
-	asg := r.AsgService.GetAutoScalingGroup(asgInstance.Name)
+	asg, err := r.findOwnedAsg(model, asgInstance.Name)
+	if err != nil {
+		withAwsError(r.logger(), err).WithField(AsgField, asgInstance.Name).Error("Failed to look up ASG")
+		return nil, false
+	}
 
-	if asg != nil {
-		log.Println("Asg already exists: ", *asg.AutoScalingGroupName)
+	if asg == nil {
+		// a blue/green cutover interrupted after deleting blue leaves only the candidate
+		if candidate, _ := r.findCandidateAsg(model); candidate != nil {
+			r.logger().WithField(AsgField, *candidate.AutoScalingGroupName).Info("Completing interrupted cutover to ASG")
+			if err := r.AsgService.DeleteAsgTags(candidate.AutoScalingGroupName, []string{CandidateTagKey}); err != nil {
+				return nil, false
+			}
+			asg = r.AsgService.GetAutoScalingGroup(*candidate.AutoScalingGroupName)
+		}
+	}
 
-		changed, err := r.AsgService.CompareAsg(asgInstance, asg)
-		if err != nil {
-			log.Println("Failed to check if ASG has changed.", err, asg.AutoScalingGroupName)
-			return nil, false
+	if asg != nil {
+		asgInstance.Name = *asg.AutoScalingGroupName
+		logger := r.logger().WithField(AsgField, *asg.AutoScalingGroupName)
+		logger.Debug("Asg already exists")
+
+		desired := asgInstance
+		blueGreen := model.Strategy == "blue-green"
+		if blueGreen && asg.LaunchTemplate != nil {
+			// the live asg keeps its version, new versions are rolled out to a new asg
+			asgInstance.LaunchTemplateVersion = aws.StringValue(asg.LaunchTemplate.Version)
+		}
+		if color := asgTagValue(asg, ColorTagKey); color != "" {
+			asgInstance.Tags[ColorTagKey] = color
 		}
 
-		if changed {
-			log.Println("ASG has changed: ", *asg.AutoScalingGroupName)
-			_, err := r.AsgService.UpdateAsg(asgInstance)
+		drift := r.AsgService.AsgDrift(&asgInstance, asg)
+		recordDrift(model.NodeGroupName(), "autoScalingGroup", drift)
+
+		if len(drift) > 0 {
+			logger.WithField("drift", drift).Info("ASG has changed")
+			_, err := r.AsgService.UpdateAsg(&asgInstance)
 
 			if err != nil {
-				log.Println("Failed to update ASG.", err, asg.AutoScalingGroupName)
+				withAwsError(logger, err).Error("Failed to update ASG")
 				return asg, false
 			}
 
 			asg = r.AsgService.GetAutoScalingGroup(asgInstance.Name)
-			log.Println("ASG updated: ", *asg.AutoScalingGroupName)
+			logger.Info("ASG updated")
 
 			// check if the changes has been applied
-			r.AsgStatusMonitor(asg.AutoScalingGroupName)
+			if err := r.AsgStatusMonitor(asg.AutoScalingGroupName, 0); err != nil {
+				logger.WithError(err).Error("ASG did not become healthy")
+				return asg, false
+			}
 
-			return asg, true
+			asg = r.AsgService.GetAutoScalingGroup(asgInstance.Name)
 		}
 
-		// check launch template version number for all instances is insync, if not, detach
-		staleInstances := make([]*autoscaling.Instance, 0)
-		log.Println("Total instances in the asg: ", len(asg.Instances))
-		for _, v := range asg.Instances {
-			if *v.LaunchTemplate.Version != *templateVersion {
-				log.Printf("Stale instance: '%v', required-'%v' vs current-'%v'", *v.InstanceId, templateVersion, *v.LaunchTemplate.Version)
-				staleInstances = append(staleInstances, v)
-			}
+		if blueGreen {
+			return r.reconcileBlueGreen(model, asg, desired, *templateVersion)
 		}
 
-		if len(staleInstances) > 0 {
-			log.Println("Stale Instances found in the ASG: ", *asg.AutoScalingGroupName, len(staleInstances))
-			for _, v := range staleInstances {
-				detached := r.AsgService.DetachInstance(v.InstanceId, asg.AutoScalingGroupName)
-				if detached {
-					r.AsgStatusMonitor(asg.AutoScalingGroupName)
+		// check launch template version number for all instances is insync, if not, replace them
+		replaced, err := r.RollingReplace(model, asg, *templateVersion)
+		if err != nil {
+			logger.WithError(err).Error("Rollout failed")
+			r.Notifications.Send(apiTypes.NotificationEvent{
+				Type:     apiTypes.RolloutFailed,
+				Message:  "Rollout failed",
+				Asg:      asgInstance.Name,
+				Version:  *templateVersion,
+				Replaced: replaced,
+				Error:    err.Error(),
+			})
+			// failed canary health gates always roll back, the canaries are the only instances on the new version
+			if _, gateFailed := err.(*HealthGateError); !gateFailed && !model.AutoRollback {
+				return asg, false
+			}
 
-					_ = r.Ec2Service.ShutDownInstance(v.InstanceId)
-					_ = r.Ec2Service.TerminateInstance(v.InstanceId)
-				}
+			logger.WithField(VersionField, *templateVersion).Warn("Rolling back launch template version")
+			if rollbackErr := r.Rollback(model, ""); rollbackErr != nil {
+				logger.WithError(rollbackErr).Error("Rollback failed")
+			}
+			return asg, false
+		}
+
+		if replaced > 0 {
+			// the rollout is done, old versions are no longer needed
+			if _, err := r.CollectLaunchTemplateVersions(model, false); err != nil {
+				logger.WithError(err).Error("Failed to garbage collect launch template versions")
 			}
-		} else {
-			log.Printf("Stale Instances found in the ASG: '%v' - %v ", *asg.AutoScalingGroupName, len(staleInstances))
 		}
 
 		return asg, true
 	}
 
-	log.Println("Asg does not exist: ", asgInstance.Name)
-	_, asgErr := r.AsgService.CreateAsg(asgInstance)
+	logger := r.logger().WithField(AsgField, asgInstance.Name)
+	logger.Info("Asg does not exist, creating it")
+	_, asgErr := r.AsgService.CreateAsg(&asgInstance)
 	if asgErr != nil {
-		log.Println("Failed to create asg", asgErr)
+		withAwsError(logger, asgErr).Error("Failed to create asg")
 		return nil, false
 	}
 
-	r.AsgStatusMonitor(&asgInstance.Name)
+	if err := r.AsgStatusMonitor(&asgInstance.Name, 0); err != nil {
+		logger.WithError(err).Error("ASG did not become healthy")
+		return nil, false
+	}
 
 	return nil, true
 }
 
-//AsgStatusMonitor represents
-func (r *ReconcilerService) AsgStatusMonitor(asgName *string) {
-	asg := r.AsgService.GetAutoScalingGroup(*asgName)
+//CollectLaunchTemplateVersions represents deleting the launch template versions outside the retention policy.
+//The last retainVersions versions, the default version and any version still used by an instance or the asg are kept.
+func (r *ReconcilerService) CollectLaunchTemplateVersions(model *apiTypes.OperatorModel, dryRun bool) ([]string, error) {
+	launchTemplate, err := r.findOwnedLaunchTemplate(model, r.ResourceName(model, model.LaunchTemplateOptions.Name))
+	if err != nil {
+		return nil, err
+	}
+
+	if launchTemplate == nil {
+		r.logger().Info("Launch template does not exist, nothing to collect")
+		return nil, nil
+	}
+
+	versions, err := r.Ec2Service.GetLaunchTemplateVersions(launchTemplate.LaunchTemplateName)
+	if err != nil {
+		return nil, err
+	}
+
+	referenced, err := r.Ec2Service.GetReferencedLaunchTemplateVersions(launchTemplate.LaunchTemplateId)
+	if err != nil {
+		return nil, err
+	}
+
+	if asg, _ := r.findOwnedAsg(model, r.ResourceName(model, model.AutoScalingGroupOptions.Name)); asg != nil && asg.LaunchTemplate != nil {
+		referenced[aws.StringValue(asg.LaunchTemplate.Version)] = true
+	}
+
+	retain := int64(model.RetainVersions)
+	if retain <= 0 {
+		retain = defaultRetainVersions
+	}
+
+	deletable := []string{}
+	for _, v := range versions {
+		version := strconv.FormatInt(*v.VersionNumber, 10)
+		switch {
+		case *v.VersionNumber > *launchTemplate.LatestVersionNumber-retain:
+			// within the most recent versions
+		case *v.VersionNumber == *launchTemplate.DefaultVersionNumber:
+			// the default version can not be deleted
+		case referenced[version]:
+			r.logger().WithFields(logrus.Fields{LaunchTemplateField: *launchTemplate.LaunchTemplateName, VersionField: version}).Info("Keeping launch template version, still used by instances")
+		default:
+			deletable = append(deletable, version)
+		}
+	}
+
+	if len(deletable) == 0 || dryRun {
+		r.logger().WithFields(logrus.Fields{LaunchTemplateField: *launchTemplate.LaunchTemplateName, "versions": len(versions), "deletable": len(deletable)}).Info("Collected launch template versions")
+		return deletable, nil
+	}
+
+	if err := r.Ec2Service.DeleteLaunchTemplateVersions(launchTemplate.LaunchTemplateName, deletable); err != nil {
+		return nil, err
+	}
+
+	r.logger().WithFields(logrus.Fields{LaunchTemplateField: *launchTemplate.LaunchTemplateName, "deleted": len(deletable)}).Info("Deleted launch template versions")
+	return deletable, nil
+}
+
+// desiredLaunchTemplate returns the launch template options of the node group with its generated name and tags
+func (r *ReconcilerService) desiredLaunchTemplate(model *apiTypes.OperatorModel) apiTypes.LaunchTemplateOptions {
+	launchTemplate := model.LaunchTemplateOptions
+	launchTemplate.Name = r.ResourceName(model, model.LaunchTemplateOptions.Name)
+	launchTemplate.ResourceTags = r.OwnershipTags(model)
+	launchTemplate.ResourceTags[ConfigHashTagKey] = r.ConfigHash(model)
+
+	return launchTemplate
+}
+
+// desiredAsg returns the asg options of the node group with its generated name and tags, referencing the launch
+// template version the version policy selects
+func (r *ReconcilerService) desiredAsg(model *apiTypes.OperatorModel, templateName string, templateVersion string) (apiTypes.AutoScalingGroupOptions, error) {
+	asg := model.AutoScalingGroupOptions
+	asg.Name = r.ResourceName(model, model.AutoScalingGroupOptions.Name)
+	asg.LaunchTemplateName = templateName
+	switch model.VersionPolicy {
+	case "", "pinned":
+		asg.LaunchTemplateVersion = templateVersion
+	case "default":
+		asg.LaunchTemplateVersion = "$Default"
+	default:
+		return asg, fmt.Errorf("unknown version policy %v", model.VersionPolicy)
+	}
+	asg.Tags = r.OwnershipTags(model)
+	asg.Tags[ConfigHashTagKey] = r.ConfigHash(model)
+	for k, v := range model.AutoScalingGroupOptions.Tags {
+		asg.Tags[k] = v
+	}
+
+	return asg, nil
+}
+
+//ResourceName represents the name for a new asg or launch template rendered from the naming template
+func (r *ReconcilerService) ResourceName(model *apiTypes.OperatorModel, group string) string {
+	template := model.NamingOptions.Template
+	if template == "" {
+		template = defaultNamingTemplate
+	}
+
+	return strings.NewReplacer("{cluster}", model.ClusterName, "{group}", group).Replace(template)
+}
+
+//OwnershipTags represents the tags identifying the resources of a node group
+func (r *ReconcilerService) OwnershipTags(model *apiTypes.OperatorModel) map[string]string {
+	managerID := model.NamingOptions.ManagerID
+	if managerID == "" {
+		managerID = defaultManagerID
+	}
+
+	return map[string]string{
+		ManagerIDTagKey: managerID,
+		ClusterTagKey:   model.ClusterName,
+		NodeGroupTagKey: model.NodeGroupName(),
+	}
+}
+
+//ConfigHash represents a short digest of the node group config, used to see which config a resource was last reconciled with
+func (r *ReconcilerService) ConfigHash(model *apiTypes.OperatorModel) string {
+	config, _ := yaml.Marshal(model)
+	sum := sha256.Sum256(config)
+	return hex.EncodeToString(sum[:])[:16]
+}
+
+// findOwnedLaunchTemplate looks up the launch template by ownership tags. Untagged templates with the
+// generated name are adopted, templates owned by another manager, cluster or node group are an error.
+func (r *ReconcilerService) findOwnedLaunchTemplate(model *apiTypes.OperatorModel, name string) (*ec2.LaunchTemplate, error) {
+	owned := r.Ec2Service.FindLaunchTemplates(r.OwnershipTags(model))
+	if len(owned) > 1 {
+		return nil, fmt.Errorf("found %v launch templates owned by node group %v", len(owned), model.NodeGroupName())
+	}
+
+	if len(owned) == 1 {
+		return owned[0], nil
+	}
+
+	launchTemplate := r.Ec2Service.GetLaunchTemplate(name)
+	if launchTemplate == nil {
+		return nil, nil
+	}
+
+	for _, v := range launchTemplate.Tags {
+		if *v.Key == ManagerIDTagKey || *v.Key == ClusterTagKey || *v.Key == NodeGroupTagKey {
+			return nil, fmt.Errorf("launch template %v is owned by another node group", name)
+		}
+	}
+
+	r.logger().WithField(LaunchTemplateField, name).Info("Adopting untagged launch template")
+	return launchTemplate, nil
+}
+
+// findOwnedAsg looks up the live asg by ownership tags, adopting an untagged asg with the generated name.
+// A blue/green candidate is not the live asg.
+func (r *ReconcilerService) findOwnedAsg(model *apiTypes.OperatorModel, name string) (*autoscaling.Group, error) {
+	owned := []*autoscaling.Group{}
+	for _, v := range r.AsgService.FindAutoScalingGroups(r.OwnershipTags(model)) {
+		if !hasAsgTag(v, CandidateTagKey) {
+			owned = append(owned, v)
+		}
+	}
+
+	if len(owned) > 1 {
+		return nil, fmt.Errorf("found %v asgs owned by node group %v", len(owned), model.NodeGroupName())
+	}
+
+	if len(owned) == 1 {
+		return owned[0], nil
+	}
+
+	asg := r.AsgService.GetAutoScalingGroup(name)
+	if asg == nil {
+		return nil, nil
+	}
+
+	for _, v := range asg.Tags {
+		if *v.Key == ManagerIDTagKey || *v.Key == ClusterTagKey || *v.Key == NodeGroupTagKey {
+			return nil, fmt.Errorf("asg %v is owned by another node group", name)
+		}
+	}
+
+	r.logger().WithField(AsgField, name).Info("Adopting untagged ASG")
+	return asg, nil
+}
+
+func hasAsgTag(asg *autoscaling.Group, key string) bool {
+	return asgTagValue(asg, key) != ""
+}
+
+func asgTagValue(asg *autoscaling.Group, key string) string {
+	for _, v := range asg.Tags {
+		if *v.Key == key {
+			return *v.Value
+		}
+	}
+
+	return ""
+}
+
+func hasEc2Tags(current []*ec2.Tag, tags map[string]string) bool {
+	currentTags := make(map[string]string)
+	for _, v := range current {
+		currentTags[*v.Key] = *v.Value
+	}
+
+	for k, v := range tags {
+		if value, ok := currentTags[k]; !ok || value != v {
+			return false
+		}
+	}
+
+	return true
+}
+
+//ImportAutoScalingGroup represents adopting an existing asg and its launch template.
+//The returned config reproduces the current settings and both resources are tagged with the ownership tags so
+//subsequent reconciles update them in place.
+func (r *ReconcilerService) ImportAutoScalingGroup(asgName string, clusterName string, managerID string) (*apiTypes.OperatorModel, error) {
+	asg := r.AsgService.GetAutoScalingGroup(asgName)
+	if asg == nil {
+		return nil, fmt.Errorf("asg %v does not exist", asgName)
+	}
+
+	spec := asg.LaunchTemplate
+	if spec == nil && asg.MixedInstancesPolicy != nil && asg.MixedInstancesPolicy.LaunchTemplate != nil {
+		spec = asg.MixedInstancesPolicy.LaunchTemplate.LaunchTemplateSpecification
+	}
+
+	if spec == nil || spec.LaunchTemplateName == nil {
+		return nil, fmt.Errorf("asg %v does not use a launch template, launch configurations can not be imported", asgName)
+	}
+
+	version := aws.StringValue(spec.Version)
+	if version == "" {
+		version = "$Default"
+	}
+
+	templateVersion, err := r.Ec2Service.GetLaunchTemplateVersion(spec.LaunchTemplateName, &version)
+	if err != nil {
+		return nil, err
+	}
+	if templateVersion == nil {
+		return nil, fmt.Errorf("launch template %v version %v does not exist", *spec.LaunchTemplateName, version)
+	}
+	r.logger().WithFields(logrus.Fields{AsgField: asgName, LaunchTemplateField: *spec.LaunchTemplateName, VersionField: *templateVersion.VersionNumber}).Info("Importing asg")
+
+	model := &apiTypes.OperatorModel{ClusterName: clusterName}
+	model.NamingOptions.ManagerID = managerID
+	model.Ec2Options.LaunchTemplateOptions = importLaunchTemplateData(*spec.LaunchTemplateName, templateVersion.LaunchTemplateData)
+	model.AutoScalingGroupOptions = apiTypes.AutoScalingGroupOptions{
+		Name:             asgName,
+		Subnets:          aws.StringValue(asg.VPCZoneIdentifier),
+		DesiredInstances: *asg.DesiredCapacity,
+		MaxInstances:     *asg.MaxSize,
+		MinInstances:     *asg.MinSize,
+		Tags:             make(map[string]string),
+	}
+
+	for _, v := range asg.Tags {
+		if !strings.HasPrefix(*v.Key, "aws:") && !strings.HasPrefix(*v.Key, "aws-node-group-manager/") {
+			model.AutoScalingGroupOptions.Tags[*v.Key] = *v.Value
+		}
+	}
+
+	managed := r.OwnershipTags(model)
+	managed[ConfigHashTagKey] = r.ConfigHash(model)
+	if err := r.lockLost(); err != nil {
+		return nil, err
+	}
+	if err := r.Ec2Service.TagResources([]*string{templateVersion.LaunchTemplateId}, managed); err != nil {
+		return nil, err
+	}
+
+	if err := r.AsgService.TagAutoScalingGroup(asgName, managed); err != nil {
+		return nil, err
+	}
+
+	return model, nil
+}
+
+func importLaunchTemplateData(name string, data *ec2.ResponseLaunchTemplateData) apiTypes.LaunchTemplateOptions {
+	options := apiTypes.LaunchTemplateOptions{
+		Name:           name,
+		InstanceType:   aws.StringValue(data.InstanceType),
+		KeyName:        aws.StringValue(data.KeyName),
+		SecurityGroups: data.SecurityGroupIds,
+	}
+
+	if len(data.NetworkInterfaces) > 0 {
+		options.PublicIps = aws.BoolValue(data.NetworkInterfaces[0].AssociatePublicIpAddress)
+		if len(options.SecurityGroups) == 0 {
+			options.SecurityGroups = data.NetworkInterfaces[0].Groups
+		}
+	}
+
+	options.IamInstanceProfile = launchTemplateInstanceProfile(data.IamInstanceProfile)
+
+	if data.UserData != nil {
+		userData, _ := base64.StdEncoding.DecodeString(*data.UserData)
+		options.UserData = string(userData)
+	}
+
+	options.Tags = launchTemplateInstanceTags(data)
+
+	if len(data.BlockDeviceMappings) > 0 && data.BlockDeviceMappings[0].Ebs != nil {
+		options.VolumeType = aws.StringValue(data.BlockDeviceMappings[0].Ebs.VolumeType)
+		options.VolumeSize = aws.Int64Value(data.BlockDeviceMappings[0].Ebs.VolumeSize)
+	}
+
+	return options
+}
+
+//AsgStatusMonitor represents waiting for the asg to reach its desired capacity with all instances healthy and in service.
+//A timeout of zero waits indefinitely, an instance reported unhealthy fails the wait.
+func (r *ReconcilerService) AsgStatusMonitor(asgName *string, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	expired := func() bool {
+		return timeout > 0 && time.Now().After(deadline)
+	}
+
+	logger := r.logger().WithField(AsgField, *asgName)
+	backoff := NewPollBackoff(2*time.Second, 30*time.Second)
+	asg := r.AsgService.RefreshAutoScalingGroup(*asgName)
 	for {
 		if asg == nil {
-			log.Println("Awaiting ASG to come up")
-			time.Sleep(time.Second * 10)
-			asg = r.AsgService.GetAutoScalingGroup(*asgName)
+			if expired() {
+				return fmt.Errorf("timed out after %v waiting for asg %v to come up", timeout, *asgName)
+			}
+			logger.Info("Awaiting ASG to come up")
+			backoff.Wait()
+			asg = r.AsgService.RefreshAutoScalingGroup(*asgName)
 		} else {
 			break
 		}
 	}
 
-	if asg == nil {
-		return
-	}
-
+	backoff.Reset()
 	for {
-		if *asg.DesiredCapacity == int64(len(asg.Instances)) {
-			log.Printf("Desired Capacity matched Current instances: Desired - %v == Current - %v \n", *asg.DesiredCapacity, len(asg.Instances))
+		if asg != nil && *asg.DesiredCapacity == int64(len(asg.Instances)) {
+			logger.WithFields(logrus.Fields{"desired": *asg.DesiredCapacity, "current": len(asg.Instances)}).Info("Desired capacity matched current instances")
 			break
 		} else {
-			log.Printf("Waiting for Desired Capacity matched Current instances: Desired - %v == Current - %v ... \n", *asg.DesiredCapacity, len(asg.Instances))
-			time.Sleep(2 * time.Second)
-			asg = r.AsgService.GetAutoScalingGroup(*asgName)
+			if expired() {
+				return fmt.Errorf("timed out after %v waiting for asg %v to reach desired capacity", timeout, *asgName)
+			}
+			if asg != nil {
+				logger.WithFields(logrus.Fields{"desired": *asg.DesiredCapacity, "current": len(asg.Instances)}).Info("Waiting for desired capacity to match current instances")
+			}
+			backoff.Wait()
+			asg = r.AsgService.RefreshAutoScalingGroup(*asgName)
 		}
 	}
 
+	backoff.Reset()
 	for {
-		completed := true
-		for _, v := range asg.Instances {
-			if *v.HealthStatus != "Healthy" || *v.LifecycleState != "InService" {
-				completed = false
+		completed := asg != nil
+		if asg != nil {
+			for _, v := range asg.Instances {
+				if *v.HealthStatus == "Unhealthy" {
+					return fmt.Errorf("instance %v in asg %v is unhealthy", *v.InstanceId, *asgName)
+				}
+				if *v.HealthStatus != "Healthy" || *v.LifecycleState != "InService" {
+					completed = false
+				}
 			}
 		}
 
 		if completed {
-			log.Println("All done.")
-			break
+			logger.Info("All instances are healthy and in service")
+			return nil
 		} else {
-			asg = r.AsgService.GetAutoScalingGroup(*asgName)
-			log.Println("Awaiting all instances to be healthy...")
-			time.Sleep(5 * time.Second)
+			if expired() {
+				return fmt.Errorf("timed out after %v waiting for instances in asg %v to be healthy", timeout, *asgName)
+			}
+			asg = r.AsgService.RefreshAutoScalingGroup(*asgName)
+			logger.Info("Awaiting all instances to be healthy")
+			backoff.Wait()
 		}
 	}
 }
 
-//GetLatestEksAmi represents
-func (r *ReconcilerService) GetLatestEksAmi(k8sVersion *string) *string {
-	ami, err := r.SsmService.GetEksOptimizedAmi(*k8sVersion)
+//GetLatestEksAmi represents the recommended eks ami of the kubernetes version
+func (r *ReconcilerService) GetLatestEksAmi(k8sVersion string) (string, error) {
+	ami, err := r.SsmService.GetEksOptimizedAmi(k8sVersion)
 	if err != nil {
-		log.Println(err)
-		return nil
+		return "", fmt.Errorf("failed to get the recommended eks ami for kubernetes %v: %v", k8sVersion, err)
+	}
+	r.logger().WithFields(logrus.Fields{"ami": ami.ImageID, "amiName": ami.ImageName}).Info("Using recommended eks ami")
+	return ami.ImageID, nil
+}
+
+//ResolveNetworking represents resolving the security group and subnet selectors to ids.
+//All resolved resources must belong to the configured vpc, or to the vpc of the first subnet when none is set.
+func (r *ReconcilerService) ResolveNetworking(model *apiTypes.OperatorModel) error {
+	sgSelectors := model.SecurityGroupSelectors
+	for _, v := range model.SecurityGroups {
+		sgSelectors = append(sgSelectors, apiTypes.ResourceSelector{ID: *v})
+	}
+
+	subnetSelectors := model.SubnetSelectors
+	for _, v := range strings.Split(model.Subnets, ",") {
+		if id := strings.TrimSpace(v); id != "" {
+			subnetSelectors = append(subnetSelectors, apiTypes.ResourceSelector{ID: id})
+		}
+	}
+
+	if len(sgSelectors) == 0 {
+		return fmt.Errorf("no security groups configured")
 	}
-	log.Println("AWS AMI: ", ami.ImageName, ami.ImageID)
-	return &ami.ImageID
+
+	if len(subnetSelectors) == 0 {
+		return fmt.Errorf("no subnets configured")
+	}
+
+	for _, v := range append(sgSelectors, subnetSelectors...) {
+		if v.ID == "" && v.Name == "" && len(v.Tags) == 0 {
+			return fmt.Errorf("empty selector, one of id, name or tags is required")
+		}
+	}
+
+	subnets, err := r.Ec2Service.ResolveSubnets(subnetSelectors)
+	if err != nil {
+		return err
+	}
+
+	vpcID := model.VpcID
+	if vpcID == "" {
+		vpcID = *subnets[0].VpcId
+	}
+
+	subnetIds := []string{}
+	for _, v := range subnets {
+		if *v.VpcId != vpcID {
+			return fmt.Errorf("subnet %v is in vpc %v, expected %v", *v.SubnetId, *v.VpcId, vpcID)
+		}
+		subnetIds = append(subnetIds, *v.SubnetId)
+	}
+
+	groups, err := r.Ec2Service.ResolveSecurityGroups(sgSelectors)
+	if err != nil {
+		return err
+	}
+
+	groupIds := []*string{}
+	for _, v := range groups {
+		if *v.VpcId != vpcID {
+			return fmt.Errorf("security group %v is in vpc %v, expected %v", *v.GroupId, *v.VpcId, vpcID)
+		}
+		groupIds = append(groupIds, v.GroupId)
+	}
+
+	model.Subnets = strings.Join(subnetIds, ",")
+	model.SecurityGroups = groupIds
+	r.logger().WithFields(logrus.Fields{"vpc": vpcID, "subnets": model.Subnets, "securityGroups": aws.StringValueSlice(groupIds)}).Info("Resolved networking")
+
+	return nil
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+
+	return false
 }
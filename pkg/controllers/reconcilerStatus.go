@@ -0,0 +1,130 @@
+package controllers
+
+import (
+	"sort"
+	"strconv"
+
+	apiTypes "github.com/anyo/aws-node-group-manager/pkg/apis"
+
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+//NodeGroupStatus represents describing the node group: its config and the recommended ami, the asg and launch template
+//running in aws, the version each instance runs, the rollout and the scaling activities in progress
+func (r *ReconcilerService) NodeGroupStatus(model *apiTypes.OperatorModel, k8sVersion string) (*apiTypes.NodeGroupStatus, error) {
+	status := apiTypes.NodeGroupStatus{
+		NodeGroup: model.NodeGroupName(),
+		Desired: apiTypes.NodeGroupSpec{
+			AsgName:          r.ResourceName(model, model.AutoScalingGroupOptions.Name),
+			LaunchTemplate:   r.ResourceName(model, model.LaunchTemplateOptions.Name),
+			MinInstances:     model.MinInstances,
+			MaxInstances:     model.MaxInstances,
+			DesiredInstances: model.DesiredInstances,
+			InstanceType:     model.LaunchTemplateOptions.InstanceType,
+		},
+		Instances:      []apiTypes.InstanceState{},
+		InstanceGroups: []apiTypes.InstanceGroup{},
+		Activities:     []apiTypes.ScalingActivity{},
+		Paused:         r.Controls.Paused(model.NodeGroupName()),
+		PendingAmi:     r.Controls.PendingAmi(model.NodeGroupName()),
+	}
+
+	ami, err := r.SsmService.GetEksOptimizedAmi(k8sVersion)
+	if err != nil {
+		return nil, err
+	}
+	status.Desired.AmiID = ami.ImageID
+
+	rollout, err := r.stateStore().Load(model.NodeGroupKey())
+	if err != nil {
+		return nil, err
+	}
+	status.Rollout = rollout
+
+	asg, err := r.findOwnedAsg(model, status.Desired.AsgName)
+	if err != nil {
+		return nil, err
+	}
+	if asg == nil {
+		return &status, nil
+	}
+
+	actual := apiTypes.NodeGroupSpec{
+		AsgName:          *asg.AutoScalingGroupName,
+		MinInstances:     aws.Int64Value(asg.MinSize),
+		MaxInstances:     aws.Int64Value(asg.MaxSize),
+		DesiredInstances: aws.Int64Value(asg.DesiredCapacity),
+	}
+	status.Actual = &actual
+
+	launchTemplate, err := r.findOwnedLaunchTemplate(model, status.Desired.LaunchTemplate)
+	if err != nil {
+		return nil, err
+	}
+	if launchTemplate != nil {
+		actual.LaunchTemplate = *launchTemplate.LaunchTemplateName
+		actual.TargetVersion = strconv.FormatInt(*launchTemplate.DefaultVersionNumber, 10)
+		actual.LatestVersion = strconv.FormatInt(*launchTemplate.LatestVersionNumber, 10)
+
+		v, err := r.Ec2Service.GetLaunchTemplateVersion(launchTemplate.LaunchTemplateName, &actual.TargetVersion)
+		if err != nil {
+			return nil, err
+		}
+		if v != nil {
+			actual.InstanceType = aws.StringValue(v.LaunchTemplateData.InstanceType)
+			actual.AmiID = aws.StringValue(v.LaunchTemplateData.ImageId)
+		}
+	}
+
+	versions, err := r.instanceLaunchTemplateVersions(asg)
+	if err != nil {
+		return nil, err
+	}
+
+	groups := make(map[apiTypes.InstanceGroup]int)
+	for _, v := range asg.Instances {
+		instance := apiTypes.InstanceState{
+			InstanceID:     *v.InstanceId,
+			Version:        versions[*v.InstanceId],
+			LifecycleState: aws.StringValue(v.LifecycleState),
+			HealthStatus:   aws.StringValue(v.HealthStatus),
+			Stale:          actual.TargetVersion != "" && versions[*v.InstanceId] != actual.TargetVersion,
+		}
+		if instance.Stale {
+			status.StaleInstances++
+		}
+		status.Instances = append(status.Instances, instance)
+		groups[apiTypes.InstanceGroup{Version: instance.Version, LifecycleState: instance.LifecycleState, HealthStatus: instance.HealthStatus}]++
+	}
+	sort.Slice(status.Instances, func(i, j int) bool { return status.Instances[i].InstanceID < status.Instances[j].InstanceID })
+
+	for k, v := range groups {
+		k.Count = v
+		status.InstanceGroups = append(status.InstanceGroups, k)
+	}
+	sort.Slice(status.InstanceGroups, func(i, j int) bool {
+		a, b := status.InstanceGroups[i], status.InstanceGroups[j]
+		if a.Version != b.Version {
+			return a.Version < b.Version
+		}
+		if a.LifecycleState != b.LifecycleState {
+			return a.LifecycleState < b.LifecycleState
+		}
+		return a.HealthStatus < b.HealthStatus
+	})
+
+	activities, err := r.AsgService.GetInProgressActivities(*asg.AutoScalingGroupName)
+	if err != nil {
+		return nil, err
+	}
+	for _, v := range activities {
+		status.Activities = append(status.Activities, apiTypes.ScalingActivity{
+			Description: aws.StringValue(v.Description),
+			Status:      aws.StringValue(v.StatusCode),
+			Progress:    aws.Int64Value(v.Progress),
+			StartTime:   aws.TimeValue(v.StartTime),
+		})
+	}
+
+	return &status, nil
+}
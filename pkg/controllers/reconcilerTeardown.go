@@ -0,0 +1,91 @@
+package controllers
+
+import (
+	apiTypes "github.com/anyo/aws-node-group-manager/pkg/apis"
+
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+)
+
+//Teardown represents deleting everything the manager created for a node group: the live asg and any blue/green
+//candidate, once their nodes are drained, then the launch template and the rollout state. Deleting an asg takes
+//a while, teardown reports it is not done yet until the asgs are gone and is called again.
+func (r *ReconcilerService) Teardown(model *apiTypes.OperatorModel) (bool, error) {
+	asgs := []*autoscaling.Group{}
+
+	candidate, err := r.findCandidateAsg(model)
+	if err != nil {
+		return false, err
+	}
+	if candidate != nil {
+		asgs = append(asgs, candidate)
+	}
+
+	live, err := r.findOwnedAsg(model, r.ResourceName(model, model.AutoScalingGroupOptions.Name))
+	if err != nil {
+		return false, err
+	}
+	if live != nil {
+		asgs = append(asgs, live)
+	}
+
+	for _, v := range asgs {
+		// the status is only set while the asg is being deleted
+		if v.Status != nil {
+			r.logger().WithField(AsgField, *v.AutoScalingGroupName).Info("Waiting for ASG deletion")
+			continue
+		}
+
+		if err := r.lockLost(); err != nil {
+			return false, err
+		}
+		r.drainAsg(v)
+		r.logger().WithField(AsgField, *v.AutoScalingGroupName).Info("Deleting ASG")
+		if err := r.AsgService.DeleteAsg(v.AutoScalingGroupName); err != nil {
+			return false, err
+		}
+	}
+	if len(asgs) > 0 {
+		return false, nil
+	}
+
+	launchTemplate, err := r.findOwnedLaunchTemplate(model, r.ResourceName(model, model.LaunchTemplateOptions.Name))
+	if err != nil {
+		return false, err
+	}
+	if launchTemplate != nil {
+		if err := r.lockLost(); err != nil {
+			return false, err
+		}
+		r.logger().WithField(LaunchTemplateField, *launchTemplate.LaunchTemplateName).Info("Deleting launch template")
+		if err := r.Ec2Service.DeleteLaunchTemplate(launchTemplate.LaunchTemplateName); err != nil {
+			return false, err
+		}
+	}
+
+	if err := r.stateStore().Delete(model.NodeGroupKey()); err != nil {
+		return false, err
+	}
+
+	r.logger().Info("Node group torn down")
+	return true, nil
+}
+
+// drainAsg cordons and drains the nodes of the asg before it is deleted. Failures are logged, the instances are
+// terminated either way.
+func (r *ReconcilerService) drainAsg(asg *autoscaling.Group) {
+	nodeNames, err := r.asgNodeNames(asg)
+	if err != nil {
+		withAwsError(r.logger(), err).WithField(AsgField, *asg.AutoScalingGroupName).Warn("Failed to look up the nodes of ASG, deleting it without draining")
+		return
+	}
+
+	for _, v := range nodeNames {
+		if err := r.KubeService.CordonNode(v); err != nil {
+			r.logger().WithError(err).WithField("node", v).Warn("Failed to cordon node")
+			continue
+		}
+		if err := r.KubeService.DrainNode(v, defaultDrainTimeout); err != nil {
+			r.logger().WithError(err).WithField("node", v).Warn("Failed to drain node")
+		}
+	}
+}
@@ -0,0 +1,172 @@
+package controllers
+
+import (
+	"math"
+	"math/rand"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/sirupsen/logrus"
+)
+
+// error codes signalling the account is over its request rate, retried with a longer delay
+var throttleErrorCodes = map[string]bool{
+	"Throttling":                             true,
+	"ThrottlingException":                    true,
+	"ThrottledException":                     true,
+	"RequestThrottled":                       true,
+	"RequestThrottledException":              true,
+	"RequestLimitExceeded":                   true,
+	"TooManyRequestsException":               true,
+	"ProvisionedThroughputExceededException": true,
+	"TransactionInProgressException":         true,
+	"EC2ThrottledException":                  true,
+	"PriorRequestNotComplete":                true,
+	"SlowDown":                               true,
+	"ResourceContention":                     true,
+}
+
+// error codes of transient service failures
+var transientErrorCodes = map[string]bool{
+	"InternalError":           true,
+	"InternalFailure":         true,
+	"InternalServerError":     true,
+	"ServiceUnavailable":      true,
+	"Unavailable":             true,
+	"RequestTimeout":          true,
+	"RequestTimeoutException": true,
+	"IDPCommunicationError":   true,
+	"EC2Unavailable":          true,
+}
+
+// jitterSource draws the jitter of the delays, its own source as the global one is not ours to seed. A source is not
+// safe for concurrent use, calls on every client share it.
+var jitterSource = struct {
+	sync.Mutex
+	*rand.Rand
+}{Rand: rand.New(rand.NewSource(time.Now().UnixNano()))}
+
+//RetryPolicy represents retrying failed aws calls with exponential backoff and jitter. Throttling errors and
+//transient service or connection errors are retried, any other error fails the call right away.
+type RetryPolicy struct {
+	// MaxAttempts includes the first attempt
+	MaxAttempts int
+	BaseDelay   time.Duration
+	// ThrottleBaseDelay is the base delay after a throttling error, higher so callers spread out
+	ThrottleBaseDelay time.Duration
+	MaxDelay          time.Duration
+}
+
+//DefaultRetryPolicy represents the policy shared by all aws services of the manager
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts:       8,
+	BaseDelay:         200 * time.Millisecond,
+	ThrottleBaseDelay: 1 * time.Second,
+	MaxDelay:          30 * time.Second,
+}
+
+//WithRetryPolicy represents setting the retry policy on the aws config every client of the session is created from
+func WithRetryPolicy(config *aws.Config, policy RetryPolicy) *aws.Config {
+	return request.WithRetryer(config, policy)
+}
+
+//MaxRetries represents
+func (r RetryPolicy) MaxRetries() int {
+	if r.MaxAttempts < 1 {
+		return 0
+	}
+
+	return r.MaxAttempts - 1
+}
+
+//ShouldRetry represents classifying the error of a failed request
+func (r RetryPolicy) ShouldRetry(req *request.Request) bool {
+	if req.Retryable != nil {
+		return *req.Retryable
+	}
+
+	retry := isThrottleError(req) || isTransientError(req)
+	if retry {
+		withAwsError(logrus.StandardLogger().WithFields(logrus.Fields{"service": req.ClientInfo.ServiceName, "operation": req.Operation.Name}), req.Error).
+			Warnf("Retrying after error, attempt %v of %v", req.RetryCount+1, r.MaxAttempts)
+	}
+
+	return retry
+}
+
+//RetryRules represents the delay before the next attempt, randomized below the exponentially growing cap
+func (r RetryPolicy) RetryRules(req *request.Request) time.Duration {
+	base := r.BaseDelay
+	if isThrottleError(req) {
+		base = r.ThrottleBaseDelay
+	}
+
+	return jitteredBackoff(base, r.MaxDelay, req.RetryCount)
+}
+
+func isThrottleError(req *request.Request) bool {
+	if req.HTTPResponse != nil && req.HTTPResponse.StatusCode == http.StatusTooManyRequests {
+		return true
+	}
+
+	aErr, ok := req.Error.(awserr.Error)
+	return ok && throttleErrorCodes[aErr.Code()]
+}
+
+func isTransientError(req *request.Request) bool {
+	if req.HTTPResponse != nil && req.HTTPResponse.StatusCode >= http.StatusInternalServerError && req.HTTPResponse.StatusCode != http.StatusNotImplemented {
+		return true
+	}
+
+	if aErr, ok := req.Error.(awserr.Error); ok && transientErrorCodes[aErr.Code()] {
+		return true
+	}
+
+	// connection resets, timeouts and the like
+	return request.IsErrorRetryable(req.Error)
+}
+
+// jitteredBackoff picks a random delay between half and all of base * 2^attempt, capped at max
+func jitteredBackoff(base time.Duration, max time.Duration, attempt int) time.Duration {
+	ceiling := float64(base) * math.Pow(2, float64(attempt))
+	if ceiling > float64(max) || math.IsInf(ceiling, 0) {
+		ceiling = float64(max)
+	}
+
+	jitterSource.Lock()
+	defer jitterSource.Unlock()
+	return time.Duration(jitterSource.Int63n(int64(ceiling)/2+1)) + time.Duration(ceiling/2)
+}
+
+//PollBackoff represents the delay between polls of a wait loop, growing from Initial up to Max
+type PollBackoff struct {
+	Initial time.Duration
+	Max     time.Duration
+	attempt int
+}
+
+//NewPollBackoff represents
+func NewPollBackoff(initial time.Duration, max time.Duration) *PollBackoff {
+	return &PollBackoff{Initial: initial, Max: max}
+}
+
+//Wait represents sleeping before the next poll, each wait longer than the last
+func (r *PollBackoff) Wait() {
+	time.Sleep(r.Next())
+}
+
+//Next represents the delay before the next poll
+func (r *PollBackoff) Next() time.Duration {
+	delay := jitteredBackoff(r.Initial, r.Max, r.attempt)
+	r.attempt++
+	return delay
+}
+
+//Reset represents starting over from the initial delay, after the awaited state made progress
+func (r *PollBackoff) Reset() {
+	r.attempt = 0
+}
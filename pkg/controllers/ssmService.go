@@ -2,18 +2,24 @@ package controllers
 
 import (
 	"encoding/json"
-	"log"
+	"fmt"
 
+	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/ssm"
+	"github.com/sirupsen/logrus"
 
 	apiTypes "github.com/anyo/aws-node-group-manager/pkg/apis"
 )
 
+// secretsManagerParameterPrefix references a Secrets Manager secret through parameter store
+const secretsManagerParameterPrefix = "/aws/reference/secretsmanager/"
+
 //SsmService represents ssm operations
 type SsmService struct {
 	AwsSession session.Session
 	Region     string
+	Log        *logrus.Entry
 }
 
 //GetEksOptimizedAmi represents getting the latest recomended Ami for Eks in this region
@@ -27,17 +33,14 @@ func (r *SsmService) GetEksOptimizedAmi(k8sVersion string) (apiTypes.SsmRecommen
 		Name: &paramName,
 	}
 	param, err := ssmSvc.GetParameter(&input)
-
 	if err != nil {
-		log.Fatal("Error while getting session", err)
 		return response, err
 	}
 
 	var recommended apiTypes.SsmRecommendedEksAmiValue
 	err = json.Unmarshal([]byte(*param.Parameter.Value), &recommended)
 	if err != nil {
-		log.Fatal("Failed to unmarshal the ami response", err)
-		return response, err
+		return response, fmt.Errorf("parameter %v is not a recommended ami: %v", paramName, err)
 	}
 
 	response = apiTypes.SsmRecommendedEksAmi{
@@ -48,3 +51,40 @@ func (r *SsmService) GetEksOptimizedAmi(k8sVersion string) (apiTypes.SsmRecommen
 
 	return response, nil
 }
+
+//GetParameter represents reading a parameter, SecureString parameters are decrypted. Parameters under the
+//secrets manager reference prefix read the secret from Secrets Manager.
+func (r *SsmService) GetParameter(name string) (string, error) {
+	ssmSvc := ssm.New(&r.AwsSession)
+
+	param, err := ssmSvc.GetParameter(&ssm.GetParameterInput{Name: aws.String(name), WithDecryption: aws.Bool(true)})
+	if err != nil {
+		return "", err
+	}
+
+	return aws.StringValue(param.Parameter.Value), nil
+}
+
+//GetSecret represents reading a Secrets Manager secret through parameter store. A key selects one field of a
+//secret holding a json object.
+func (r *SsmService) GetSecret(secretID string, key string) (string, error) {
+	value, err := r.GetParameter(secretsManagerParameterPrefix + secretID)
+	if err != nil || key == "" {
+		return value, err
+	}
+
+	fields := make(map[string]interface{})
+	if err := json.Unmarshal([]byte(value), &fields); err != nil {
+		return "", fmt.Errorf("secret %v is not a json object: %v", secretID, err)
+	}
+
+	field, ok := fields[key]
+	if !ok {
+		return "", fmt.Errorf("secret %v has no key %v", secretID, key)
+	}
+	if s, ok := field.(string); ok {
+		return s, nil
+	}
+
+	return fmt.Sprint(field), nil
+}
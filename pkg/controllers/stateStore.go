@@ -0,0 +1,254 @@
+package controllers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	apiTypes "github.com/anyo/aws-node-group-manager/pkg/apis"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/sirupsen/logrus"
+)
+
+const defaultStatePath = ".node-group-manager"
+
+//StateStore represents where the rollout state of node groups is persisted between runs
+type StateStore interface {
+	// Load returns nil without an error when the node group has no rollout in progress
+	Load(nodeGroup string) (*apiTypes.RolloutState, error)
+	Save(state *apiTypes.RolloutState) error
+	Delete(nodeGroup string) error
+}
+
+//NewStateStore represents creating the state store for the configured backend
+func NewStateStore(options apiTypes.StateOptions, awsSession session.Session) (StateStore, error) {
+	switch options.Backend {
+	case "", "file":
+		path := options.Path
+		if path == "" {
+			path = defaultStatePath
+		}
+		return &FileStateStore{Dir: path}, nil
+	case "s3":
+		if options.Bucket == "" {
+			return nil, fmt.Errorf("the s3 state backend requires a bucket")
+		}
+		return &S3StateStore{AwsSession: awsSession, Bucket: options.Bucket, Prefix: options.Prefix}, nil
+	case "dynamodb":
+		if options.Table == "" {
+			return nil, fmt.Errorf("the dynamodb state backend requires a table")
+		}
+		return &DynamoDBStateStore{AwsSession: awsSession, Table: options.Table}, nil
+	}
+
+	return nil, fmt.Errorf("unknown state backend %v, expected one of: file, s3, dynamodb", options.Backend)
+}
+
+//FileStateStore represents rollout state kept as one json file per node group in a local directory
+type FileStateStore struct {
+	Dir string
+}
+
+//Load represents
+func (r *FileStateStore) Load(nodeGroup string) (*apiTypes.RolloutState, error) {
+	content, err := ioutil.ReadFile(r.path(nodeGroup))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return decodeRolloutState(content)
+}
+
+//Save represents writing the state to a temporary file renamed over the previous state, so a crash never leaves a partial file
+func (r *FileStateStore) Save(state *apiTypes.RolloutState) error {
+	if err := os.MkdirAll(r.Dir, 0755); err != nil {
+		return err
+	}
+
+	content, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp := r.path(state.NodeGroup) + ".tmp"
+	if err := ioutil.WriteFile(tmp, content, 0644); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, r.path(state.NodeGroup))
+}
+
+//Delete represents
+func (r *FileStateStore) Delete(nodeGroup string) error {
+	err := os.Remove(r.path(nodeGroup))
+	if os.IsNotExist(err) {
+		return nil
+	}
+
+	return err
+}
+
+func (r *FileStateStore) path(nodeGroup string) string {
+	return filepath.Join(r.Dir, nodeGroup+".json")
+}
+
+//S3StateStore represents rollout state kept as one json object per node group in a bucket
+type S3StateStore struct {
+	AwsSession session.Session
+	Bucket     string
+	Prefix     string
+}
+
+//Load represents
+func (r *S3StateStore) Load(nodeGroup string) (*apiTypes.RolloutState, error) {
+	s3Svc := s3.New(&r.AwsSession)
+
+	input := s3.GetObjectInput{
+		Bucket: aws.String(r.Bucket),
+		Key:    aws.String(r.Prefix + nodeGroup + ".json"),
+	}
+
+	output, err := s3Svc.GetObject(&input)
+	if err != nil {
+		if aErr, ok := err.(awserr.Error); ok && aErr.Code() == s3.ErrCodeNoSuchKey {
+			return nil, nil
+		}
+		withAwsError(logrus.WithField(NodeGroupField, nodeGroup), err).Error("Failed to get rollout state")
+		return nil, err
+	}
+	defer output.Body.Close()
+
+	content, err := ioutil.ReadAll(output.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	return decodeRolloutState(content)
+}
+
+//Save represents
+func (r *S3StateStore) Save(state *apiTypes.RolloutState) error {
+	s3Svc := s3.New(&r.AwsSession)
+
+	content, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+
+	input := s3.PutObjectInput{
+		Bucket: aws.String(r.Bucket),
+		Key:    aws.String(r.Prefix + state.NodeGroup + ".json"),
+		Body:   bytes.NewReader(content),
+	}
+
+	_, err = s3Svc.PutObject(&input)
+	if err != nil {
+		withAwsError(logrus.WithField(NodeGroupField, state.NodeGroup), err).Error("Failed to save rollout state")
+	}
+
+	return err
+}
+
+//Delete represents
+func (r *S3StateStore) Delete(nodeGroup string) error {
+	s3Svc := s3.New(&r.AwsSession)
+
+	input := s3.DeleteObjectInput{
+		Bucket: aws.String(r.Bucket),
+		Key:    aws.String(r.Prefix + nodeGroup + ".json"),
+	}
+
+	_, err := s3Svc.DeleteObject(&input)
+	return err
+}
+
+//DynamoDBStateStore represents rollout state kept in a table keyed by the NodeGroup string attribute
+type DynamoDBStateStore struct {
+	AwsSession session.Session
+	Table      string
+}
+
+//Load represents
+func (r *DynamoDBStateStore) Load(nodeGroup string) (*apiTypes.RolloutState, error) {
+	dynamoSvc := dynamodb.New(&r.AwsSession)
+
+	input := dynamodb.GetItemInput{
+		TableName:      aws.String(r.Table),
+		Key:            map[string]*dynamodb.AttributeValue{"NodeGroup": {S: aws.String(nodeGroup)}},
+		ConsistentRead: aws.Bool(true),
+	}
+
+	output, err := dynamoSvc.GetItem(&input)
+	if err != nil {
+		withAwsError(logrus.WithField(NodeGroupField, nodeGroup), err).Error("Failed to get rollout state")
+		return nil, err
+	}
+
+	if output.Item == nil || output.Item["State"] == nil {
+		return nil, nil
+	}
+
+	return decodeRolloutState([]byte(aws.StringValue(output.Item["State"].S)))
+}
+
+//Save represents
+func (r *DynamoDBStateStore) Save(state *apiTypes.RolloutState) error {
+	dynamoSvc := dynamodb.New(&r.AwsSession)
+
+	content, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+
+	input := dynamodb.PutItemInput{
+		TableName: aws.String(r.Table),
+		Item: map[string]*dynamodb.AttributeValue{
+			"NodeGroup": {S: aws.String(state.NodeGroup)},
+			"State":     {S: aws.String(string(content))},
+		},
+	}
+
+	_, err = dynamoSvc.PutItem(&input)
+	if err != nil {
+		withAwsError(logrus.WithField(NodeGroupField, state.NodeGroup), err).Error("Failed to save rollout state")
+	}
+
+	return err
+}
+
+//Delete represents
+func (r *DynamoDBStateStore) Delete(nodeGroup string) error {
+	dynamoSvc := dynamodb.New(&r.AwsSession)
+
+	input := dynamodb.DeleteItemInput{
+		TableName: aws.String(r.Table),
+		Key:       map[string]*dynamodb.AttributeValue{"NodeGroup": {S: aws.String(nodeGroup)}},
+	}
+
+	_, err := dynamoSvc.DeleteItem(&input)
+	return err
+}
+
+func decodeRolloutState(content []byte) (*apiTypes.RolloutState, error) {
+	state := apiTypes.RolloutState{}
+	if err := json.Unmarshal(content, &state); err != nil {
+		return nil, err
+	}
+
+	if state.Instances == nil {
+		state.Instances = make(map[string]string)
+	}
+
+	return &state, nil
+}
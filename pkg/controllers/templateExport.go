@@ -0,0 +1,375 @@
+package controllers
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"gopkg.in/yaml.v2"
+)
+
+var nonAlphanumericPattern = regexp.MustCompile(`[^A-Za-z0-9]+`)
+
+var terraformNamePattern = regexp.MustCompile(`[^A-Za-z0-9_-]`)
+
+//RenderCloudFormation represents the exported node groups as a CloudFormation template in yaml, a launch template and
+//an asg resource for each. The asg references the latest or default version of the launch template in the stack.
+func RenderCloudFormation(exports []*NodeGroupExport) ([]byte, error) {
+	resources := yaml.MapSlice{}
+	for _, v := range exports {
+		id := cloudFormationID(v.NodeGroup)
+
+		version := "LatestVersionNumber"
+		if aws.StringValue(v.Asg.LaunchTemplate.Version) == "$Default" {
+			version = "DefaultVersionNumber"
+		}
+
+		tags := []interface{}{}
+		for _, t := range v.Asg.Tags {
+			tags = append(tags, yaml.MapSlice{
+				{Key: "Key", Value: aws.StringValue(t.Key)},
+				{Key: "Value", Value: aws.StringValue(t.Value)},
+				{Key: "PropagateAtLaunch", Value: aws.BoolValue(t.PropagateAtLaunch)},
+			})
+		}
+
+		asg := yaml.MapSlice{
+			{Key: "AutoScalingGroupName", Value: aws.StringValue(v.Asg.AutoScalingGroupName)},
+			{Key: "MinSize", Value: strconv.FormatInt(aws.Int64Value(v.Asg.MinSize), 10)},
+			{Key: "MaxSize", Value: strconv.FormatInt(aws.Int64Value(v.Asg.MaxSize), 10)},
+			{Key: "DesiredCapacity", Value: strconv.FormatInt(aws.Int64Value(v.Asg.DesiredCapacity), 10)},
+			{Key: "VPCZoneIdentifier", Value: strings.Split(aws.StringValue(v.Asg.VPCZoneIdentifier), ",")},
+			{Key: "LaunchTemplate", Value: yaml.MapSlice{
+				{Key: "LaunchTemplateId", Value: map[string]string{"Ref": id + "LaunchTemplate"}},
+				{Key: "Version", Value: map[string][]string{"Fn::GetAtt": {id + "LaunchTemplate", version}}},
+			}},
+			{Key: "Tags", Value: tags},
+		}
+
+		resources = append(resources,
+			yaml.MapItem{Key: id + "LaunchTemplate", Value: yaml.MapSlice{
+				{Key: "Type", Value: "AWS::EC2::LaunchTemplate"},
+				{Key: "Properties", Value: cloudFormationLaunchTemplate(v.LaunchTemplate)},
+			}},
+			yaml.MapItem{Key: id + "AutoScalingGroup", Value: yaml.MapSlice{
+				{Key: "Type", Value: "AWS::AutoScaling::AutoScalingGroup"},
+				{Key: "Properties", Value: asg},
+			}},
+		)
+	}
+
+	return yaml.Marshal(yaml.MapSlice{
+		{Key: "AWSTemplateFormatVersion", Value: "2010-09-09"},
+		{Key: "Description", Value: "Node groups exported by aws-node-group-manager"},
+		{Key: "Resources", Value: resources},
+	})
+}
+
+//RenderTerraform represents the exported node groups as terraform aws_launch_template and aws_autoscaling_group
+//resources. New launch template versions become the default one, as they do when the manager applies them.
+func RenderTerraform(exports []*NodeGroupExport) []byte {
+	w := hclWriter{}
+	w.comment("node groups exported by aws-node-group-manager")
+
+	for _, v := range exports {
+		name := terraformName(v.NodeGroup)
+		data := v.LaunchTemplate.LaunchTemplateData
+
+		w.line("")
+		w.block(fmt.Sprintf("resource \"aws_launch_template\" %v", strconv.Quote(name)), func() {
+			w.attr("name", hclString(aws.StringValue(v.LaunchTemplate.LaunchTemplateName)))
+			w.attr("image_id", hclString(aws.StringValue(data.ImageId)))
+			w.attr("instance_type", hclString(aws.StringValue(data.InstanceType)))
+			w.attr("key_name", hclString(aws.StringValue(data.KeyName)))
+			w.attr("user_data", hclString(aws.StringValue(data.UserData)))
+			w.attr("update_default_version", "true")
+
+			if data.IamInstanceProfile != nil {
+				w.block("iam_instance_profile", func() {
+					w.attr("name", hclString(aws.StringValue(data.IamInstanceProfile.Name)))
+				})
+			}
+
+			for _, bdm := range data.BlockDeviceMappings {
+				w.block("block_device_mappings", func() {
+					w.attr("device_name", hclString(aws.StringValue(bdm.DeviceName)))
+					if bdm.Ebs != nil {
+						w.block("ebs", func() {
+							w.attr("volume_size", strconv.FormatInt(aws.Int64Value(bdm.Ebs.VolumeSize), 10))
+							w.attr("volume_type", hclString(aws.StringValue(bdm.Ebs.VolumeType)))
+						})
+					}
+				})
+			}
+
+			for _, ni := range data.NetworkInterfaces {
+				w.block("network_interfaces", func() {
+					w.attr("associate_public_ip_address", strconv.FormatBool(aws.BoolValue(ni.AssociatePublicIpAddress)))
+					w.attr("delete_on_termination", strconv.FormatBool(aws.BoolValue(ni.DeleteOnTermination)))
+					w.attr("device_index", strconv.FormatInt(aws.Int64Value(ni.DeviceIndex), 10))
+					w.attr("security_groups", hclList(aws.StringValueSlice(ni.Groups)))
+				})
+			}
+
+			for _, ts := range data.TagSpecifications {
+				w.block("tag_specifications", func() {
+					w.attr("resource_type", hclString(aws.StringValue(ts.ResourceType)))
+					w.attr("tags", w.hclTags(ts.Tags))
+				})
+			}
+
+			for _, ts := range v.LaunchTemplate.TagSpecifications {
+				w.attr("tags", w.hclTags(ts.Tags))
+			}
+		})
+
+		version := "latest_version"
+		if aws.StringValue(v.Asg.LaunchTemplate.Version) == "$Default" {
+			version = "default_version"
+		}
+
+		w.line("")
+		w.block(fmt.Sprintf("resource \"aws_autoscaling_group\" %v", strconv.Quote(name)), func() {
+			w.attr("name", hclString(aws.StringValue(v.Asg.AutoScalingGroupName)))
+			w.attr("min_size", strconv.FormatInt(aws.Int64Value(v.Asg.MinSize), 10))
+			w.attr("max_size", strconv.FormatInt(aws.Int64Value(v.Asg.MaxSize), 10))
+			w.attr("desired_capacity", strconv.FormatInt(aws.Int64Value(v.Asg.DesiredCapacity), 10))
+			w.attr("vpc_zone_identifier", hclList(strings.Split(aws.StringValue(v.Asg.VPCZoneIdentifier), ",")))
+
+			w.block("launch_template", func() {
+				w.attr("id", fmt.Sprintf("aws_launch_template.%v.id", name))
+				w.attr("version", fmt.Sprintf("aws_launch_template.%v.%v", name, version))
+			})
+
+			for _, t := range v.Asg.Tags {
+				w.block("tag", func() {
+					w.attr("key", hclString(aws.StringValue(t.Key)))
+					w.attr("value", hclString(aws.StringValue(t.Value)))
+					w.attr("propagate_at_launch", strconv.FormatBool(aws.BoolValue(t.PropagateAtLaunch)))
+				})
+			}
+		})
+	}
+
+	return w.buf.Bytes()
+}
+
+// cloudFormationLaunchTemplate maps the create request to the properties of an AWS::EC2::LaunchTemplate field by
+// field, the fields of the request left unset are left out
+func cloudFormationLaunchTemplate(input *ec2.CreateLaunchTemplateInput) yaml.MapSlice {
+	data := input.LaunchTemplateData
+
+	mappings := []interface{}{}
+	for _, v := range data.BlockDeviceMappings {
+		mapping := withProperty(yaml.MapSlice{}, "DeviceName", v.DeviceName)
+		if v.Ebs != nil {
+			ebs := withProperty(yaml.MapSlice{}, "VolumeSize", v.Ebs.VolumeSize)
+			mapping = append(mapping, yaml.MapItem{Key: "Ebs", Value: withProperty(ebs, "VolumeType", v.Ebs.VolumeType)})
+		}
+		mappings = append(mappings, mapping)
+	}
+
+	networkInterfaces := []interface{}{}
+	for _, v := range data.NetworkInterfaces {
+		networkInterface := withProperty(yaml.MapSlice{}, "AssociatePublicIpAddress", v.AssociatePublicIpAddress)
+		networkInterface = withProperty(networkInterface, "DeleteOnTermination", v.DeleteOnTermination)
+		networkInterface = withProperty(networkInterface, "DeviceIndex", v.DeviceIndex)
+		networkInterfaces = append(networkInterfaces, append(networkInterface, yaml.MapItem{Key: "Groups", Value: aws.StringValueSlice(v.Groups)}))
+	}
+
+	tagSpecifications := []interface{}{}
+	for _, v := range data.TagSpecifications {
+		tagSpecifications = append(tagSpecifications, cloudFormationTagSpecification(v.ResourceType, v.Tags))
+	}
+
+	properties := yaml.MapSlice{}
+	if len(mappings) > 0 {
+		properties = append(properties, yaml.MapItem{Key: "BlockDeviceMappings", Value: mappings})
+	}
+	if data.IamInstanceProfile != nil {
+		properties = append(properties, yaml.MapItem{Key: "IamInstanceProfile", Value: withProperty(yaml.MapSlice{}, "Name", data.IamInstanceProfile.Name)})
+	}
+	properties = withProperty(properties, "ImageId", data.ImageId)
+	properties = withProperty(properties, "InstanceType", data.InstanceType)
+	properties = withProperty(properties, "KeyName", data.KeyName)
+	if len(networkInterfaces) > 0 {
+		properties = append(properties, yaml.MapItem{Key: "NetworkInterfaces", Value: networkInterfaces})
+	}
+	if len(tagSpecifications) > 0 {
+		properties = append(properties, yaml.MapItem{Key: "TagSpecifications", Value: tagSpecifications})
+	}
+	properties = withProperty(properties, "UserData", data.UserData)
+
+	launchTemplate := yaml.MapSlice{
+		{Key: "LaunchTemplateData", Value: properties},
+		{Key: "LaunchTemplateName", Value: aws.StringValue(input.LaunchTemplateName)},
+	}
+	if len(input.TagSpecifications) > 0 {
+		tagSpecifications := []interface{}{}
+		for _, v := range input.TagSpecifications {
+			tagSpecifications = append(tagSpecifications, cloudFormationTagSpecification(v.ResourceType, v.Tags))
+		}
+		launchTemplate = append(launchTemplate, yaml.MapItem{Key: "TagSpecifications", Value: tagSpecifications})
+	}
+
+	return launchTemplate
+}
+
+func cloudFormationTagSpecification(resourceType *string, tags []*ec2.Tag) yaml.MapSlice {
+	items := []interface{}{}
+	for _, t := range tags {
+		items = append(items, yaml.MapSlice{
+			{Key: "Key", Value: aws.StringValue(t.Key)},
+			{Key: "Value", Value: aws.StringValue(t.Value)},
+		})
+	}
+
+	return yaml.MapSlice{{Key: "ResourceType", Value: aws.StringValue(resourceType)}, {Key: "Tags", Value: items}}
+}
+
+// withProperty appends the property when the field of the request is set
+func withProperty(properties yaml.MapSlice, key string, value interface{}) yaml.MapSlice {
+	switch v := value.(type) {
+	case *string:
+		if v == nil {
+			return properties
+		}
+		value = *v
+	case *int64:
+		if v == nil {
+			return properties
+		}
+		value = *v
+	case *bool:
+		if v == nil {
+			return properties
+		}
+		value = *v
+	}
+
+	return append(properties, yaml.MapItem{Key: key, Value: value})
+}
+
+// cloudFormationID turns a node group name into a logical id, kafka-dedicated becomes KafkaDedicated
+func cloudFormationID(nodeGroup string) string {
+	id := ""
+	for _, v := range nonAlphanumericPattern.Split(nodeGroup, -1) {
+		if v != "" {
+			id += strings.ToUpper(v[:1]) + v[1:]
+		}
+	}
+	if id == "" || (id[0] >= '0' && id[0] <= '9') {
+		id = "NodeGroup" + id
+	}
+
+	return id
+}
+
+// terraformName turns a node group name into a resource name, which starts with a letter or an underscore
+func terraformName(nodeGroup string) string {
+	name := terraformNamePattern.ReplaceAllString(nodeGroup, "_")
+	if name == "" || (name[0] >= '0' && name[0] <= '9') || name[0] == '-' {
+		name = "_" + name
+	}
+
+	return name
+}
+
+// hclWriter writes terraform configuration, blocks are indented by two spaces
+type hclWriter struct {
+	buf    bytes.Buffer
+	indent int
+}
+
+func (w *hclWriter) line(s string) {
+	if s == "" {
+		w.buf.WriteString("\n")
+		return
+	}
+
+	w.buf.WriteString(strings.Repeat("  ", w.indent) + s + "\n")
+}
+
+func (w *hclWriter) comment(s string) {
+	w.line("# " + s)
+}
+
+func (w *hclWriter) attr(name string, value string) {
+	w.line(name + " = " + value)
+}
+
+func (w *hclWriter) block(header string, body func()) {
+	w.line(header + " {")
+	w.indent++
+	body()
+	w.indent--
+	w.line("}")
+}
+
+// hclTags renders tags as a map, one key per line
+func (w *hclWriter) hclTags(tags []*ec2.Tag) string {
+	if len(tags) == 0 {
+		return "{}"
+	}
+
+	indent := strings.Repeat("  ", w.indent+1)
+	lines := []string{"{"}
+	for _, t := range tags {
+		lines = append(lines, indent+hclString(aws.StringValue(t.Key))+" = "+hclString(aws.StringValue(t.Value)))
+	}
+	lines = append(lines, strings.Repeat("  ", w.indent)+"}")
+
+	return strings.Join(lines, "\n")
+}
+
+// hclString quotes a string literal with the escapes of hcl, which has no \x, and doubles the $ and % of the ${ and %{
+// that would start a template sequence
+func hclString(s string) string {
+	var b strings.Builder
+	b.WriteString(`"`)
+	for i, r := range s {
+		switch r {
+		case '\\':
+			b.WriteString(`\\`)
+		case '"':
+			b.WriteString(`\"`)
+		case '\n':
+			b.WriteString(`\n`)
+		case '\r':
+			b.WriteString(`\r`)
+		case '\t':
+			b.WriteString(`\t`)
+		case '$', '%':
+			b.WriteRune(r)
+			if strings.HasPrefix(s[i+1:], "{") {
+				b.WriteRune(r)
+			}
+		default:
+			switch {
+			case unicode.IsPrint(r):
+				b.WriteRune(r)
+			case r > 0xffff:
+				fmt.Fprintf(&b, `\U%08x`, r)
+			default:
+				fmt.Fprintf(&b, `\u%04x`, r)
+			}
+		}
+	}
+	b.WriteString(`"`)
+
+	return b.String()
+}
+
+func hclList(values []string) string {
+	quoted := []string{}
+	for _, v := range values {
+		quoted = append(quoted, hclString(v))
+	}
+
+	return "[" + strings.Join(quoted, ", ") + "]"
+}
@@ -0,0 +1,116 @@
+package controllers
+
+import (
+	"flag"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	apiTypes "github.com/anyo/aws-node-group-manager/pkg/apis"
+
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+var updateGolden = flag.Bool("update", false, "rewrite the golden files of the tests")
+
+// testExports are two node groups built the way ExportNodeGroup builds them, one pinned to the latest version
+func testExports() []*NodeGroupExport {
+	exports := []*NodeGroupExport{}
+	for _, v := range []struct {
+		name    string
+		version string
+	}{{"workers", latestTemplateVersion}, {"kafka-dedicated", "$Default"}} {
+		launchTemplate := apiTypes.LaunchTemplateOptions{
+			Name:               v.name + "-lt",
+			AmiID:              "ami-0123456789abcdef0",
+			InstanceType:       "m5.large",
+			KeyName:            "ops",
+			SecurityGroups:     aws.StringSlice([]string{"sg-1", "sg-2"}),
+			UserData:           "#!/bin/bash\n/etc/eks/bootstrap.sh tally",
+			IamInstanceProfile: "workers-profile",
+			Tags:               map[string]string{"team": "data", "note": "say \"hi\"\n${not} %{a}\x01"},
+			ResourceTags:       map[string]string{"owner": "manager"},
+			EbsVolume:          apiTypes.EbsVolume{VolumeType: "gp3", VolumeSize: 50},
+		}
+		asg := apiTypes.AutoScalingGroupOptions{
+			Name:                  v.name,
+			Subnets:               "subnet-1,subnet-2",
+			DesiredInstances:      2,
+			MaxInstances:          3,
+			MinInstances:          1,
+			LaunchTemplateName:    launchTemplate.Name,
+			LaunchTemplateVersion: v.version,
+			Tags:                  map[string]string{"kubernetes.io/cluster/tally": "owned", "env": "prod"},
+		}
+
+		export := NodeGroupExport{
+			NodeGroup:      v.name,
+			LaunchTemplate: (&Ec2Service{}).getCreateLaunchTemplateInput(&launchTemplate),
+			Asg:            (&AsgService{}).getCreateAsgInput(&asg),
+		}
+		for _, t := range export.LaunchTemplate.TagSpecifications {
+			sortEc2Tags(t.Tags)
+		}
+		for _, t := range export.LaunchTemplate.LaunchTemplateData.TagSpecifications {
+			sortEc2Tags(t.Tags)
+		}
+		sort.Slice(export.Asg.Tags, func(i, j int) bool {
+			return aws.StringValue(export.Asg.Tags[i].Key) < aws.StringValue(export.Asg.Tags[j].Key)
+		})
+		exports = append(exports, &export)
+	}
+
+	return exports
+}
+
+// assertGolden compares the rendered template to the golden file, rewritten instead with -update
+func assertGolden(t *testing.T, name string, actual []byte) {
+	path := filepath.Join("testdata", name)
+	if *updateGolden {
+		if err := ioutil.WriteFile(path, actual, 0644); err != nil {
+			t.Fatalf("write %v: %v", path, err)
+		}
+	}
+
+	expected, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("read %v: %v", path, err)
+	}
+	if string(actual) != string(expected) {
+		t.Fatalf("rendered\n%s\nexpected, as in %v\n%s", actual, path, expected)
+	}
+}
+
+func TestRenderCloudFormation(t *testing.T) {
+	content, err := RenderCloudFormation(testExports())
+	if err != nil {
+		t.Fatalf("render: %v", err)
+	}
+
+	assertGolden(t, "export.cloudformation.yaml", content)
+}
+
+func TestRenderTerraform(t *testing.T) {
+	assertGolden(t, "export.tf", RenderTerraform(testExports()))
+}
+
+func TestHclString(t *testing.T) {
+	tests := map[string]string{
+		"plain":                   `"plain"`,
+		`say "hi" \o/`:            `"say \"hi\" \\o/"`,
+		"a\nb\rc\td":              `"a\nb\rc\td"`,
+		"${var.x} %{if}":          `"$${var.x} %%{if}"`,
+		"$ % $$ %%":               `"$ % $$ %%"`,
+		"bell\x07 del\x7f":        `"bell\u0007 del\u007f"`,
+		"nbsp\u00a0 été":          `"nbsp\u00a0 été"`,
+		"zero width\u200b":        `"zero width\u200b"`,
+		"supplementary\U000e0001": `"supplementary\U000e0001"`,
+	}
+
+	for value, expected := range tests {
+		if actual := hclString(value); actual != expected {
+			t.Fatalf("hcl string of %q is %v, expected %v", value, actual, expected)
+		}
+	}
+}
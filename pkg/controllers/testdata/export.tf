@@ -0,0 +1,129 @@
+# node groups exported by aws-node-group-manager
+
+resource "aws_launch_template" "workers" {
+  name = "workers-lt"
+  image_id = "ami-0123456789abcdef0"
+  instance_type = "m5.large"
+  key_name = "ops"
+  user_data = "IyEvYmluL2Jhc2gKL2V0Yy9la3MvYm9vdHN0cmFwLnNoIHRhbGx5"
+  update_default_version = true
+  iam_instance_profile {
+    name = "workers-profile"
+  }
+  block_device_mappings {
+    device_name = "/dev/xvda"
+    ebs {
+      volume_size = 50
+      volume_type = "gp3"
+    }
+  }
+  network_interfaces {
+    associate_public_ip_address = false
+    delete_on_termination = true
+    device_index = 0
+    security_groups = ["sg-1", "sg-2"]
+  }
+  tag_specifications {
+    resource_type = "instance"
+    tags = {
+      "note" = "say \"hi\"\n$${not} %%{a}\u0001"
+      "team" = "data"
+    }
+  }
+  tag_specifications {
+    resource_type = "volume"
+    tags = {
+      "note" = "say \"hi\"\n$${not} %%{a}\u0001"
+      "team" = "data"
+    }
+  }
+  tags = {
+    "owner" = "manager"
+  }
+}
+
+resource "aws_autoscaling_group" "workers" {
+  name = "workers"
+  min_size = 1
+  max_size = 3
+  desired_capacity = 2
+  vpc_zone_identifier = ["subnet-1", "subnet-2"]
+  launch_template {
+    id = aws_launch_template.workers.id
+    version = aws_launch_template.workers.latest_version
+  }
+  tag {
+    key = "env"
+    value = "prod"
+    propagate_at_launch = true
+  }
+  tag {
+    key = "kubernetes.io/cluster/tally"
+    value = "owned"
+    propagate_at_launch = true
+  }
+}
+
+resource "aws_launch_template" "kafka-dedicated" {
+  name = "kafka-dedicated-lt"
+  image_id = "ami-0123456789abcdef0"
+  instance_type = "m5.large"
+  key_name = "ops"
+  user_data = "IyEvYmluL2Jhc2gKL2V0Yy9la3MvYm9vdHN0cmFwLnNoIHRhbGx5"
+  update_default_version = true
+  iam_instance_profile {
+    name = "workers-profile"
+  }
+  block_device_mappings {
+    device_name = "/dev/xvda"
+    ebs {
+      volume_size = 50
+      volume_type = "gp3"
+    }
+  }
+  network_interfaces {
+    associate_public_ip_address = false
+    delete_on_termination = true
+    device_index = 0
+    security_groups = ["sg-1", "sg-2"]
+  }
+  tag_specifications {
+    resource_type = "instance"
+    tags = {
+      "note" = "say \"hi\"\n$${not} %%{a}\u0001"
+      "team" = "data"
+    }
+  }
+  tag_specifications {
+    resource_type = "volume"
+    tags = {
+      "note" = "say \"hi\"\n$${not} %%{a}\u0001"
+      "team" = "data"
+    }
+  }
+  tags = {
+    "owner" = "manager"
+  }
+}
+
+resource "aws_autoscaling_group" "kafka-dedicated" {
+  name = "kafka-dedicated"
+  min_size = 1
+  max_size = 3
+  desired_capacity = 2
+  vpc_zone_identifier = ["subnet-1", "subnet-2"]
+  launch_template {
+    id = aws_launch_template.kafka-dedicated.id
+    version = aws_launch_template.kafka-dedicated.default_version
+  }
+  tag {
+    key = "env"
+    value = "prod"
+    propagate_at_launch = true
+  }
+  tag {
+    key = "kubernetes.io/cluster/tally"
+    value = "owned"
+    propagate_at_launch = true
+  }
+}
@@ -0,0 +1,109 @@
+package controllers
+
+import (
+	"fmt"
+
+	apiTypes "github.com/anyo/aws-node-group-manager/pkg/apis"
+
+	"github.com/aws/aws-sdk-go/aws/session"
+)
+
+// reservedAsgTags counts the tags the manager puts on an asg next to the configured ones: ownership, config hash,
+// and the blue/green color and candidate tags
+const reservedAsgTags = 6
+
+//ValidateConfig represents checking every node group of a config without calling aws: the node group settings,
+//the state, lock, audit and notification settings, and the dependencies between node groups
+func ValidateConfig(models []apiTypes.OperatorModel) apiTypes.ValidationErrors {
+	errs := apiTypes.ValidationErrors{}
+	for i := range models {
+		errs = append(errs, ValidateNodeGroup(&models[i])...)
+	}
+
+	if err := ValidateNodeGroupDependencies(models); err != nil {
+		errs = append(errs, err)
+	}
+
+	return errs
+}
+
+//ValidateNodeGroup represents checking the settings of one node group without calling aws, leaving out its
+//dependencies
+func ValidateNodeGroup(model *apiTypes.OperatorModel) apiTypes.ValidationErrors {
+	errs := model.Validate(reservedAsgTags)
+
+	// the constructors validate their settings without doing any i/o, an empty session is enough
+	if _, err := NewStateStore(model.StateOptions, session.Session{}); err != nil {
+		errs = append(errs, nodeGroupError(model, "state", err))
+	}
+	if _, err := NewLocker(model.LockOptions, session.Session{}, KubeService{}, LockHolder()); err != nil {
+		errs = append(errs, nodeGroupError(model, "lock", err))
+	}
+	if _, err := NewAuditSink(model.AuditOptions, session.Session{}, model.NodeGroupName()); err != nil {
+		errs = append(errs, nodeGroupError(model, "audit", err))
+	}
+	if _, err := NewNotifications(model.NotificationOptions, session.Session{}, model.NodeGroupName()); err != nil {
+		errs = append(errs, nodeGroupError(model, "notifications", err))
+	}
+
+	return errs
+}
+
+//ValidateOperatorNodeGroup represents rejecting the settings a NodeGroup resource cannot use: files do not outlive the
+//pod of the operator and are not shared between its replicas, rollout state and locks have to be kept elsewhere
+func ValidateOperatorNodeGroup(model *apiTypes.OperatorModel) apiTypes.ValidationErrors {
+	errs := apiTypes.ValidationErrors{}
+	if model.StateOptions.Backend == "" || model.StateOptions.Backend == "file" {
+		errs = append(errs, nodeGroupError(model, "state.backend", fmt.Errorf("the operator keeps rollout state in s3 or dynamodb")))
+	}
+	if model.LockOptions.Backend == "" || model.LockOptions.Backend == "file" {
+		errs = append(errs, nodeGroupError(model, "lock.backend", fmt.Errorf("the operator locks node groups with kubernetes or dynamodb")))
+	}
+
+	return errs
+}
+
+//ValidateWithoutAPI represents rejecting the settings that need the api to take effect, an ami waiting for approval
+//would never be approved
+func ValidateWithoutAPI(models []apiTypes.OperatorModel) apiTypes.ValidationErrors {
+	errs := apiTypes.ValidationErrors{}
+	for i := range models {
+		if models[i].RequireAmiApproval {
+			errs = append(errs, nodeGroupError(&models[i], "requireAmiApproval", fmt.Errorf("amis are approved through the api, serve it with -api-addr")))
+		}
+	}
+
+	return errs
+}
+
+//ValidateInstanceTypes represents checking the instance type of every node group exists in the region
+func (r *ReconcilerService) ValidateInstanceTypes(models []apiTypes.OperatorModel) apiTypes.ValidationErrors {
+	errs := apiTypes.ValidationErrors{}
+	exists := make(map[string]bool)
+	for i := range models {
+		model := &models[i]
+		instanceType := model.LaunchTemplateOptions.InstanceType
+		if instanceType == "" {
+			continue
+		}
+
+		if _, ok := exists[instanceType]; !ok {
+			found, err := r.Ec2Service.InstanceTypeExists(instanceType)
+			if err != nil {
+				errs = append(errs, nodeGroupError(model, "ec2.launchTemplate.instanceType", err))
+				continue
+			}
+			exists[instanceType] = found
+		}
+
+		if !exists[instanceType] {
+			errs = append(errs, nodeGroupError(model, "ec2.launchTemplate.instanceType", fmt.Errorf("%v does not exist in the region", instanceType)))
+		}
+	}
+
+	return errs
+}
+
+func nodeGroupError(model *apiTypes.OperatorModel, field string, err error) error {
+	return fmt.Errorf("node group %v: %v: %v", model.NodeGroupName(), field, err)
+}